@@ -6,9 +6,14 @@ import (
 
 // Config holds all server configuration
 type Config struct {
-	Port       string
-	DatabaseURL string
-	Host       string
+	Port          string
+	DatabaseURL   string
+	Host          string
+	SessionStore  string // "postgres" (default) or "memory"
+	Environment   string // Name of this deployment, matched against API key environments
+	PreviewSecret string // Signs preview tokens; replicas must share it
+	ImageSecret   string // Signs image transformation URLs; replicas must share it
+	ImageURL      string // Public URL base of the /images/ endpoint
 }
 
 // LoadConfig loads configuration from environment variables
@@ -28,10 +33,30 @@ func LoadConfig() *Config {
 		host = ""
 	}
 
+	sessionStore := os.Getenv("SESSION_STORE")
+	if sessionStore == "" {
+		sessionStore = "postgres"
+	}
+
+	environment := os.Getenv("ENVIRONMENT")
+	if environment == "" {
+		environment = "development"
+	}
+
+	imageURL := os.Getenv("IMAGE_PUBLIC_URL")
+	if imageURL == "" {
+		imageURL = "/images"
+	}
+
 	return &Config{
-		Port:       port,
-		DatabaseURL: dbURL,
-		Host:       host,
+		Port:          port,
+		DatabaseURL:   dbURL,
+		Host:          host,
+		SessionStore:  sessionStore,
+		Environment:   environment,
+		PreviewSecret: os.Getenv("PREVIEW_SECRET"),
+		ImageSecret:   os.Getenv("IMAGE_SECRET"),
+		ImageURL:      imageURL,
 	}
 }
 
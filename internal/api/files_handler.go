@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gofrik/internal/storage"
+)
+
+// FilesHandler serves stored files for backends without their own public URL
+type FilesHandler struct {
+	backend storage.Backend
+	prefix  string
+}
+
+// NewFilesHandler creates a handler serving the files of backend under prefix, such as "/files/"
+func NewFilesHandler(backend storage.Backend, prefix string) *FilesHandler {
+	return &FilesHandler{
+		backend: backend,
+		prefix:  prefix,
+	}
+}
+
+// ServeHTTP handles the file request
+func (h *FilesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Internal files are only read through the server, e.g. variants through
+	// their signed URLs
+	key := strings.TrimPrefix(r.URL.Path, h.prefix)
+	if storage.IsInternalKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+	body, object, err := h.backend.Get(r.Context(), key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to serve file %s: %v", key, err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer body.Close()
+
+	// Uploads are not trusted: keep browsers from sniffing other types and
+	// from running scripts in SVG files served from this origin
+	w.Header().Set("Content-Type", object.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		// Handles ranges and conditional requests
+		http.ServeContent(w, r, key, object.ModTime, seeker)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, body)
+}
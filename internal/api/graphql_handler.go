@@ -3,43 +3,59 @@ package api
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"log"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"gofrik/internal/auth"
 	gofrikGraphQL "gofrik/internal/graphql"
+	"gofrik/internal/imaging"
+	"gofrik/internal/models"
+	"gofrik/internal/storage"
 
 	"github.com/graphql-go/handler"
 )
 
+// apiKeyTouchInterval limits how often API key last-used timestamps are written
+const apiKeyTouchInterval = time.Minute
+
+// schemaSyncInterval is how often the schema version is checked for content
+// type changes made through other servers
+const schemaSyncInterval = 5 * time.Second
+
 type GraphQLHandler struct {
-	db      *sql.DB
-	auth    *auth.Middleware
-	handler *handler.Handler
-}
+	db          *sql.DB
+	auth        *auth.Middleware
+	preview     *auth.PreviewSigner
+	schema      *gofrikGraphQL.Schema
+	environment string
 
-func NewGraphQLHandler(db *sql.DB) (*GraphQLHandler, error) {
-	authMW := auth.NewMiddleware()
+	// handler serves the schema built from schemaVersion
+	mu            sync.Mutex
+	handler       *handler.Handler
+	schemaVersion int64
+}
 
+// NewGraphQLHandler creates the GraphQL handler and keeps its schema in sync
+// with the content types in the database until ctx is cancelled
+func NewGraphQLHandler(ctx context.Context, db *sql.DB, authMW *auth.Middleware, preview *auth.PreviewSigner, storageClient *storage.Storage, images *imaging.Signer, environment string) (*GraphQLHandler, error) {
 	// Create GraphQL schema
-	schema, err := gofrikGraphQL.NewSchema(db, authMW)
+	schema, err := gofrikGraphQL.NewSchema(db, authMW, preview, storageClient, images)
 	if err != nil {
 		return nil, err
 	}
-
-	// Create GraphQL handler with GraphiQL enabled
-	gqlSchema := schema.GetSchema()
-	h := handler.New(&handler.Config{
-		Schema:     &gqlSchema,
-		Pretty:     true,
-		GraphiQL:   true,
-		Playground: true,
-	})
+	go schema.RunSync(ctx, schemaSyncInterval)
 
 	return &GraphQLHandler{
-		db:      db,
-		auth:    authMW,
-		handler: h,
+		db:          db,
+		auth:        authMW,
+		preview:     preview,
+		schema:      schema,
+		environment: environment,
 	}, nil
 }
 
@@ -47,20 +63,132 @@ func (h *GraphQLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Start with request context
 	ctx := r.Context()
 
+	// Record the client so sessions can show where they were created
+	ctx = context.WithValue(ctx, "client", auth.ClientInfo{
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	})
+
 	// Check for authentication token
-	authHeader := r.Header.Get("Authorization")
-	if authHeader != "" {
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) == 2 && parts[0] == "Bearer" {
-			token := parts[1]
-			if session, ok := h.auth.GetSession(token); ok {
-				// Add session to context
-				ctx = context.WithValue(ctx, "session", session)
-			}
+	ctx, err := withCaller(ctx, r, h.db, h.auth, h.environment)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// A preview token lets this request read the draft of one entry
+	if token := r.Header.Get("X-Preview-Token"); token != "" {
+		if grant, err := h.preview.Verify(token, time.Now().UTC()); err == nil {
+			ctx = context.WithValue(ctx, "preview", grant)
 		}
 	}
 
 	// Serve GraphQL
-	h.handler.ContextHandler(ctx, w, r)
+	h.currentHandler().ContextHandler(ctx, w, r)
+}
+
+// currentHandler returns the GraphQL handler (with GraphiQL enabled) of the
+// current schema, creating it once per schema version
+func (h *GraphQLHandler) currentHandler() *handler.Handler {
+	gqlSchema, version := h.schema.GetSchema()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.handler == nil || version != h.schemaVersion {
+		h.handler = handler.New(&handler.Config{
+			Schema:     &gqlSchema,
+			Pretty:     true,
+			GraphiQL:   true,
+			Playground: true,
+		})
+		h.schemaVersion = version
+	}
+	return h.handler
+}
+
+// errInvalidAPIKey is returned for API keys that are unknown, revoked,
+// expired, meant for another environment or created by a deactivated user
+var errInvalidAPIKey = errors.New("invalid API key")
+
+// withCaller adds the session or API key of the request's bearer token to ctx
+func withCaller(ctx context.Context, r *http.Request, db *sql.DB, authMW *auth.Middleware, environment string) (context.Context, error) {
+	session, key, err := authenticate(r, db, authMW, environment)
+	if err != nil {
+		return ctx, err
+	}
+	if key != nil {
+		// Add API key to context
+		ctx = context.WithValue(ctx, "apiKey", key)
+	} else if session != nil {
+		// Add session to context
+		ctx = context.WithValue(ctx, "session", session)
+	}
+	return ctx, nil
+}
+
+// authenticate returns the session or API key of the request's bearer token;
+// both are nil for anonymous requests and unknown session tokens. API keys
+// that cannot be used return errInvalidAPIKey rather than falling back to an
+// anonymous request, so that misconfigured clients notice.
+func authenticate(r *http.Request, db *sql.DB, authMW *auth.Middleware, environment string) (*auth.Session, *models.APIKey, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, nil, nil
+	}
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, nil, nil
+	}
+
+	token := parts[1]
+	if auth.IsAPIKey(token) {
+		key, ok := authenticateAPIKey(db, environment, token)
+		if !ok {
+			return nil, nil, errInvalidAPIKey
+		}
+		return nil, key, nil
+	}
+	if session, ok := authMW.GetSession(token); ok {
+		return session, nil, nil
+	}
+	return nil, nil, nil
 }
 
+// authenticateAPIKey looks up an API key and checks that it is usable in this environment
+func authenticateAPIKey(db *sql.DB, environment, token string) (*models.APIKey, bool) {
+	key, err := models.GetAPIKeyByHash(db, auth.HashToken(token))
+	if err != nil {
+		return nil, false
+	}
+
+	now := time.Now().UTC()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, false
+	}
+	if key.Environment != "" && key.Environment != environment {
+		return nil, false
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		if err := models.TouchAPIKey(db, key.ID, now); err != nil {
+			log.Printf("Failed to update API key last used time: %v", err)
+		}
+		key.LastUsedAt = &now
+	}
+
+	return key, true
+}
+
+// clientIP returns the client address, preferring the first X-Forwarded-For
+// hop when running behind a proxy. It is informational only.
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
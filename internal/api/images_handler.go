@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gofrik/internal/imaging"
+	"gofrik/internal/storage"
+)
+
+const (
+	// maxSourceBytes caps the size of originals read for transformation
+	maxSourceBytes = 32 << 20
+
+	// maxSourcePixels caps the dimensions of originals, so that small files
+	// declaring huge images cannot exhaust memory when decoded
+	maxSourcePixels = 50_000_000
+)
+
+// ImagesHandler serves transformed variants of stored images. Requests must
+// carry a signature from imaging.Signer; variants are generated once and
+// cached in storage.
+type ImagesHandler struct {
+	storage *storage.Storage
+	signer  *imaging.Signer
+	prefix  string
+}
+
+// NewImagesHandler creates a handler serving variants under prefix, such as "/images/"
+func NewImagesHandler(storage *storage.Storage, signer *imaging.Signer, prefix string) *ImagesHandler {
+	return &ImagesHandler{
+		storage: storage,
+		signer:  signer,
+		prefix:  prefix,
+	}
+}
+
+// ServeHTTP handles the image request
+func (h *ImagesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, h.prefix)
+	opts, err := imaging.ParseOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.signer.Verify(key, opts, r.URL.Query().Get("s")) {
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return
+	}
+	if opts.Format == "" {
+		opts.Format = imaging.FormatForKey(key)
+	}
+
+	ctx := r.Context()
+	backend := h.storage.Backend()
+	variantKey := storage.VariantKey(key, opts.CacheName())
+
+	// Serve the cached variant if there is one
+	cached, _, err := backend.Get(ctx, variantKey)
+	if err == nil {
+		defer cached.Close()
+		writeImageHeaders(w, opts.Format)
+		if r.Method != http.MethodHead {
+			io.Copy(w, cached)
+		}
+		return
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Failed to read image variant %s: %v", variantKey, err)
+	}
+
+	variant, status, err := h.generate(ctx, key, opts)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.Printf("Failed to transform image %s: %v", key, err)
+			http.Error(w, "Failed to transform image", status)
+			return
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// Later requests are served from the cache; failing to store the variant
+	// only costs regenerating it
+	if err := storage.PutPrivate(ctx, backend, variantKey, bytes.NewReader(variant), int64(len(variant)), imaging.ContentType(opts.Format)); err != nil {
+		log.Printf("Failed to cache image variant %s: %v", variantKey, err)
+	}
+
+	writeImageHeaders(w, opts.Format)
+	w.Header().Set("Content-Length", strconv.Itoa(len(variant)))
+	if r.Method != http.MethodHead {
+		w.Write(variant)
+	}
+}
+
+// generate decodes the original stored under key and encodes its variant,
+// returning the HTTP status to answer with when it fails
+func (h *ImagesHandler) generate(ctx context.Context, key string, opts imaging.Options) ([]byte, int, error) {
+	body, object, err := h.storage.Backend().Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, http.StatusNotFound, fmt.Errorf("image not found")
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+	defer body.Close()
+	if object.Size > maxSourceBytes {
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("image too large to transform")
+	}
+
+	source, err := io.ReadAll(io.LimitReader(body, maxSourceBytes+1))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if len(source) > maxSourceBytes {
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("image too large to transform")
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(source))
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("file is not a supported image")
+	}
+	if config.Width*config.Height > maxSourcePixels {
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("image too large to transform")
+	}
+	img, _, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("file is not a supported image")
+	}
+
+	var encoded bytes.Buffer
+	if err := imaging.Encode(&encoded, imaging.Transform(img, opts), opts.Format, opts.Quality); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return encoded.Bytes(), http.StatusOK, nil
+}
+
+// writeImageHeaders sets the headers of variant responses. Signed URLs always
+// name the same variant, so it can be cached indefinitely.
+func writeImageHeaders(w http.ResponseWriter, format string) {
+	w.Header().Set("Content-Type", imaging.ContentType(format))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
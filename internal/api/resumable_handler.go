@@ -0,0 +1,379 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gofrik/internal/auth"
+	"gofrik/internal/models"
+	"gofrik/internal/storage"
+)
+
+// tusVersion is the version of the tus protocol spoken by ResumableHandler
+const tusVersion = "1.0.0"
+
+const (
+	// resumableUploadTTL is how long an upload is kept after its last chunk
+	resumableUploadTTL = 24 * time.Hour
+
+	// uploadLockTTL is how long a chunk may take between writes to storage
+	// before another request may take over the upload
+	uploadLockTTL = 5 * time.Minute
+)
+
+// ResumableHandler receives large files in chunks, implementing the core tus
+// protocol (https://tus.io) with its creation, termination and expiration
+// extensions. Completed uploads are recorded in the media library.
+type ResumableHandler struct {
+	storage     *storage.Storage
+	db          *sql.DB
+	auth        *auth.Middleware
+	environment string
+	prefix      string
+}
+
+// NewResumableHandler creates a handler serving uploads under prefix, such as "/uploads/"
+func NewResumableHandler(storage *storage.Storage, db *sql.DB, authMW *auth.Middleware, environment, prefix string) *ResumableHandler {
+	return &ResumableHandler{
+		storage:     storage,
+		db:          db,
+		auth:        authMW,
+		environment: environment,
+		prefix:      prefix,
+	}
+}
+
+// ServeHTTP handles the upload request
+func (h *ResumableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	// Uploading needs the same access as the /upload endpoint
+	uploadedBy, status := authorizeUpload(r, h.db, h.auth, h.environment)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, h.prefix)
+	if id == "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.create(w, r, uploadedBy)
+		return
+	}
+	if strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		h.head(w, r, id, uploadedBy)
+	case http.MethodPatch:
+		h.patch(w, r, id, uploadedBy)
+	case http.MethodDelete:
+		h.terminate(w, r, id, uploadedBy)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// create starts an upload of Upload-Length bytes. Upload-Metadata holds the
+// filename and filetype of the file and optionally the alt, folder and
+// comma separated tags of its asset.
+func (h *ResumableHandler) create(w http.ResponseWriter, r *http.Request, uploadedBy *int) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "Upload-Defer-Length is not supported", http.StatusBadRequest)
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if size > storage.MaxResumableSize {
+		http.Error(w, "File too large: maximum size is 2GB", http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filename := metadata["filename"]
+	if filename == "" {
+		http.Error(w, "Upload-Metadata must include the filename", http.StatusBadRequest)
+		return
+	}
+
+	resumable, err := h.storage.StartResumable(r.Context(), filename, metadata["filetype"], size)
+	if err != nil {
+		if errors.Is(err, storage.ErrUploadRejected) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Upload error: %v", err)
+		http.Error(w, "Failed to start upload", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := auth.GenerateToken()
+	if err != nil {
+		log.Printf("Upload error: %v", err)
+		http.Error(w, "Failed to start upload", http.StatusInternalServerError)
+		return
+	}
+	upload := &models.ResumableUpload{
+		ID:         id,
+		Resumable:  *resumable,
+		Filename:   filename,
+		AltText:    strings.TrimSpace(metadata["alt"]),
+		Folder:     metadata["folder"],
+		Tags:       strings.Split(metadata["tags"], ","),
+		UploadedBy: uploadedBy,
+		ExpiresAt:  time.Now().UTC().Add(resumableUploadTTL),
+	}
+	if err := models.CreateResumableUpload(h.db, upload); err != nil {
+		log.Printf("Upload error: %v", err)
+		if err := h.storage.AbortResumable(context.Background(), resumable); err != nil {
+			log.Printf("Failed to abort upload %s: %v", resumable.Key, err)
+		}
+		http.Error(w, "Failed to start upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", h.prefix+upload.ID)
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head reports how many bytes of an upload were received, so that clients
+// know where to resume
+func (h *ResumableHandler) head(w http.ResponseWriter, r *http.Request, id string, uploadedBy *int) {
+	upload, err := models.GetResumableUpload(h.db, id, time.Now().UTC())
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	if !sameUploader(upload.UploadedBy, uploadedBy) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch appends a chunk at Upload-Offset. Once the last byte has arrived the
+// file is assembled and recorded as an asset, whose id is returned in the
+// X-Asset-Id header.
+func (h *ResumableHandler) patch(w http.ResponseWriter, r *http.Request, id string, uploadedBy *int) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := h.lock(id)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	defer func() {
+		if err := models.UnlockResumableUpload(h.db, id, upload.LockToken); err != nil {
+			log.Printf("Upload error: %v", err)
+		}
+	}()
+
+	if !sameUploader(upload.UploadedBy, uploadedBy) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if offset != upload.Offset {
+		http.Error(w, fmt.Sprintf("Upload-Offset must be %d", upload.Offset), http.StatusConflict)
+		return
+	}
+	if r.ContentLength > upload.Size-upload.Offset {
+		http.Error(w, "Chunk exceeds the Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Keep what was received when the client disconnects mid-chunk
+	ctx := context.WithoutCancel(r.Context())
+	err = h.storage.WriteResumable(ctx, &upload.Resumable, r.Body, func(*storage.Resumable) error {
+		now := time.Now().UTC()
+		return models.SaveResumableProgress(h.db, upload, now.Add(uploadLockTTL), now.Add(resumableUploadTTL), now)
+	})
+	if err != nil {
+		log.Printf("Upload %s stopped at offset %d: %v", id, upload.Offset, err)
+		if errors.Is(err, models.ErrUploadLocked) {
+			// The lock expired mid-chunk and another request took over
+			writeUploadError(w, err)
+			return
+		}
+		http.Error(w, "Failed to store chunk", http.StatusInternalServerError)
+		return
+	}
+
+	if upload.Complete() {
+		asset, err := h.finish(ctx, upload)
+		if err != nil {
+			log.Printf("Upload error: %v", err)
+			http.Error(w, "Failed to complete upload", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Asset-Id", strconv.Itoa(asset.ID))
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finish assembles a completely received upload and records it in the media
+// library. When assembling fails the upload is kept, so that an empty PATCH
+// at the final offset retries.
+func (h *ResumableHandler) finish(ctx context.Context, upload *models.ResumableUpload) (*models.Asset, error) {
+	uploaded, err := h.storage.FinishResumable(ctx, &upload.Resumable)
+	if err != nil {
+		return nil, err
+	}
+
+	asset := &models.Asset{
+		Key:        uploaded.Key,
+		Filename:   upload.Filename,
+		MimeType:   uploaded.ContentType,
+		Size:       uploaded.Size,
+		Width:      uploaded.Width,
+		Height:     uploaded.Height,
+		Checksum:   uploaded.Checksum,
+		AltText:    upload.AltText,
+		UploadedBy: upload.UploadedBy,
+		Folder:     upload.Folder,
+		Tags:       upload.Tags,
+	}
+	createErr := models.CreateAsset(h.db, asset)
+	if createErr != nil {
+		// Do not leave a file behind that the media library does not know about
+		if err := h.storage.DeleteFile(ctx, uploaded.URL); err != nil {
+			log.Printf("Failed to delete file %s: %v", uploaded.Key, err)
+		}
+	}
+
+	// The parts are gone either way, so the upload cannot be resumed
+	if err := models.DeleteResumableUpload(h.db, upload.ID); err != nil {
+		log.Printf("Upload error: %v", err)
+	}
+	if createErr != nil {
+		return nil, createErr
+	}
+	return asset, nil
+}
+
+// terminate discards an upload and everything received for it
+func (h *ResumableHandler) terminate(w http.ResponseWriter, r *http.Request, id string, uploadedBy *int) {
+	upload, err := h.lock(id)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	if !sameUploader(upload.UploadedBy, uploadedBy) {
+		if err := models.UnlockResumableUpload(h.db, id, upload.LockToken); err != nil {
+			log.Printf("Upload error: %v", err)
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	// A failed abort leaves the upload locked until the lock expires, after
+	// which it can be retried or is cleaned up once expired
+	if err := h.storage.AbortResumable(context.WithoutCancel(r.Context()), &upload.Resumable); err != nil {
+		log.Printf("Upload error: %v", err)
+		http.Error(w, "Failed to abort upload", http.StatusInternalServerError)
+		return
+	}
+	if err := models.DeleteResumableUpload(h.db, id); err != nil {
+		log.Printf("Upload error: %v", err)
+		http.Error(w, "Failed to abort upload", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lock reserves an upload for the request with a lock token of its own
+func (h *ResumableHandler) lock(id string) (*models.ResumableUpload, error) {
+	token, err := auth.GenerateToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	now := time.Now().UTC()
+	return models.LockResumableUpload(h.db, id, token, now, now.Add(uploadLockTTL))
+}
+
+// writeUploadError answers a request for an upload that could not be loaded
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrUploadNotFound):
+		http.Error(w, "Upload not found", http.StatusNotFound)
+	case errors.Is(err, models.ErrUploadLocked):
+		http.Error(w, "Upload is in use by another request", http.StatusLocked)
+	default:
+		log.Printf("Upload error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// sameUploader reports whether two uploader ids are the same user
+func sameUploader(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header: comma separated
+// pairs of a key and a base64 encoded value
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %q", fields[0])
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid Upload-Metadata")
+		}
+	}
+	return metadata, nil
+}
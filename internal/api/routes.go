@@ -1,10 +1,13 @@
 package api
 
 import (
+	"context"
 	"embed"
 	"html/template"
 	"log"
 	"net/http"
+
+	"gofrik/internal/storage"
 )
 
 //go:embed templates/*.html
@@ -21,12 +24,12 @@ func init() {
 }
 
 // addRoutes configures all HTTP routes
-func (s *Server) addRoutes(mux *http.ServeMux) error {
+func (s *Server) addRoutes(ctx context.Context, mux *http.ServeMux) error {
 	// Root page
 	mux.HandleFunc("/", s.handleRoot)
 
 	// GraphQL endpoint
-	graphQLHandler, err := NewGraphQLHandler(s.db)
+	graphQLHandler, err := NewGraphQLHandler(ctx, s.db, s.auth, s.preview, s.storage, s.images, s.config.Environment)
 	if err != nil {
 		return err
 	}
@@ -35,11 +38,31 @@ func (s *Server) addRoutes(mux *http.ServeMux) error {
 
 	// Upload endpoint (if storage is configured)
 	if s.storage != nil {
-		uploadHandler := NewUploadHandler(s.storage)
+		uploadHandler := NewUploadHandler(s.storage, s.db, s.auth, s.config.Environment)
 		mux.Handle("/upload", uploadHandler)
 		log.Printf("Upload endpoint configured at /upload")
 	}
 
+	// Resumable upload endpoint (if storage is configured)
+	if s.storage != nil {
+		mux.Handle("/uploads/", NewResumableHandler(s.storage, s.db, s.auth, s.config.Environment, "/uploads/"))
+		log.Printf("Resumable upload endpoint configured at /uploads/")
+	}
+
+	// Image transformation endpoint (if storage is configured)
+	if s.storage != nil {
+		mux.Handle("/images/", NewImagesHandler(s.storage, s.images, "/images/"))
+		log.Printf("Image endpoint configured at /images/")
+	}
+
+	// Files endpoint (if files are stored on the local disk)
+	if s.storage != nil {
+		if _, ok := s.storage.Backend().(*storage.LocalBackend); ok {
+			mux.Handle("/files/", NewFilesHandler(s.storage.Backend(), "/files/"))
+			log.Printf("Files endpoint configured at /files/")
+		}
+	}
+
 	// Health check endpoint
 	mux.HandleFunc("/health", s.handleHealth)
 
@@ -1,10 +1,13 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
 
+	"gofrik/internal/auth"
+	"gofrik/internal/imaging"
 	"gofrik/internal/storage"
 )
 
@@ -13,23 +16,48 @@ type Server struct {
 	config  *Config
 	db      *sql.DB
 	storage *storage.Storage
+	auth    *auth.Middleware
+	preview *auth.PreviewSigner
+	images  *imaging.Signer
 }
 
-// NewServer creates a new HTTP server with all dependencies
+// NewServer creates a new HTTP server with all dependencies. Background work
+// it starts, such as schema syncing, stops when ctx is cancelled.
 func NewServer(
+	ctx context.Context,
 	config *Config,
 	db *sql.DB,
 	storageClient *storage.Storage,
+	authMW *auth.Middleware,
 ) (http.Handler, error) {
+	if config.PreviewSecret == "" {
+		log.Printf("PREVIEW_SECRET is not set, preview tokens will stop working on restart")
+	}
+	previewSigner, err := auth.NewPreviewSigner([]byte(config.PreviewSecret))
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ImageSecret == "" {
+		log.Printf("IMAGE_SECRET is not set, image URLs will stop working on restart")
+	}
+	imageSigner, err := imaging.NewSigner([]byte(config.ImageSecret), config.ImageURL)
+	if err != nil {
+		return nil, err
+	}
+
 	srv := &Server{
 		config:  config,
 		db:      db,
 		storage: storageClient,
+		auth:    authMW,
+		preview: previewSigner,
+		images:  imageSigner,
 	}
 
 	// Create mux and add routes
 	mux := http.NewServeMux()
-	if err := srv.addRoutes(mux); err != nil {
+	if err := srv.addRoutes(ctx, mux); err != nil {
 		return nil, err
 	}
 
@@ -53,8 +81,11 @@ func loggingMiddleware(next http.Handler) http.Handler {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, HEAD, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Preview-Token, "+
+			"Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, "+
+			"Upload-Offset, Upload-Length, Upload-Expires, X-Asset-Id")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
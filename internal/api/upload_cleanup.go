@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"gofrik/internal/models"
+	"gofrik/internal/storage"
+)
+
+const (
+	// pendingAssetTTL is how long a direct upload may take to be completed
+	// before its asset and file are discarded
+	pendingAssetTTL = 24 * time.Hour
+
+	// cleanupBatchSize limits how many uploads a single pass discards
+	cleanupBatchSize = 100
+)
+
+// RunUploadCleanup discards abandoned uploads every interval until ctx is
+// cancelled: resumable uploads that expired, and direct uploads that were
+// never completed
+func RunUploadCleanup(ctx context.Context, db *sql.DB, storageClient *storage.Storage, interval time.Duration, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now().UTC()
+			cleanupResumableUploads(ctx, db, storageClient, now, logger)
+			cleanupPendingAssets(ctx, db, storageClient, now, logger)
+		}
+	}
+}
+
+// cleanupResumableUploads aborts expired resumable uploads, deleting the
+// parts stored for them
+func cleanupResumableUploads(ctx context.Context, db *sql.DB, storageClient *storage.Storage, now time.Time, logger *log.Logger) {
+	uploads, err := models.ListExpiredResumableUploads(db, now, cleanupBatchSize)
+	if err != nil {
+		logger.Printf("Failed to clean up expired uploads: %v", err)
+		return
+	}
+
+	deleted := 0
+	for _, upload := range uploads {
+		if err := storageClient.AbortResumable(ctx, &upload.Resumable); err != nil {
+			logger.Printf("Failed to abort upload %s: %v", upload.Key, err)
+			continue
+		}
+		if err := models.DeleteResumableUpload(db, upload.ID); err != nil {
+			logger.Printf("Failed to clean up expired uploads: %v", err)
+			continue
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		logger.Printf("Deleted %d expired uploads", deleted)
+	}
+}
+
+// cleanupPendingAssets deletes the assets, and any files, of direct uploads
+// that were not completed within pendingAssetTTL
+func cleanupPendingAssets(ctx context.Context, db *sql.DB, storageClient *storage.Storage, now time.Time, logger *log.Logger) {
+	assets, err := models.ListStalePendingAssets(db, now.Add(-pendingAssetTTL), cleanupBatchSize)
+	if err != nil {
+		logger.Printf("Failed to clean up pending assets: %v", err)
+		return
+	}
+
+	deleted := 0
+	for _, asset := range assets {
+		if err := storageClient.DiscardUpload(ctx, asset.Key); err != nil {
+			logger.Printf("Failed to delete file %s: %v", asset.Key, err)
+			continue
+		}
+		if err := models.DeleteAsset(db, asset.ID); err != nil {
+			logger.Printf("Failed to clean up pending assets: %v", err)
+			continue
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		logger.Printf("Deleted %d pending assets that were never uploaded", deleted)
+	}
+}
@@ -1,23 +1,34 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
+	"gofrik/internal/auth"
+	"gofrik/internal/models"
 	"gofrik/internal/storage"
 )
 
-// UploadHandler handles file uploads
+// UploadHandler handles file uploads and records them in the media library
 type UploadHandler struct {
-	storage *storage.Storage
+	storage     *storage.Storage
+	db          *sql.DB
+	auth        *auth.Middleware
+	environment string
 }
 
 // NewUploadHandler creates a new upload handler
-func NewUploadHandler(storage *storage.Storage) *UploadHandler {
+func NewUploadHandler(storage *storage.Storage, db *sql.DB, authMW *auth.Middleware, environment string) *UploadHandler {
 	return &UploadHandler{
-		storage: storage,
+		storage:     storage,
+		db:          db,
+		auth:        authMW,
+		environment: environment,
 	}
 }
 
@@ -29,6 +40,14 @@ func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Uploading needs a global create grant, or an API key with read_write
+	// access to every content type
+	uploadedBy, status := authorizeUpload(r, h.db, h.auth, h.environment)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
 	// Parse multipart form (max 10MB)
 	if err := r.ParseMultipartForm(10 << 20); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to parse form: %v", err), http.StatusBadRequest)
@@ -44,20 +63,79 @@ func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	// Upload the file
-	url, err := h.storage.UploadFile(r.Context(), file, header)
+	uploaded, err := h.storage.UploadFile(r.Context(), file, header)
 	if err != nil {
 		log.Printf("Upload error: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to upload file: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Return the URL as JSON
+	// Record the asset
+	asset := &models.Asset{
+		Key:        uploaded.Key,
+		Filename:   header.Filename,
+		MimeType:   uploaded.ContentType,
+		Size:       uploaded.Size,
+		Width:      uploaded.Width,
+		Height:     uploaded.Height,
+		Checksum:   uploaded.Checksum,
+		AltText:    strings.TrimSpace(r.FormValue("alt")),
+		UploadedBy: uploadedBy,
+		Folder:     r.FormValue("folder"),
+		Tags:       strings.Split(r.FormValue("tags"), ","),
+	}
+	if err := models.CreateAsset(h.db, asset); err != nil {
+		log.Printf("Upload error: %v", err)
+		// Do not leave a file behind that the media library does not know about
+		if err := h.storage.DeleteFile(context.Background(), uploaded.URL); err != nil {
+			log.Printf("Failed to delete file %s: %v", uploaded.Key, err)
+		}
+		http.Error(w, "Failed to record asset", http.StatusInternalServerError)
+		return
+	}
+
+	// Return the URL and the asset as JSON
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"url":      url,
+		"url":      uploaded.URL,
 		"filename": header.Filename,
-		"size":     header.Size,
+		"size":     uploaded.Size,
+		"asset":    asset,
 		"message":  "File uploaded successfully",
 	})
 }
 
+// authorizeUpload returns the user to record as the uploader and
+// http.StatusOK when the caller may upload files
+func authorizeUpload(r *http.Request, db *sql.DB, authMW *auth.Middleware, environment string) (*int, int) {
+	session, key, err := authenticate(r, db, authMW, environment)
+	switch {
+	case err != nil:
+		return nil, http.StatusUnauthorized
+	case key != nil:
+		if len(key.ContentTypeIDs) > 0 || key.Access != models.APIKeyAccessReadWrite || key.CreatedBy == nil {
+			return nil, http.StatusForbidden
+		}
+		allowed, err := models.HasPermission(db, *key.CreatedBy, models.PermCreate, nil)
+		if err != nil {
+			log.Printf("Upload error: %v", err)
+			return nil, http.StatusInternalServerError
+		}
+		if !allowed {
+			return nil, http.StatusForbidden
+		}
+		return key.CreatedBy, http.StatusOK
+	case session != nil:
+		allowed, err := models.HasPermission(db, session.UserID, models.PermCreate, nil)
+		if err != nil {
+			log.Printf("Upload error: %v", err)
+			return nil, http.StatusInternalServerError
+		}
+		if !allowed {
+			return nil, http.StatusForbidden
+		}
+		return &session.UserID, http.StatusOK
+	default:
+		return nil, http.StatusUnauthorized
+	}
+}
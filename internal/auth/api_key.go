@@ -0,0 +1,24 @@
+package auth
+
+import "strings"
+
+// APIKeyPrefix marks API keys so they can be told apart from session tokens
+const APIKeyPrefix = "gfk_"
+
+// apiKeyDisplayLength is how much of a key is kept in plaintext to identify it
+const apiKeyDisplayLength = 12
+
+// GenerateAPIKey returns a new API key and the prefix stored to identify it
+func GenerateAPIKey() (key, prefix string, err error) {
+	token, err := GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + strings.TrimRight(token, "=")
+	return key, key[:apiKeyDisplayLength], nil
+}
+
+// IsAPIKey reports whether a bearer token is an API key rather than a session token
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
@@ -1,30 +1,56 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
-	"sync"
+	"encoding/hex"
+	"errors"
+	"log"
 	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// sessionTTL is how long a session stays valid after login
+	sessionTTL = 24 * time.Hour
+
+	// touchInterval limits how often last-seen timestamps are written
+	touchInterval = time.Minute
 )
 
 type Middleware struct {
-	sessions map[string]*Session
-	mu       sync.RWMutex
+	store Store
 }
 
 type Session struct {
-	UserID    int
-	Email     string
-	ExpiresAt time.Time
+	ID         string
+	UserID     int
+	Email      string
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	UserAgent  string
+	IP         string
+	TokenHash  string
 }
 
-func NewMiddleware() *Middleware {
+// ClientInfo describes the client a session is created for
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+func NewMiddleware(store Store) *Middleware {
 	return &Middleware{
-		sessions: make(map[string]*Session),
+		store: store,
 	}
 }
 
-func generateToken() (string, error) {
+// GenerateToken returns a random URL-safe token
+func GenerateToken() (string, error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
 		return "", err
@@ -32,39 +58,124 @@ func generateToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
-func (am *Middleware) CreateSession(userID int, email string) (string, error) {
-	token, err := generateToken()
+// HashToken returns the hex-encoded SHA-256 of a token. Only hashes are
+// stored, so a leaked table cannot be used to hijack sessions.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (am *Middleware) CreateSession(userID int, email string, client ClientInfo) (string, error) {
+	token, err := GenerateToken()
 	if err != nil {
 		return "", err
 	}
 
-	am.mu.Lock()
-	defer am.mu.Unlock()
+	now := time.Now().UTC()
+	session := &Session{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		Email:      email,
+		ExpiresAt:  now.Add(sessionTTL),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		TokenHash:  HashToken(token),
+	}
 
-	am.sessions[token] = &Session{
-		UserID:    userID,
-		Email:     email,
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+	if err := am.store.Create(session); err != nil {
+		return "", err
 	}
 
 	return token, nil
 }
 
 func (am *Middleware) GetSession(token string) (*Session, bool) {
-	am.mu.RLock()
-	defer am.mu.RUnlock()
+	tokenHash := HashToken(token)
+
+	session, err := am.store.Get(tokenHash)
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			log.Printf("Failed to load session: %v", err)
+		}
+		return nil, false
+	}
 
-	session, ok := am.sessions[token]
-	if !ok || time.Now().After(session.ExpiresAt) {
+	now := time.Now().UTC()
+	if now.After(session.ExpiresAt) {
+		if err := am.store.Delete(tokenHash); err != nil {
+			log.Printf("Failed to delete expired session: %v", err)
+		}
 		return nil, false
 	}
 
+	if now.Sub(session.LastSeenAt) > touchInterval {
+		if err := am.store.Touch(tokenHash, now); err != nil {
+			log.Printf("Failed to update session last seen time: %v", err)
+		}
+		session.LastSeenAt = now
+	}
+
 	return session, true
 }
 
-func (am *Middleware) DeleteSession(token string) {
-	am.mu.Lock()
-	defer am.mu.Unlock()
-	delete(am.sessions, token)
+func (am *Middleware) DeleteSession(token string) error {
+	return am.store.Delete(HashToken(token))
 }
 
+// ListSessions returns the active sessions of a user, most recently used first
+func (am *Middleware) ListSessions(userID int) ([]*Session, error) {
+	sessions, err := am.store.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	active := sessions[:0]
+	for _, session := range sessions {
+		if now.Before(session.ExpiresAt) {
+			active = append(active, session)
+		}
+	}
+	return active, nil
+}
+
+// RevokeSession ends one session of a user
+func (am *Middleware) RevokeSession(userID int, sessionID string) error {
+	deleted, err := am.store.DeleteByID(userID, sessionID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAllSessions ends every session of a user and returns how many were ended
+func (am *Middleware) RevokeAllSessions(userID int) (int, error) {
+	return am.store.DeleteByUser(userID)
+}
+
+// RunCleanup deletes expired sessions every interval until ctx is cancelled
+func (am *Middleware) RunCleanup(ctx context.Context, interval time.Duration, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := am.store.DeleteExpired(time.Now().UTC())
+			if err != nil {
+				logger.Printf("Failed to clean up expired sessions: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				logger.Printf("Deleted %d expired sessions", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions in process memory. Sessions are lost on restart
+// and not shared between replicas, so it is meant for development and tests.
+type MemoryStore struct {
+	sessions map[string]*Session
+	mu       sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (ms *MemoryStore) Create(session *Session) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored := *session
+	ms.sessions[session.TokenHash] = &stored
+	return nil
+}
+
+func (ms *MemoryStore) Get(tokenHash string) (*Session, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	session, ok := ms.sessions[tokenHash]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	// Return a copy so callers cannot mutate the stored session
+	result := *session
+	return &result, nil
+}
+
+func (ms *MemoryStore) Touch(tokenHash string, lastSeenAt time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if session, ok := ms.sessions[tokenHash]; ok {
+		session.LastSeenAt = lastSeenAt
+	}
+	return nil
+}
+
+func (ms *MemoryStore) Delete(tokenHash string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.sessions, tokenHash)
+	return nil
+}
+
+func (ms *MemoryStore) DeleteExpired(now time.Time) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	deleted := 0
+	for tokenHash, session := range ms.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(ms.sessions, tokenHash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (ms *MemoryStore) ListByUser(userID int) ([]*Session, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var sessions []*Session
+	for _, session := range ms.sessions {
+		if session.UserID == userID {
+			result := *session
+			sessions = append(sessions, &result)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (ms *MemoryStore) DeleteByID(userID int, id string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for tokenHash, session := range ms.sessions {
+		if session.UserID == userID && session.ID == id {
+			delete(ms.sessions, tokenHash)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ms *MemoryStore) DeleteByUser(userID int) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	deleted := 0
+	for tokenHash, session := range ms.sessions {
+		if session.UserID == userID {
+			delete(ms.sessions, tokenHash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PostgresStore keeps sessions in the sessions table so they survive restarts
+// and are shared by every replica
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{
+		db: db,
+	}
+}
+
+func (ps *PostgresStore) Create(session *Session) error {
+	_, err := ps.db.Exec(
+		`INSERT INTO sessions (id, token_hash, user_id, expires_at, created_at, last_seen_at, user_agent, ip)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		session.ID, session.TokenHash, session.UserID, session.ExpiresAt, session.CreatedAt, session.LastSeenAt, session.UserAgent, session.IP,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStore) Get(tokenHash string) (*Session, error) {
+	var session Session
+	err := ps.db.QueryRow(
+		`SELECT s.id, s.token_hash, s.user_id, u.email, s.expires_at, s.created_at, s.last_seen_at, s.user_agent, s.ip
+		 FROM sessions s JOIN users u ON u.id = s.user_id
+		 WHERE s.token_hash = $1 AND u.active`,
+		tokenHash,
+	).Scan(&session.ID, &session.TokenHash, &session.UserID, &session.Email, &session.ExpiresAt, &session.CreatedAt, &session.LastSeenAt, &session.UserAgent, &session.IP)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return &session, nil
+}
+
+func (ps *PostgresStore) Touch(tokenHash string, lastSeenAt time.Time) error {
+	_, err := ps.db.Exec(`UPDATE sessions SET last_seen_at = $1 WHERE token_hash = $2`, lastSeenAt, tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStore) Delete(tokenHash string) error {
+	_, err := ps.db.Exec(`DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStore) DeleteExpired(now time.Time) (int, error) {
+	result, err := ps.db.Exec(`DELETE FROM sessions WHERE expires_at < $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted sessions: %w", err)
+	}
+	return int(deleted), nil
+}
+
+func (ps *PostgresStore) ListByUser(userID int) ([]*Session, error) {
+	rows, err := ps.db.Query(
+		`SELECT s.id, s.token_hash, s.user_id, u.email, s.expires_at, s.created_at, s.last_seen_at, s.user_agent, s.ip
+		 FROM sessions s JOIN users u ON u.id = s.user_id
+		 WHERE s.user_id = $1 ORDER BY s.last_seen_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.TokenHash, &session.UserID, &session.Email, &session.ExpiresAt, &session.CreatedAt, &session.LastSeenAt, &session.UserAgent, &session.IP); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (ps *PostgresStore) DeleteByID(userID int, id string) (bool, error) {
+	result, err := ps.db.Exec(`DELETE FROM sessions WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete session: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count deleted sessions: %w", err)
+	}
+	return deleted > 0, nil
+}
+
+func (ps *PostgresStore) DeleteByUser(userID int) (int, error) {
+	result, err := ps.db.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted sessions: %w", err)
+	}
+	return int(deleted), nil
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// PreviewTokenPrefix marks preview tokens so they can be told apart from other tokens
+const PreviewTokenPrefix = "gfp_"
+
+// ErrInvalidPreviewToken is returned for malformed, forged or expired preview tokens
+var ErrInvalidPreviewToken = errors.New("invalid or expired preview token")
+
+// PreviewGrant is what a preview token allows: reading the draft of one entry,
+// and with References the drafts of the entries it references, until it expires
+type PreviewGrant struct {
+	EntryID    int       `json:"e"`
+	References bool      `json:"r,omitempty"`
+	ExpiresAt  time.Time `json:"x"`
+}
+
+// PreviewSigner issues and verifies preview tokens. Tokens are not stored;
+// they are HMAC-SHA256 signed, so every replica sharing the secret accepts them.
+type PreviewSigner struct {
+	secret []byte
+}
+
+// NewPreviewSigner creates a signer. An empty secret is replaced by a random
+// one, in which case tokens stop working on restart and are not accepted by
+// other replicas.
+func NewPreviewSigner(secret []byte) (*PreviewSigner, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &PreviewSigner{secret: secret}, nil
+}
+
+// Sign returns a token for grant
+func (ps *PreviewSigner) Sign(grant PreviewGrant) (string, error) {
+	grant.ExpiresAt = grant.ExpiresAt.UTC().Truncate(time.Second)
+	payload, err := json.Marshal(grant)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return PreviewTokenPrefix + encoded + "." + ps.signature(encoded), nil
+}
+
+// Verify checks a token's signature and expiry and returns its grant
+func (ps *PreviewSigner) Verify(token string, now time.Time) (*PreviewGrant, error) {
+	encoded, signature, ok := strings.Cut(strings.TrimPrefix(token, PreviewTokenPrefix), ".")
+	if !ok || !strings.HasPrefix(token, PreviewTokenPrefix) {
+		return nil, ErrInvalidPreviewToken
+	}
+	if !hmac.Equal([]byte(signature), []byte(ps.signature(encoded))) {
+		return nil, ErrInvalidPreviewToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidPreviewToken
+	}
+	var grant PreviewGrant
+	if err := json.Unmarshal(payload, &grant); err != nil {
+		return nil, ErrInvalidPreviewToken
+	}
+	if !now.Before(grant.ExpiresAt) {
+		return nil, ErrInvalidPreviewToken
+	}
+	return &grant, nil
+}
+
+func (ps *PreviewSigner) signature(encoded string) string {
+	mac := hmac.New(sha256.New, ps.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
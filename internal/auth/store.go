@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSessionNotFound is returned by stores when no session matches a token hash
+var ErrSessionNotFound = errors.New("session not found")
+
+// Store persists sessions. Sessions are looked up by the hash of their token;
+// plaintext tokens never reach a store.
+type Store interface {
+	Create(session *Session) error
+	Get(tokenHash string) (*Session, error)
+	Touch(tokenHash string, lastSeenAt time.Time) error
+	Delete(tokenHash string) error
+	DeleteExpired(now time.Time) (int, error)
+
+	// ListByUser returns the sessions of a user, most recently used first
+	ListByUser(userID int) ([]*Session, error)
+	// DeleteByID deletes one session of a user, reporting whether it existed
+	DeleteByID(userID int, id string) (bool, error)
+	// DeleteByUser deletes every session of a user
+	DeleteByUser(userID int) (int, error)
+}
@@ -54,10 +54,389 @@ func Migrate(db *sql.DB) error {
 			published_at TIMESTAMP
 		)`,
 
+		// User roles and deactivation; existing users predate roles and keep full access
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(50) NOT NULL DEFAULT 'admin'`,
+		`ALTER TABLE users ALTER COLUMN role SET DEFAULT 'viewer'`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT TRUE`,
+
+		// Invitations table (tokens are stored as SHA-256 hashes)
+		`CREATE TABLE IF NOT EXISTS invitations (
+			id SERIAL PRIMARY KEY,
+			email VARCHAR(255) NOT NULL,
+			role VARCHAR(50) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			expires_at TIMESTAMP NOT NULL,
+			accepted_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Roles and their permissions; a NULL content_type_id grants the permission on every content type
+		`CREATE TABLE IF NOT EXISTS roles (
+			name VARCHAR(50) PRIMARY KEY,
+			description TEXT NOT NULL DEFAULT '',
+			builtin BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS role_permissions (
+			id SERIAL PRIMARY KEY,
+			role VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+			permission VARCHAR(50) NOT NULL,
+			content_type_id INTEGER REFERENCES content_types(id) ON DELETE CASCADE
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_role_permissions_unique 
+			ON role_permissions(role, permission, COALESCE(content_type_id, 0))`,
+
+		// Built-in roles; default permissions are only seeded when the role is first created
+		// so later changes made by admins are kept
+		`WITH inserted AS (
+			INSERT INTO roles (name, description, builtin) VALUES ('admin', 'Full access, including users and roles', TRUE)
+			ON CONFLICT DO NOTHING RETURNING name
+		) INSERT INTO role_permissions (role, permission)
+		  SELECT name, p FROM inserted, unnest(ARRAY['read', 'create', 'update', 'publish', 'delete', 'manage_schema']) AS p`,
+		`WITH inserted AS (
+			INSERT INTO roles (name, description, builtin) VALUES ('editor', 'Manage and publish all content', TRUE)
+			ON CONFLICT DO NOTHING RETURNING name
+		) INSERT INTO role_permissions (role, permission)
+		  SELECT name, p FROM inserted, unnest(ARRAY['read', 'create', 'update', 'publish', 'delete']) AS p`,
+		`WITH inserted AS (
+			INSERT INTO roles (name, description, builtin) VALUES ('author', 'Write content without publishing it', TRUE)
+			ON CONFLICT DO NOTHING RETURNING name
+		) INSERT INTO role_permissions (role, permission)
+		  SELECT name, p FROM inserted, unnest(ARRAY['read', 'create', 'update']) AS p`,
+		`WITH inserted AS (
+			INSERT INTO roles (name, description, builtin) VALUES ('viewer', 'Read-only access to content', TRUE)
+			ON CONFLICT DO NOTHING RETURNING name
+		) INSERT INTO role_permissions (role, permission)
+		  SELECT name, p FROM inserted, unnest(ARRAY['read']) AS p`,
+
+		// API keys for server-to-server access; only key hashes are stored
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			prefix VARCHAR(20) NOT NULL,
+			key_hash VARCHAR(64) UNIQUE NOT NULL,
+			access VARCHAR(20) NOT NULL DEFAULT 'read',
+			environment VARCHAR(50) NOT NULL DEFAULT '',
+			content_type_ids INTEGER[] NOT NULL DEFAULT '{}',
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMP,
+			expires_at TIMESTAMP,
+			revoked_at TIMESTAMP
+		)`,
+
+		// Sessions table (tokens are stored as SHA-256 hashes)
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id UUID PRIMARY KEY,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip VARCHAR(45) NOT NULL DEFAULT ''
+		)`,
+
+		// Entry statuses are limited to draft, published and archived; earlier
+		// free-form statuses become drafts
+		`UPDATE content_entries SET status = 'draft' 
+			WHERE status IS NULL OR status NOT IN ('draft', 'published', 'archived')`,
+		`UPDATE content_entries SET published_at = updated_at 
+			WHERE status = 'published' AND published_at IS NULL`,
+		`ALTER TABLE content_entries ALTER COLUMN status SET NOT NULL`,
+		`DO $$ BEGIN
+			ALTER TABLE content_entries ADD CONSTRAINT content_entries_status_check 
+				CHECK (status IN ('draft', 'published', 'archived'));
+		EXCEPTION WHEN duplicate_object THEN NULL;
+		END $$`,
+
+		// Scheduled publishing
+		`ALTER TABLE content_entries ADD COLUMN IF NOT EXISTS publish_at TIMESTAMP`,
+		`ALTER TABLE content_entries ADD COLUMN IF NOT EXISTS unpublish_at TIMESTAMP`,
+		`CREATE INDEX IF NOT EXISTS idx_content_entries_publish_at ON content_entries(publish_at) WHERE publish_at IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_content_entries_unpublish_at ON content_entries(unpublish_at) WHERE unpublish_at IS NOT NULL`,
+
+		// Immutable entry revisions, one per save
+		`CREATE TABLE IF NOT EXISTS content_revisions (
+			id SERIAL PRIMARY KEY,
+			entry_id INTEGER NOT NULL REFERENCES content_entries(id) ON DELETE CASCADE,
+			revision INTEGER NOT NULL,
+			data JSONB NOT NULL,
+			status VARCHAR(50) NOT NULL,
+			author_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			message TEXT NOT NULL DEFAULT '',
+			restored_from INTEGER REFERENCES content_revisions(id) ON DELETE SET NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (entry_id, revision)
+		)`,
+		// Entries created before revisions existed start with their current state
+		`INSERT INTO content_revisions (entry_id, revision, data, status, author_id, message, created_at) 
+			SELECT e.id, 1, e.data, e.status, e.created_by, 'Initial revision', COALESCE(e.updated_at, CURRENT_TIMESTAMP) 
+			FROM content_entries e 
+			WHERE NOT EXISTS (SELECT 1 FROM content_revisions r WHERE r.entry_id = e.id)`,
+
+		// Versions for optimistic concurrency control
+		`ALTER TABLE content_entries ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+		`ALTER TABLE content_types ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+
+		// Published snapshots kept next to the working draft in data
+		`ALTER TABLE content_entries ADD COLUMN IF NOT EXISTS published_data JSONB`,
+		`UPDATE content_entries SET published_data = data 
+			WHERE status = 'published' AND published_data IS NULL`,
+
+		// Editorial workflows: per content type stages and transitions, the stage
+		// each entry is in, who moved it there and reviewers' verdicts
+		`CREATE TABLE IF NOT EXISTS workflows (
+			content_type_id INTEGER PRIMARY KEY REFERENCES content_types(id) ON DELETE CASCADE,
+			stages JSONB NOT NULL,
+			transitions JSONB NOT NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE content_entries ADD COLUMN IF NOT EXISTS stage VARCHAR(50)`,
+		`CREATE TABLE IF NOT EXISTS content_stage_history (
+			id SERIAL PRIMARY KEY,
+			entry_id INTEGER NOT NULL REFERENCES content_entries(id) ON DELETE CASCADE,
+			from_stage VARCHAR(50),
+			to_stage VARCHAR(50) NOT NULL,
+			actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS content_reviews (
+			id SERIAL PRIMARY KEY,
+			entry_id INTEGER NOT NULL REFERENCES content_entries(id) ON DELETE CASCADE,
+			revision INTEGER NOT NULL,
+			stage VARCHAR(50) NOT NULL,
+			reviewer_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			approved BOOLEAN NOT NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_content_stage_history_entry ON content_stage_history(entry_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_content_reviews_entry ON content_reviews(entry_id, stage, revision)`,
+
+		// Content filters: containment lookups on published snapshots and
+		// date-time comparisons that skip values which are not timestamps
+		`CREATE INDEX IF NOT EXISTS idx_content_entries_published_data ON content_entries USING GIN(published_data)`,
+		`CREATE OR REPLACE FUNCTION gofrik_try_timestamptz(value TEXT) RETURNS TIMESTAMPTZ AS $$
+		BEGIN
+			RETURN value::timestamptz;
+		EXCEPTION WHEN others THEN
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql STABLE`,
+
+		// Full-text search: each content type's text search configuration and
+		// weighted search fields, and a search vector of every published snapshot
+		// that triggers keep current
+		`ALTER TABLE content_types ADD COLUMN IF NOT EXISTS search_config REGCONFIG NOT NULL DEFAULT 'simple'`,
+		`ALTER TABLE content_types ADD COLUMN IF NOT EXISTS search_fields JSONB NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE content_entries ADD COLUMN IF NOT EXISTS search_vector TSVECTOR`,
+		`CREATE OR REPLACE FUNCTION gofrik_search_vector(config REGCONFIG, fields JSONB, doc JSONB) RETURNS TSVECTOR AS $$
+		DECLARE
+			field JSONB;
+			result TSVECTOR := ''::tsvector;
+		BEGIN
+			IF doc IS NULL THEN
+				RETURN NULL;
+			END IF;
+			FOR field IN SELECT value FROM jsonb_array_elements(fields) LOOP
+				result := result || setweight(
+					jsonb_to_tsvector(config, COALESCE(doc #> ARRAY(SELECT jsonb_array_elements_text(field->'path')), 'null'), '["string"]'),
+					(field->>'weight')::"char"
+				);
+			END LOOP;
+			RETURN result;
+		END;
+		$$ LANGUAGE plpgsql IMMUTABLE`,
+		`CREATE OR REPLACE FUNCTION gofrik_search_text(fields JSONB, doc JSONB) RETURNS TEXT AS $$
+			SELECT string_agg(value #>> '{}', ' ' ORDER BY f.position)
+			FROM jsonb_array_elements(fields) WITH ORDINALITY AS f(field, position),
+				jsonb_path_query(doc #> ARRAY(SELECT jsonb_array_elements_text(f.field->'path')), 'strict $.**') AS value
+			WHERE jsonb_typeof(value) = 'string'
+		$$ LANGUAGE sql IMMUTABLE`,
+		`CREATE OR REPLACE FUNCTION gofrik_index_entry() RETURNS TRIGGER AS $$
+		BEGIN
+			SELECT gofrik_search_vector(t.search_config, t.search_fields, NEW.published_data)
+				INTO NEW.search_vector
+				FROM content_types t WHERE t.id = NEW.content_type_id;
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`CREATE OR REPLACE TRIGGER content_entries_search
+			BEFORE INSERT OR UPDATE OF published_data, content_type_id ON content_entries
+			FOR EACH ROW EXECUTE FUNCTION gofrik_index_entry()`,
+		`CREATE OR REPLACE FUNCTION gofrik_reindex_content_type() RETURNS TRIGGER AS $$
+		BEGIN
+			UPDATE content_entries
+				SET search_vector = gofrik_search_vector(NEW.search_config, NEW.search_fields, published_data)
+				WHERE content_type_id = NEW.id;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`CREATE OR REPLACE TRIGGER content_types_search
+			AFTER UPDATE OF search_config, search_fields ON content_types
+			FOR EACH ROW
+			WHEN (OLD.search_config IS DISTINCT FROM NEW.search_config OR OLD.search_fields IS DISTINCT FROM NEW.search_fields)
+			EXECUTE FUNCTION gofrik_reindex_content_type()`,
+		`UPDATE content_entries e
+			SET search_vector = gofrik_search_vector(t.search_config, t.search_fields, e.published_data)
+			FROM content_types t
+			WHERE t.id = e.content_type_id AND e.search_vector IS NULL AND e.published_data IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_content_entries_search ON content_entries USING GIN(search_vector)`,
+
+		// References between entries: each content type's reference properties
+		// and a row for every entry id held by the draft or published snapshot of
+		// an entry, which triggers keep current. Deleting a referenced entry is
+		// refused until its delete policy has been applied.
+		`ALTER TABLE content_types ADD COLUMN IF NOT EXISTS reference_fields JSONB NOT NULL DEFAULT '[]'`,
+		`CREATE TABLE IF NOT EXISTS content_references (
+			source_id INTEGER NOT NULL REFERENCES content_entries(id) ON DELETE CASCADE,
+			property VARCHAR(255) NOT NULL,
+			target_id INTEGER NOT NULL REFERENCES content_entries(id),
+			on_delete VARCHAR(20) NOT NULL,
+			PRIMARY KEY (source_id, property, target_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_content_references_target ON content_references(target_id)`,
+		`CREATE OR REPLACE FUNCTION gofrik_references(fields JSONB, doc JSONB) RETURNS TABLE (property TEXT, target_id INTEGER, on_delete TEXT) AS $$
+			SELECT f.field->>'property', (value #>> '{}')::numeric::integer, f.field->>'onDelete'
+			FROM jsonb_array_elements(fields) AS f(field),
+				jsonb_path_query(doc -> (f.field->>'property'), 'lax $[*] ? (@.type() == "number")') AS value
+		$$ LANGUAGE sql IMMUTABLE`,
+		`CREATE OR REPLACE FUNCTION gofrik_link_entry() RETURNS TRIGGER AS $$
+		BEGIN
+			DELETE FROM content_references WHERE source_id = NEW.id;
+			INSERT INTO content_references (source_id, property, target_id, on_delete)
+				SELECT NEW.id, r.property, r.target_id, r.on_delete
+				FROM content_types t,
+					LATERAL (SELECT * FROM gofrik_references(t.reference_fields, NEW.data)
+						UNION SELECT * FROM gofrik_references(t.reference_fields, NEW.published_data)) AS r
+				WHERE t.id = NEW.content_type_id;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`CREATE OR REPLACE TRIGGER content_entries_references
+			AFTER INSERT OR UPDATE OF data, published_data, content_type_id ON content_entries
+			FOR EACH ROW EXECUTE FUNCTION gofrik_link_entry()`,
+		// Ids stored before a property became a reference may point nowhere;
+		// they are left out rather than failing the schema change
+		`CREATE OR REPLACE FUNCTION gofrik_relink_content_type() RETURNS TRIGGER AS $$
+		BEGIN
+			DELETE FROM content_references r USING content_entries e
+				WHERE r.source_id = e.id AND e.content_type_id = NEW.id;
+			INSERT INTO content_references (source_id, property, target_id, on_delete)
+				SELECT e.id, r.property, r.target_id, r.on_delete
+				FROM content_entries e,
+					LATERAL (SELECT * FROM gofrik_references(NEW.reference_fields, e.data)
+						UNION SELECT * FROM gofrik_references(NEW.reference_fields, e.published_data)) AS r
+				WHERE e.content_type_id = NEW.id
+					AND EXISTS (SELECT 1 FROM content_entries target WHERE target.id = r.target_id);
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`CREATE OR REPLACE TRIGGER content_types_references
+			AFTER UPDATE OF reference_fields ON content_types
+			FOR EACH ROW
+			WHEN (OLD.reference_fields IS DISTINCT FROM NEW.reference_fields)
+			EXECUTE FUNCTION gofrik_relink_content_type()`,
+		// Removes entry ids from reference properties, leaving null in single references
+		`CREATE OR REPLACE FUNCTION gofrik_unlink(doc JSONB, properties TEXT[], ids INTEGER[]) RETURNS JSONB AS $$
+		DECLARE
+			property TEXT;
+		BEGIN
+			IF doc IS NULL THEN
+				RETURN NULL;
+			END IF;
+			FOREACH property IN ARRAY properties LOOP
+				IF jsonb_typeof(doc -> property) = 'array' THEN
+					doc := jsonb_set(doc, ARRAY[property], COALESCE(
+						(SELECT jsonb_agg(item ORDER BY position)
+							FROM jsonb_array_elements(doc -> property) WITH ORDINALITY AS a(item, position)
+							WHERE NOT (jsonb_typeof(item) = 'number' AND (item #>> '{}')::numeric = ANY(ids::numeric[]))),
+						'[]'));
+				ELSIF jsonb_typeof(doc -> property) = 'number' AND (doc ->> property)::numeric = ANY(ids::numeric[]) THEN
+					doc := jsonb_set(doc, ARRAY[property], 'null');
+				END IF;
+			END LOOP;
+			RETURN doc;
+		END;
+		$$ LANGUAGE plpgsql IMMUTABLE`,
+
+		// Media library: metadata of every uploaded file
+		`CREATE TABLE IF NOT EXISTS assets (
+			id SERIAL PRIMARY KEY,
+			key VARCHAR(1024) UNIQUE NOT NULL,
+			filename VARCHAR(255) NOT NULL,
+			mime_type VARCHAR(255) NOT NULL,
+			size BIGINT NOT NULL,
+			width INTEGER,
+			height INTEGER,
+			checksum VARCHAR(64) NOT NULL,
+			alt_text TEXT NOT NULL DEFAULT '',
+			uploaded_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			folder VARCHAR(255) NOT NULL DEFAULT '',
+			tags TEXT[] NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_assets_folder ON assets(folder)`,
+		`CREATE INDEX IF NOT EXISTS idx_assets_tags ON assets USING GIN(tags)`,
+		`CREATE INDEX IF NOT EXISTS idx_assets_checksum ON assets(checksum)`,
+
+		// Direct uploads: assets stay pending until the uploaded file is verified
+		`ALTER TABLE assets ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'ready'`,
+		`CREATE INDEX IF NOT EXISTS idx_assets_pending ON assets(created_at) WHERE status = 'pending'`,
+
+		// Resumable uploads: files received in chunks, stored as multipart
+		// uploads until every byte has arrived
+		`CREATE TABLE IF NOT EXISTS resumable_uploads (
+			id VARCHAR(64) PRIMARY KEY,
+			key VARCHAR(1024) NOT NULL,
+			multipart_id VARCHAR(1024) NOT NULL,
+			mime_type VARCHAR(255) NOT NULL,
+			size BIGINT NOT NULL,
+			upload_offset BIGINT NOT NULL DEFAULT 0,
+			parts JSONB NOT NULL DEFAULT '[]',
+			tail_size BIGINT NOT NULL DEFAULT 0,
+			hash_state BYTEA,
+			filename VARCHAR(255) NOT NULL,
+			alt_text TEXT NOT NULL DEFAULT '',
+			folder VARCHAR(255) NOT NULL DEFAULT '',
+			tags TEXT[] NOT NULL DEFAULT '{}',
+			uploaded_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			locked_until TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_resumable_uploads_expires ON resumable_uploads(expires_at)`,
+		// The request holding the lock of an upload
+		`ALTER TABLE resumable_uploads ADD COLUMN IF NOT EXISTS lock_token VARCHAR(64)`,
+
+		// Schema version: a counter bumped by every content type change, so
+		// that each replica knows when to rebuild its GraphQL schema
+		`CREATE TABLE IF NOT EXISTS schema_version (
+			id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+			version BIGINT NOT NULL DEFAULT 0
+		)`,
+		`INSERT INTO schema_version (id) VALUES (TRUE) ON CONFLICT DO NOTHING`,
+		`CREATE OR REPLACE FUNCTION gofrik_bump_schema_version() RETURNS TRIGGER AS $$
+		BEGIN
+			UPDATE schema_version SET version = version + 1;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`CREATE OR REPLACE TRIGGER content_types_schema_version
+			AFTER INSERT OR UPDATE OR DELETE ON content_types
+			FOR EACH STATEMENT EXECUTE FUNCTION gofrik_bump_schema_version()`,
+
 		// Create indexes
 		`CREATE INDEX IF NOT EXISTS idx_content_entries_type ON content_entries(content_type_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_content_entries_status ON content_entries(status)`,
 		`CREATE INDEX IF NOT EXISTS idx_content_entries_data ON content_entries USING GIN(data)`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at)`,
 	}
 
 	for _, migration := range migrations {
@@ -0,0 +1,215 @@
+package graphql
+
+import (
+	"fmt"
+
+	"gofrik/internal/auth"
+	"gofrik/internal/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+// apiKeyFromContext returns the API key the request was made with, if any
+func apiKeyFromContext(p graphql.ResolveParams) *models.APIKey {
+	key, _ := p.Context.Value("apiKey").(*models.APIKey)
+	return key
+}
+
+// requireCaller requires either a session or an API key
+func requireCaller(p graphql.ResolveParams) error {
+	if apiKeyFromContext(p) != nil {
+		return nil
+	}
+	_, err := requireAuth(p)
+	return err
+}
+
+// authorize requires a caller holding permission on the content type. Sessions
+// are checked against the user's role; API keys against their scope and access
+// and the current role of the user who created them.
+// A nil content type checks for a global grant (e.g. creating content types or
+// managing assets).
+func (s *Schema) authorize(p graphql.ResolveParams, permission string, ct *models.ContentType) error {
+	var contentTypeID *int
+	var contentType string
+	if ct != nil {
+		contentTypeID = &ct.ID
+		contentType = ct.Slug
+	}
+
+	if key := apiKeyFromContext(p); key != nil {
+		if !apiKeyAllows(key, permission, ct) || key.CreatedBy == nil {
+			return &ForbiddenError{Permission: permission, ContentType: contentType}
+		}
+		allowed, err := models.HasPermission(s.db, *key.CreatedBy, permission, contentTypeID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &ForbiddenError{Permission: permission, ContentType: contentType}
+		}
+		return nil
+	}
+
+	session, err := requireAuth(p)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := models.HasPermission(s.db, session.UserID, permission, contentTypeID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &ForbiddenError{Permission: permission, ContentType: contentType}
+	}
+	return nil
+}
+
+// readableContentTypes returns the content types the caller may read. For API
+// keys that is the key's scope limited to what its creator may read.
+func (s *Schema) readableContentTypes(p graphql.ResolveParams) (models.ContentTypeScope, error) {
+	if key := apiKeyFromContext(p); key != nil {
+		if key.CreatedBy == nil {
+			return models.ContentTypeScope{}, nil
+		}
+		creator, err := models.PermittedContentTypes(s.db, *key.CreatedBy, models.PermRead)
+		if err != nil {
+			return models.ContentTypeScope{}, err
+		}
+		scope := models.ContentTypeScope{All: len(key.ContentTypeIDs) == 0, IDs: key.ContentTypeIDs}
+		return scope.Intersect(creator), nil
+	}
+
+	session, err := requireAuth(p)
+	if err != nil {
+		return models.ContentTypeScope{}, err
+	}
+	return models.PermittedContentTypes(s.db, session.UserID, models.PermRead)
+}
+
+// apiKeyAllows reports whether an API key grants permission on a content type.
+// Keys never manage schemas; writing needs read_write access. A nil content
+// type (e.g. assets) is only allowed to keys scoped to every content type.
+func apiKeyAllows(key *models.APIKey, permission string, ct *models.ContentType) bool {
+	if ct == nil && len(key.ContentTypeIDs) > 0 {
+		return false
+	}
+	if ct != nil && !key.AllowsContentType(ct.ID) {
+		return false
+	}
+	switch permission {
+	case models.PermRead:
+		return true
+	case models.PermCreate, models.PermUpdate, models.PermPublish, models.PermDelete:
+		return key.Access == models.APIKeyAccessReadWrite
+	default:
+		return false
+	}
+}
+
+// authorizeEntry loads the content type of an entry and authorizes permission on it
+func (s *Schema) authorizeEntry(p graphql.ResolveParams, permission string, entry *models.ContentEntry) (*models.ContentType, error) {
+	ct, err := models.GetContentType(s.db, entry.ContentTypeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(p, permission, ct); err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+// readableEntry returns the version of an entry the caller may read. Outside
+// preview mode that is the published snapshot, and entries that are not
+// published are reported as not found. In preview mode callers with the read
+// permission or a preview token for the entry get the working draft.
+func (s *Schema) readableEntry(p graphql.ResolveParams, entry *models.ContentEntry) (*models.ContentEntry, error) {
+	if preview, _ := p.Args["preview"].(bool); preview {
+		if grant, ok := p.Context.Value("preview").(*auth.PreviewGrant); ok && grant.EntryID == entry.ID {
+			return entry, nil
+		}
+		if _, err := s.authorizeEntry(p, models.PermRead, entry); err != nil {
+			return nil, err
+		}
+		return entry, nil
+	}
+	if entry.Status != models.StatusPublished {
+		return nil, fmt.Errorf("content entry not found")
+	}
+	return entry.Published(), nil
+}
+
+// readableReference returns the version of an entry referenced by entry
+// parentID the caller may read, or nil when it is not published. It works like
+// readableEntry, and preview tokens for the parent created with
+// includeReferences also give the working draft.
+func (s *Schema) readableReference(p graphql.ResolveParams, parentID int, entry *models.ContentEntry) (*models.ContentEntry, error) {
+	if preview, _ := p.Args["preview"].(bool); preview {
+		if grant, ok := p.Context.Value("preview").(*auth.PreviewGrant); ok && grant.References && grant.EntryID == parentID {
+			return entry, nil
+		}
+	} else if entry.Status != models.StatusPublished {
+		return nil, nil
+	}
+	return s.readableEntry(p, entry)
+}
+
+// authorizeDeletion returns a check for the effects of deleting entries: the
+// caller needs the delete permission on the content types of entries deleted
+// through cascading references and the update permission on those of entries
+// whose references are removed
+func (s *Schema) authorizeDeletion(p graphql.ResolveParams) func(models.DeletionEffects) error {
+	return func(effects models.DeletionEffects) error {
+		types := map[int]*models.ContentType{}
+		require := func(permission string, refs []models.EntryRef) error {
+			for _, ref := range refs {
+				ct, ok := types[ref.ContentTypeID]
+				if !ok {
+					var err error
+					if ct, err = models.GetContentType(s.db, ref.ContentTypeID); err != nil {
+						return err
+					}
+					types[ref.ContentTypeID] = ct
+				}
+				if err := s.authorize(p, permission, ct); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := require(models.PermDelete, effects.Deleted); err != nil {
+			return err
+		}
+		return require(models.PermUpdate, effects.Updated)
+	}
+}
+
+// actorID returns the user a write is attributed to: the session user, or
+// the user who created the API key
+func actorID(p graphql.ResolveParams) *int {
+	if key := apiKeyFromContext(p); key != nil {
+		return key.CreatedBy
+	}
+	if session, ok := p.Context.Value("session").(*auth.Session); ok && session != nil {
+		return &session.UserID
+	}
+	return nil
+}
+
+// requireAdmin requires a session whose user has the admin role
+func (s *Schema) requireAdmin(p graphql.ResolveParams) (*auth.Session, error) {
+	session, err := requireAuth(p)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := models.GetUserByID(s.db, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role != models.RoleAdmin || !user.Active {
+		return nil, &ForbiddenError{Permission: "admin"}
+	}
+	return session, nil
+}
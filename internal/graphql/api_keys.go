@@ -0,0 +1,163 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gofrik/internal/auth"
+	"gofrik/internal/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+func apiKeyToMap(key *models.APIKey) map[string]interface{} {
+	contentTypeIDs := key.ContentTypeIDs
+	if contentTypeIDs == nil {
+		contentTypeIDs = []int{}
+	}
+
+	result := map[string]interface{}{
+		"id":               key.ID,
+		"name":             key.Name,
+		"prefix":           key.Prefix,
+		"access":           key.Access,
+		"environment":      key.Environment,
+		"content_type_ids": contentTypeIDs,
+		"created_at":       key.CreatedAt,
+	}
+	if key.CreatedBy != nil {
+		result["created_by"] = *key.CreatedBy
+	}
+	if key.LastUsedAt != nil {
+		result["last_used_at"] = *key.LastUsedAt
+	}
+	if key.ExpiresAt != nil {
+		result["expires_at"] = *key.ExpiresAt
+	}
+	if key.RevokedAt != nil {
+		result["revoked_at"] = *key.RevokedAt
+	}
+	return result
+}
+
+func (s *Schema) resolveAPIKeys(p graphql.ResolveParams) (interface{}, error) {
+	// API key management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return nil, err
+	}
+
+	keys, err := models.ListAPIKeys(s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []map[string]interface{}
+	for i := range keys {
+		items = append(items, apiKeyToMap(&keys[i]))
+	}
+	return items, nil
+}
+
+func (s *Schema) resolveCreateAPIKey(p graphql.ResolveParams) (interface{}, error) {
+	// API key management is reserved to admins
+	session, err := s.requireAdmin(p)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(p.Args["name"].(string))
+	access, _ := p.Args["access"].(string)
+	environment, _ := p.Args["environment"].(string)
+
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if access != models.APIKeyAccessRead && access != models.APIKeyAccessReadWrite {
+		return nil, fmt.Errorf("invalid access %q (use read or read_write)", access)
+	}
+
+	// Resolve the content type scope
+	var contentTypeIDs []int
+	if slugs, ok := p.Args["typeSlugs"].([]interface{}); ok {
+		for _, slug := range slugs {
+			ct, err := models.GetContentTypeBySlug(s.db, slug.(string))
+			if err != nil {
+				return nil, fmt.Errorf("content type %q: %w", slug, err)
+			}
+			contentTypeIDs = append(contentTypeIDs, ct.ID)
+		}
+	}
+
+	expiresAt := timeArg(p, "expiresAt")
+	if expiresAt != nil && !expiresAt.After(time.Now().UTC()) {
+		return nil, fmt.Errorf("expiresAt must be in the future")
+	}
+
+	secret, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &models.APIKey{
+		Name:           name,
+		Prefix:         prefix,
+		KeyHash:        auth.HashToken(secret),
+		Access:         access,
+		Environment:    strings.TrimSpace(environment),
+		ContentTypeIDs: contentTypeIDs,
+		CreatedBy:      &session.UserID,
+		ExpiresAt:      expiresAt,
+	}
+	if err := models.CreateAPIKey(s.db, key); err != nil {
+		return nil, err
+	}
+
+	// The key is only returned here; store it in the client's secrets
+	return map[string]interface{}{
+		"key":    secret,
+		"apiKey": apiKeyToMap(key),
+	}, nil
+}
+
+func (s *Schema) resolveRotateAPIKey(p graphql.ResolveParams) (interface{}, error) {
+	// API key management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return nil, err
+	}
+
+	id, _ := p.Args["id"].(int)
+
+	secret, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// The old key stops working immediately
+	if err := models.RotateAPIKey(s.db, id, prefix, auth.HashToken(secret)); err != nil {
+		return nil, err
+	}
+
+	key, err := models.GetAPIKey(s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"key":    secret,
+		"apiKey": apiKeyToMap(key),
+	}, nil
+}
+
+func (s *Schema) resolveRevokeAPIKey(p graphql.ResolveParams) (interface{}, error) {
+	// API key management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return false, err
+	}
+
+	id, _ := p.Args["id"].(int)
+	if err := models.RevokeAPIKey(s.db, id, time.Now().UTC()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,477 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"gofrik/internal/imaging"
+	"gofrik/internal/models"
+	"gofrik/internal/storage"
+
+	"github.com/graphql-go/graphql"
+)
+
+func (s *Schema) assetToMap(asset *models.Asset) map[string]interface{} {
+	tags := asset.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	result := map[string]interface{}{
+		"id":         asset.ID,
+		"status":     asset.Status,
+		"key":        asset.Key,
+		"filename":   asset.Filename,
+		"mime_type":  asset.MimeType,
+		"size":       asset.Size,
+		"checksum":   asset.Checksum,
+		"alt_text":   asset.AltText,
+		"folder":     asset.Folder,
+		"tags":       tags,
+		"created_at": asset.CreatedAt,
+		"updated_at": asset.UpdatedAt,
+	}
+	if s.storage != nil {
+		result["url"] = s.storage.URL(asset.Key)
+	}
+	if asset.Width != nil {
+		result["width"] = *asset.Width
+	}
+	if asset.Height != nil {
+		result["height"] = *asset.Height
+	}
+	if asset.UploadedBy != nil {
+		result["uploaded_by"] = *asset.UploadedBy
+	}
+	return result
+}
+
+func (s *Schema) getAssetType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Asset",
+		Description: "An uploaded file of the media library",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"status": &graphql.Field{
+				Type:        graphql.String,
+				Description: "ready, or pending until a direct upload is completed",
+			},
+			"key": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Location of the file in storage",
+			},
+			"url": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Public URL of the file; null when storage is not configured",
+			},
+			"image_url": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Signed URL of a resized, cropped or converted version of the image; null for files that are not raster images",
+				Args: graphql.FieldConfigArgument{
+					"width": &graphql.ArgumentConfig{
+						Type: graphql.Int,
+					},
+					"height": &graphql.ArgumentConfig{
+						Type: graphql.Int,
+					},
+					"fit": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "contain (default) fits within width and height, cover crops to fill them around the focal point, fill stretches",
+					},
+					"format": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "jpeg, png, gif or webp; defaults to the format of the original",
+					},
+					"quality": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "JPEG quality from 1 to 100 (default: 80)",
+					},
+					"focalX": &graphql.ArgumentConfig{
+						Type:        graphql.Float,
+						Description: "Horizontal position of the point cover keeps in view, from 0 (left) to 1 (right)",
+					},
+					"focalY": &graphql.ArgumentConfig{
+						Type:        graphql.Float,
+						Description: "Vertical position of the point cover keeps in view, from 0 (top) to 1 (bottom)",
+					},
+				},
+				Resolve: s.resolveAssetImageURL,
+			},
+			"filename": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Name the file was uploaded with",
+			},
+			"mime_type": &graphql.Field{
+				Type: graphql.String,
+			},
+			"size": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Size in bytes",
+			},
+			"width": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Width in pixels, for images",
+			},
+			"height": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Height in pixels, for images",
+			},
+			"checksum": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Hex encoded SHA-256 of the content",
+			},
+			"alt_text": &graphql.Field{
+				Type: graphql.String,
+			},
+			"folder": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Slash separated folder path; empty for the root folder",
+			},
+			"tags": &graphql.Field{
+				Type: graphql.NewList(graphql.String),
+			},
+			"uploaded_by": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "User who uploaded the file",
+			},
+			"created_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"updated_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
+
+func getDirectUploadType() *graphql.Object {
+	headerType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UploadHeader",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"value": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "DirectUpload",
+		Description: "A presigned request uploading a file straight to the storage bucket",
+		Fields: graphql.Fields{
+			"asset_id": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "The pending asset; pass it to completeUpload once the file is uploaded",
+			},
+			"url": &graphql.Field{
+				Type: graphql.String,
+			},
+			"method": &graphql.Field{
+				Type: graphql.String,
+			},
+			"headers": &graphql.Field{
+				Type:        graphql.NewList(headerType),
+				Description: "Headers to send with the request; the bucket rejects requests without them",
+			},
+			"expires_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
+
+func getAssetsResponseType(assetType, pageInfoType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "AssetsResponse",
+		Description: "List of assets with pagination info",
+		Fields: graphql.Fields{
+			"items": &graphql.Field{
+				Type: graphql.NewList(assetType),
+			},
+			"pageInfo": &graphql.Field{
+				Type:        pageInfoType,
+				Description: "Pagination information",
+			},
+		},
+	})
+}
+
+func assetsArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"search": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "Words that must all appear in the filename or alt text, ignoring case",
+		},
+		"folder": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: `Only assets directly in this folder; "" for the root folder`,
+		},
+		"tags": &graphql.ArgumentConfig{
+			Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+			Description: "Only assets having every tag",
+		},
+		"mimeType": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: `MIME type such as "image/png", or a prefix ending in "/" such as "image/"`,
+		},
+		"limit": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 10,
+			Description:  "Number of items per page (default: 10, max: 100)",
+		},
+		"offset": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 0,
+			Description:  "Number of items to skip (default: 0)",
+		},
+	}
+}
+
+// stringsArg returns a list of strings argument, or nil when it was not given
+func stringsArg(p graphql.ResolveParams, name string) []string {
+	values, ok := p.Args[name].([]interface{})
+	if !ok {
+		return nil
+	}
+	result := []string{}
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
+}
+
+// Assets are not tied to a content type, so access needs a global grant
+func (s *Schema) resolveAssets(p graphql.ResolveParams) (interface{}, error) {
+	if err := s.authorize(p, models.PermRead, nil); err != nil {
+		return nil, err
+	}
+
+	filter := models.AssetFilter{
+		Tags: stringsArg(p, "tags"),
+	}
+	filter.Search, _ = p.Args["search"].(string)
+	filter.MimeType, _ = p.Args["mimeType"].(string)
+	if folder, ok := p.Args["folder"].(string); ok {
+		filter.Folder = &folder
+	}
+
+	limit, offset, _, _ := paginationArgs(p)
+	totalCount, err := models.CountAssets(s.db, filter)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := models.ListAssets(s.db, filter, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []map[string]interface{}
+	for i := range assets {
+		items = append(items, s.assetToMap(&assets[i]))
+	}
+
+	return map[string]interface{}{
+		"items":    items,
+		"pageInfo": pageInfo(totalCount, limit, offset),
+	}, nil
+}
+
+func (s *Schema) resolveAsset(p graphql.ResolveParams) (interface{}, error) {
+	if err := s.authorize(p, models.PermRead, nil); err != nil {
+		return nil, err
+	}
+
+	asset, err := models.GetAsset(s.db, p.Args["id"].(int))
+	if err != nil {
+		return nil, err
+	}
+	return s.assetToMap(asset), nil
+}
+
+func (s *Schema) resolveUpdateAsset(p graphql.ResolveParams) (interface{}, error) {
+	if err := s.authorize(p, models.PermUpdate, nil); err != nil {
+		return nil, err
+	}
+
+	update := models.AssetUpdate{
+		Tags: stringsArg(p, "tags"),
+	}
+	if altText, ok := p.Args["altText"].(string); ok {
+		update.AltText = &altText
+	}
+	if folder, ok := p.Args["folder"].(string); ok {
+		update.Folder = &folder
+	}
+
+	asset, err := models.UpdateAsset(s.db, p.Args["id"].(int), update, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return s.assetToMap(asset), nil
+}
+
+func (s *Schema) resolveDeleteAsset(p graphql.ResolveParams) (interface{}, error) {
+	if err := s.authorize(p, models.PermDelete, nil); err != nil {
+		return nil, err
+	}
+	if s.storage == nil {
+		return nil, fmt.Errorf("file storage is not configured")
+	}
+
+	asset, err := models.GetAsset(s.db, p.Args["id"].(int))
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the file first so that a failure leaves the asset listed and the
+	// deletion can be retried
+	if asset.Status == models.AssetPending {
+		err = s.storage.DiscardUpload(p.Context, asset.Key)
+	} else {
+		err = s.storage.DeleteFile(p.Context, s.storage.URL(asset.Key))
+	}
+	if err != nil {
+		log.Printf("Failed to delete file %s: %v", asset.Key, err)
+		return nil, fmt.Errorf("failed to delete file")
+	}
+	if err := s.storage.DeleteVariants(p.Context, asset.Key); err != nil {
+		log.Printf("Failed to delete variants of file %s: %v", asset.Key, err)
+	}
+	if err := models.DeleteAsset(s.db, asset.ID); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func (s *Schema) resolveAssetImageURL(p graphql.ResolveParams) (interface{}, error) {
+	source, _ := p.Source.(map[string]interface{})
+	key, _ := source["key"].(string)
+	if _, isImage := source["width"]; !isImage || s.storage == nil || s.images == nil {
+		return nil, nil
+	}
+
+	opts := imaging.Options{FocalX: 0.5, FocalY: 0.5}
+	opts.Width, _ = p.Args["width"].(int)
+	opts.Height, _ = p.Args["height"].(int)
+	opts.Fit, _ = p.Args["fit"].(string)
+	opts.Format, _ = p.Args["format"].(string)
+	opts.Quality, _ = p.Args["quality"].(int)
+	if focalX, ok := p.Args["focalX"].(float64); ok {
+		opts.FocalX = focalX
+	}
+	if focalY, ok := p.Args["focalY"].(float64); ok {
+		opts.FocalY = focalY
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return s.images.URL(key, opts), nil
+}
+
+func (s *Schema) resolveCreateUploadURL(p graphql.ResolveParams) (interface{}, error) {
+	if err := s.authorize(p, models.PermCreate, nil); err != nil {
+		return nil, err
+	}
+	if s.storage == nil {
+		return nil, fmt.Errorf("file storage is not configured")
+	}
+
+	filename, _ := p.Args["filename"].(string)
+	contentType, _ := p.Args["contentType"].(string)
+	size, _ := p.Args["size"].(int)
+	if filename == "" {
+		return nil, fmt.Errorf("filename is required")
+	}
+
+	upload, err := s.storage.CreateUploadURL(p.Context, filename, contentType, int64(size), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	// The asset is listed once completeUpload has verified the file
+	asset := &models.Asset{
+		Status:     models.AssetPending,
+		Key:        upload.Key,
+		Filename:   filename,
+		MimeType:   contentType,
+		Size:       int64(size),
+		UploadedBy: actorID(p),
+	}
+	asset.AltText, _ = p.Args["altText"].(string)
+	asset.Folder, _ = p.Args["folder"].(string)
+	asset.Tags = stringsArg(p, "tags")
+	if err := models.CreateAsset(s.db, asset); err != nil {
+		return nil, err
+	}
+
+	var headers []map[string]interface{}
+	for name, values := range upload.Headers {
+		for _, value := range values {
+			headers = append(headers, map[string]interface{}{"name": name, "value": value})
+		}
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i]["name"].(string) < headers[j]["name"].(string) })
+
+	return map[string]interface{}{
+		"asset_id":   asset.ID,
+		"url":        upload.URL,
+		"method":     http.MethodPut,
+		"headers":    headers,
+		"expires_at": upload.ExpiresAt,
+	}, nil
+}
+
+func (s *Schema) resolveCompleteUpload(p graphql.ResolveParams) (interface{}, error) {
+	if err := s.authorize(p, models.PermCreate, nil); err != nil {
+		return nil, err
+	}
+	if s.storage == nil {
+		return nil, fmt.Errorf("file storage is not configured")
+	}
+
+	asset, err := models.GetAsset(s.db, p.Args["assetId"].(int))
+	if err != nil {
+		return nil, err
+	}
+	if asset.Status != models.AssetPending {
+		return nil, fmt.Errorf("asset %d is not a pending upload", asset.ID)
+	}
+	// Only the uploader completes an upload
+	if uploader, actor := asset.UploadedBy, actorID(p); uploader != nil && (actor == nil || *actor != *uploader) {
+		return nil, &ForbiddenError{Permission: models.PermCreate}
+	}
+
+	uploaded, err := s.storage.VerifyUpload(p.Context, asset.Key, asset.MimeType, asset.Size)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("the file of asset %d has not been uploaded", asset.ID)
+	}
+	if errors.Is(err, storage.ErrUploadRejected) {
+		// Files breaking the policy are not kept around
+		if err := s.storage.DiscardUpload(p.Context, asset.Key); err != nil {
+			log.Printf("Failed to delete file %s: %v", asset.Key, err)
+		}
+		if err := models.DeleteAsset(s.db, asset.ID); err != nil {
+			log.Printf("Failed to delete asset %d: %v", asset.ID, err)
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	completed, err := models.CompleteAsset(s.db, asset.ID, uploaded.Size, uploaded.Width, uploaded.Height, uploaded.Checksum, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return s.assetToMap(completed), nil
+}
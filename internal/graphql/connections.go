@@ -0,0 +1,193 @@
+package graphql
+
+import (
+	"fmt"
+
+	"gofrik/internal/models"
+	"gofrik/internal/query"
+
+	"github.com/graphql-go/graphql"
+)
+
+func getConnectionPageInfoType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ConnectionPageInfo",
+		Description: "Where a connection page sits in its list",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+			},
+			"hasPreviousPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+			},
+			"startCursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Cursor of the first edge; pass it as before to get the previous page",
+			},
+			"endCursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Cursor of the last edge; pass it as after to get the next page",
+			},
+		},
+	})
+}
+
+// newConnectionType returns the Relay connection type of nodeType, named
+// <base>Connection, with its <base>Edge type
+func newConnectionType(base string, nodeType, connectionPageInfoType *graphql.Object) *graphql.Object {
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: base + "Edge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"node": &graphql.Field{
+				Type: nodeType,
+			},
+		},
+	})
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: base + "Connection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: graphql.NewList(edgeType),
+			},
+			"pageInfo": &graphql.Field{
+				Type: connectionPageInfoType,
+			},
+			"totalCount": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Total number of items in the list",
+			},
+		},
+	})
+}
+
+// connectionArgs returns the Relay pagination arguments
+func connectionArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"first": &graphql.ArgumentConfig{
+			Type:        graphql.Int,
+			Description: fmt.Sprintf("Number of items after the after cursor (default: %d, max: %d)", query.DefaultPageSize, query.MaxPageSize),
+		},
+		"after": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"last": &graphql.ArgumentConfig{
+			Type:        graphql.Int,
+			Description: fmt.Sprintf("Number of items before the before cursor (max: %d)", query.MaxPageSize),
+		},
+		"before": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+	}
+}
+
+// connectionPage reads the Relay pagination arguments
+func connectionPage(p graphql.ResolveParams) query.Page {
+	var page query.Page
+	page.First, _ = p.Args["first"].(int)
+	page.After, _ = p.Args["after"].(string)
+	page.Last, _ = p.Args["last"].(int)
+	page.Before, _ = p.Args["before"].(string)
+	return page
+}
+
+// connectionToMap builds a connection from nodes and their cursors
+func connectionToMap(nodes []interface{}, cursors []string, info query.PageInfo, totalCount int) map[string]interface{} {
+	edges := make([]interface{}, 0, len(nodes))
+	for i, node := range nodes {
+		edges = append(edges, map[string]interface{}{
+			"cursor": cursors[i],
+			"node":   node,
+		})
+	}
+
+	pageInfo := map[string]interface{}{
+		"hasNextPage":     info.HasNextPage,
+		"hasPreviousPage": info.HasPreviousPage,
+	}
+	if info.StartCursor != "" {
+		pageInfo["startCursor"] = info.StartCursor
+		pageInfo["endCursor"] = info.EndCursor
+	}
+
+	return map[string]interface{}{
+		"edges":      edges,
+		"pageInfo":   pageInfo,
+		"totalCount": totalCount,
+	}
+}
+
+func (s *Schema) resolveContentTypesConnection(p graphql.ResolveParams) (interface{}, error) {
+	scope, err := s.readableContentTypes(p)
+	if err != nil {
+		return nil, err
+	}
+
+	orderBy, _ := p.Args["orderBy"].(string)
+	orderDirection, _ := p.Args["orderDirection"].(string)
+
+	totalCount, err := models.CountContentTypes(s.db, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := models.PageContentTypes(s.db, scope, orderBy, orderDirection, connectionPage(p))
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []interface{}
+	for i := range conn.Types {
+		nodes = append(nodes, contentTypeToMap(&conn.Types[i]))
+	}
+	return connectionToMap(nodes, conn.Cursors, conn.PageInfo, totalCount), nil
+}
+
+func (s *Schema) resolveContentConnection(p graphql.ResolveParams) (interface{}, error) {
+	typeSlug, ok := p.Args["typeSlug"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid typeSlug")
+	}
+
+	ct, err := models.GetContentTypeBySlug(s.db, typeSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.connectEntries(p, ct, func(entry *models.ContentEntry) interface{} {
+		return entryToMap(entry)
+	})
+}
+
+// connectEntries lists entries of a content type as a connection, converting
+// each entry with toNode
+func (s *Schema) connectEntries(p graphql.ResolveParams, ct *models.ContentType, toNode func(*models.ContentEntry) interface{}) (interface{}, error) {
+	filter, order, err := s.entryQuery(p, ct)
+	if err != nil {
+		return nil, err
+	}
+	preview, _ := p.Args["preview"].(bool)
+
+	totalCount, err := models.CountContentEntries(s.db, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := models.PageContentEntries(s.db, filter, order, connectionPage(p))
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []interface{}
+	for i := range conn.Entries {
+		entry := &conn.Entries[i]
+		if !preview {
+			// Public delivery serves the published snapshot, not the draft
+			entry = entry.Published()
+		}
+		nodes = append(nodes, toNode(entry))
+	}
+	return connectionToMap(nodes, conn.Cursors, conn.PageInfo, totalCount), nil
+}
@@ -0,0 +1,708 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gofrik/internal/jsonschema"
+	"gofrik/internal/models"
+	"gofrik/internal/reference"
+
+	"github.com/graphql-go/graphql"
+)
+
+var graphqlNamePattern = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// systemEntryFields are the entry columns exposed on every typed object;
+// schema properties with the same name are only available through ContentEntry.data
+var systemEntryFields = map[string]bool{
+	"id":                      true,
+	"content_type_id":         true,
+	"status":                  true,
+	"created_by":              true,
+	"created_at":              true,
+	"updated_at":              true,
+	"published_at":            true,
+	"publish_at":              true,
+	"unpublish_at":            true,
+	"version":                 true,
+	"has_unpublished_changes": true,
+	"stage":                   true,
+}
+
+// typedDataKey holds the decoded entry data in the source map of typed objects
+const typedDataKey = "$data"
+
+// typeBuilder generates typed GraphQL objects, queries and mutations from content type schemas.
+// A "blog-post" content type yields a BlogPost object, blogPosts/blogPost queries and
+// createBlogPost/updateBlogPost mutations.
+type typeBuilder struct {
+	s        *Schema
+	jsonType *graphql.Scalar
+	// entryType is the generic ContentEntry type, used for references that may
+	// point to several content types
+	entryType    *graphql.Object
+	whereType    *graphql.InputObject
+	sortType     *graphql.InputObject
+	pageInfoType *graphql.Object
+	// connectionPageInfoType is the page info of typed connections
+	connectionPageInfoType *graphql.Object
+	query                  *graphql.Object
+	mutation               *graphql.Object
+	typeNames              map[string]bool
+	// objects holds the typed object of each content type by slug
+	objects map[string]*graphql.Object
+	// references are the reference fields to add once every typed object exists
+	references []typedReference
+}
+
+// typedReference is a reference property of a typed object
+type typedReference struct {
+	object *graphql.Object
+	name   string // GraphQL field name
+	field  reference.Field
+}
+
+func newTypeBuilder(s *Schema, jsonType *graphql.Scalar, entryType *graphql.Object, whereType, sortType *graphql.InputObject, pageInfoType, connectionPageInfoType *graphql.Object, query, mutation *graphql.Object) *typeBuilder {
+	b := &typeBuilder{
+		s:                      s,
+		jsonType:               jsonType,
+		entryType:              entryType,
+		whereType:              whereType,
+		sortType:               sortType,
+		pageInfoType:           pageInfoType,
+		connectionPageInfoType: connectionPageInfoType,
+		query:                  query,
+		mutation:               mutation,
+		typeNames: map[string]bool{
+			"String":        true,
+			"Int":           true,
+			"Float":         true,
+			"Boolean":       true,
+			"ID":            true,
+			"DateTime":      true,
+			jsonType.Name(): true,
+		},
+		objects: map[string]*graphql.Object{},
+	}
+	b.collectTypeNames(query)
+	b.collectTypeNames(mutation)
+	return b
+}
+
+// collectTypeNames records every named type reachable from t so generated names never clash with them
+func (b *typeBuilder) collectTypeNames(t graphql.Type) {
+	switch typ := t.(type) {
+	case *graphql.List:
+		b.collectTypeNames(typ.OfType)
+	case *graphql.NonNull:
+		b.collectTypeNames(typ.OfType)
+	case *graphql.Object:
+		if b.typeNames[typ.Name()] {
+			return
+		}
+		b.typeNames[typ.Name()] = true
+		for _, field := range typ.Fields() {
+			b.collectTypeNames(field.Type)
+			for _, arg := range field.Args {
+				b.collectTypeNames(arg.Type)
+			}
+		}
+	case *graphql.InputObject:
+		if b.typeNames[typ.Name()] {
+			return
+		}
+		b.typeNames[typ.Name()] = true
+		for _, field := range typ.Fields() {
+			b.collectTypeNames(field.Type)
+		}
+	case nil:
+	default:
+		b.typeNames[typ.Name()] = true
+	}
+}
+
+// addContentTypes adds the typed API of every content type, skipping (and logging)
+// content types whose names cannot be mapped to free GraphQL names
+func (b *typeBuilder) addContentTypes(contentTypes []models.ContentType) {
+	for i := range contentTypes {
+		if err := b.addContentType(&contentTypes[i]); err != nil {
+			log.Printf("Skipping typed GraphQL API for content type %q: %v", contentTypes[i].Slug, err)
+		}
+	}
+	b.addReferenceFields()
+}
+
+// addReferenceFields turns the reference properties of typed objects into
+// fields resolving the referenced entries. References to a single content
+// type return its typed object; others return ContentEntry.
+func (b *typeBuilder) addReferenceFields() {
+	for _, ref := range b.references {
+		field := ref.field
+
+		var target graphql.Output = b.entryType
+		toNode := func(entry *models.ContentEntry) interface{} { return entryToMap(entry) }
+		if len(field.To) == 1 && b.objects[field.To[0]] != nil {
+			target = b.objects[field.To[0]]
+			toNode = typedEntryToMap
+		}
+		if field.Multiple {
+			target = graphql.NewList(target)
+		}
+
+		ref.object.AddFieldConfig(ref.name, &graphql.Field{
+			Type:        target,
+			Description: fmt.Sprintf("Reference to %s entries; entries that are not published are left out unless preview is set", strings.Join(field.To, " or ")),
+			Args: graphql.FieldConfigArgument{
+				"preview": referencePreviewArg(),
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return b.s.resolveReference(p, field, toNode)
+			},
+		})
+	}
+}
+
+// apiNames are the GraphQL names generated for one content type
+type apiNames struct {
+	typeName     string
+	inputName    string
+	updateName   string
+	responseName string
+	single       string
+	plural       string
+	connection   string
+	create       string
+	update       string
+}
+
+func namesFor(base string) apiNames {
+	single := lowerFirst(base)
+	plural := pluralize(single)
+	if plural == single {
+		plural = single + "List"
+	}
+	return apiNames{
+		typeName:     base,
+		inputName:    base + "Input",
+		updateName:   base + "UpdateInput",
+		responseName: upperFirst(plural) + "Response",
+		single:       single,
+		plural:       plural,
+		connection:   plural + "Connection",
+		create:       "create" + base,
+		update:       "update" + base,
+	}
+}
+
+// available reports whether none of the names are taken yet
+func (b *typeBuilder) available(n apiNames) bool {
+	for _, name := range []string{n.typeName, n.inputName, n.updateName, n.responseName, n.typeName + "Connection", n.typeName + "Edge"} {
+		if b.typeNames[name] {
+			return false
+		}
+	}
+	queryFields := b.query.Fields()
+	mutationFields := b.mutation.Fields()
+	return queryFields[n.single] == nil && queryFields[n.plural] == nil && queryFields[n.connection] == nil &&
+		mutationFields[n.create] == nil && mutationFields[n.update] == nil
+}
+
+func (b *typeBuilder) addContentType(ct *models.ContentType) error {
+	schema, err := jsonschema.Parse(ct.Schema)
+	if err != nil {
+		return err
+	}
+
+	base := pascalCase(ct.Slug)
+	if !graphqlNamePattern.MatchString(base) {
+		return fmt.Errorf("slug does not produce a valid GraphQL name")
+	}
+
+	// Fall back to a suffixed name when the plain one clashes (e.g. "user" or "content")
+	names := namesFor(base)
+	if !b.available(names) {
+		names = namesFor(base + "Entry")
+		if !b.available(names) {
+			return fmt.Errorf("generated names for %q are already in use", base)
+		}
+	}
+	b.typeNames[names.typeName] = true
+	b.typeNames[names.inputName] = true
+	b.typeNames[names.updateName] = true
+	b.typeNames[names.responseName] = true
+	b.typeNames[names.typeName+"Connection"] = true
+	b.typeNames[names.typeName+"Edge"] = true
+
+	references, err := reference.FromSchema(ct.Schema)
+	if err != nil {
+		return err
+	}
+
+	objectType := b.objectType(schema, names.typeName, ct.Description, true)
+	b.objects[ct.Slug] = objectType
+	for _, pf := range propertyFields(schema, true) {
+		for _, field := range references {
+			if field.Property == pf.property {
+				b.references = append(b.references, typedReference{object: objectType, name: pf.field, field: field})
+			}
+		}
+	}
+	responseType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        names.responseName,
+		Description: fmt.Sprintf("List of %s entries with pagination info", ct.Name),
+		Fields: graphql.Fields{
+			"items": &graphql.Field{
+				Type:        graphql.NewList(objectType),
+				Description: fmt.Sprintf("List of %s entries", ct.Name),
+			},
+			"pageInfo": &graphql.Field{
+				Type:        b.pageInfoType,
+				Description: "Pagination information",
+			},
+		},
+	})
+	createInput := b.inputObjectType(schema, names.inputName, true, true)
+	updateInput := b.inputObjectType(schema, names.updateName, true, false)
+
+	ctID := ct.ID
+
+	b.query.AddFieldConfig(names.plural, &graphql.Field{
+		Type:        responseType,
+		Description: fmt.Sprintf("Get %s entries; only published entries unless preview is set", ct.Name),
+		Args:        listArgs(b.whereType, b.sortType),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			ct, err := models.GetContentType(b.s.db, ctID)
+			if err != nil {
+				return nil, err
+			}
+			return b.s.listEntries(p, ct, typedEntryToMap)
+		},
+	})
+
+	b.query.AddFieldConfig(names.connection, &graphql.Field{
+		Type:        newConnectionType(names.typeName, objectType, b.connectionPageInfoType),
+		Description: fmt.Sprintf("Get %s entries as a Relay connection with cursor pagination; only published entries unless preview is set", ct.Name),
+		Args:        withArgs(connectionArgs(), entryQueryArgs(b.whereType, b.sortType)),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			ct, err := models.GetContentType(b.s.db, ctID)
+			if err != nil {
+				return nil, err
+			}
+			return b.s.connectEntries(p, ct, typedEntryToMap)
+		},
+	})
+
+	b.query.AddFieldConfig(names.single, &graphql.Field{
+		Type:        objectType,
+		Description: fmt.Sprintf("Get a %s entry by ID", ct.Name),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"preview": previewArg(),
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(int)
+			entry, err := getTypedEntry(b.s, id, ctID)
+			if err != nil {
+				return nil, err
+			}
+			entry, err = b.s.readableEntry(p, entry)
+			if err != nil {
+				return nil, err
+			}
+			return typedEntryToMap(entry), nil
+		},
+	})
+
+	b.mutation.AddFieldConfig(names.create, &graphql.Field{
+		Type:        objectType,
+		Description: fmt.Sprintf("Create a new %s entry", ct.Name),
+		Args: graphql.FieldConfigArgument{
+			"input": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(createInput),
+			},
+			"status": &graphql.ArgumentConfig{
+				Type:         graphql.String,
+				DefaultValue: "draft",
+			},
+			"message": messageArg(),
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			ct, err := models.GetContentType(b.s.db, ctID)
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := json.Marshal(inputToData(p.Args["input"], schema, true))
+			if err != nil {
+				return nil, err
+			}
+			status, _ := p.Args["status"].(string)
+
+			entry, err := b.s.createEntry(p, ct, data, status)
+			if err != nil {
+				return nil, err
+			}
+			return typedEntryToMap(entry), nil
+		},
+	})
+
+	b.mutation.AddFieldConfig(names.update, &graphql.Field{
+		Type:        objectType,
+		Description: fmt.Sprintf("Update a %s entry; only the given fields are changed", ct.Name),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"input": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(updateInput),
+			},
+			"status": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"message":         messageArg(),
+			"expectedVersion": expectedVersionArg(),
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(int)
+			entry, err := getTypedEntry(b.s, id, ctID)
+			if err != nil {
+				return nil, err
+			}
+			if expected := expectedVersion(p); expected != nil && *expected != entry.Version {
+				return nil, b.s.entryConflict(&models.VersionConflictError{Expected: *expected, Actual: entry.Version}, id)
+			}
+
+			// Merge the given fields into the existing data
+			current := map[string]interface{}{}
+			if err := json.Unmarshal(entry.Data, &current); err != nil {
+				return nil, fmt.Errorf("invalid stored data: %w", err)
+			}
+			if patch, ok := inputToData(p.Args["input"], schema, true).(map[string]interface{}); ok {
+				for k, v := range patch {
+					current[k] = v
+				}
+			}
+			data, err := json.Marshal(current)
+			if err != nil {
+				return nil, err
+			}
+			status, _ := p.Args["status"].(string)
+
+			// The patch was merged into the data read above, so the write must
+			// not overwrite changes saved in the meantime
+			updated, err := b.s.updateEntry(p, entry, data, status, &entry.Version)
+			if err != nil {
+				return nil, err
+			}
+			return typedEntryToMap(updated), nil
+		},
+	})
+
+	return nil
+}
+
+// uniqueTypeName returns name, or name with a numeric suffix if it is taken
+func (b *typeBuilder) uniqueTypeName(name string) string {
+	candidate := name
+	for i := 2; b.typeNames[candidate]; i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	b.typeNames[candidate] = true
+	return candidate
+}
+
+// schemaKind maps a schema to the single JSON type it describes, or "" when it
+// allows several (such values are exposed as the JSON scalar)
+func schemaKind(schema *jsonschema.Schema) string {
+	var types []string
+	for _, t := range schema.Types {
+		if t != "null" {
+			types = append(types, t)
+		}
+	}
+	switch {
+	case len(types) == 1:
+		return types[0]
+	case len(types) == 2 && schema.HasType("integer") && schema.HasType("number"):
+		return "number"
+	case len(types) == 0 && len(schema.Properties) > 0:
+		return "object"
+	case len(types) == 0 && schema.Items != nil:
+		return "array"
+	}
+	return ""
+}
+
+func (b *typeBuilder) outputType(schema *jsonschema.Schema, name string) graphql.Output {
+	switch schemaKind(schema) {
+	case "string":
+		return graphql.String
+	case "integer":
+		return graphql.Int
+	case "number":
+		return graphql.Float
+	case "boolean":
+		return graphql.Boolean
+	case "array":
+		if schema.Items == nil {
+			return graphql.NewList(b.jsonType)
+		}
+		return graphql.NewList(b.outputType(schema.Items, name+"Item"))
+	case "object":
+		if len(schema.Properties) == 0 {
+			return b.jsonType
+		}
+		return b.objectType(schema, b.uniqueTypeName(name), "", false)
+	}
+	return b.jsonType
+}
+
+func (b *typeBuilder) inputType(schema *jsonschema.Schema, name string) graphql.Input {
+	switch schemaKind(schema) {
+	case "string":
+		return graphql.String
+	case "integer":
+		return graphql.Int
+	case "number":
+		return graphql.Float
+	case "boolean":
+		return graphql.Boolean
+	case "array":
+		if schema.Items == nil {
+			return graphql.NewList(b.jsonType)
+		}
+		return graphql.NewList(b.inputType(schema.Items, name+"Item"))
+	case "object":
+		if len(schema.Properties) == 0 {
+			return b.jsonType
+		}
+		return b.inputObjectType(schema, b.uniqueTypeName(name+"Input"), false, true)
+	}
+	return b.jsonType
+}
+
+// objectType builds an object type for a schema with properties. The top-level
+// object of a content type also carries the entry's system fields.
+func (b *typeBuilder) objectType(schema *jsonschema.Schema, name, description string, topLevel bool) *graphql.Object {
+	fields := graphql.Fields{}
+	if topLevel {
+		fields["id"] = &graphql.Field{Type: graphql.Int}
+		fields["content_type_id"] = &graphql.Field{Type: graphql.Int}
+		fields["status"] = &graphql.Field{Type: graphql.String}
+		fields["created_by"] = &graphql.Field{Type: graphql.Int}
+		fields["created_at"] = &graphql.Field{Type: graphql.DateTime}
+		fields["updated_at"] = &graphql.Field{Type: graphql.DateTime}
+		fields["published_at"] = &graphql.Field{Type: graphql.DateTime}
+		fields["publish_at"] = &graphql.Field{Type: graphql.DateTime}
+		fields["unpublish_at"] = &graphql.Field{Type: graphql.DateTime}
+		fields["version"] = &graphql.Field{Type: graphql.Int}
+		fields["has_unpublished_changes"] = &graphql.Field{Type: graphql.Boolean}
+		fields["stage"] = &graphql.Field{Type: graphql.String}
+	}
+
+	for _, pf := range propertyFields(schema, topLevel) {
+		property := pf.property
+		fields[pf.field] = &graphql.Field{
+			Type: b.outputType(schema.Properties[property], name+pascalCase(property)),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				source, _ := p.Source.(map[string]interface{})
+				if topLevel {
+					source, _ = source[typedDataKey].(map[string]interface{})
+				}
+				return source[property], nil
+			},
+		}
+	}
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        name,
+		Description: description,
+		Fields:      fields,
+	})
+}
+
+// inputObjectType builds an input type for a schema with properties. With
+// enforceRequired unset every field is optional, which is used for partial updates.
+func (b *typeBuilder) inputObjectType(schema *jsonschema.Schema, name string, topLevel, enforceRequired bool) *graphql.InputObject {
+	fields := graphql.InputObjectConfigFieldMap{}
+	for _, pf := range propertyFields(schema, topLevel) {
+		var fieldType graphql.Input = b.inputType(schema.Properties[pf.property], strings.TrimSuffix(name, "Input")+pascalCase(pf.property))
+		if enforceRequired && schema.IsRequired(pf.property) {
+			fieldType = graphql.NewNonNull(fieldType)
+		}
+		fields[pf.field] = &graphql.InputObjectFieldConfig{Type: fieldType}
+	}
+
+	// GraphQL does not allow input objects without fields
+	if len(fields) == 0 {
+		fields["_"] = &graphql.InputObjectFieldConfig{
+			Type:        graphql.Boolean,
+			Description: "Placeholder, the schema declares no properties",
+		}
+	}
+
+	return graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:   name,
+		Fields: fields,
+	})
+}
+
+type propertyField struct {
+	field    string // GraphQL field name
+	property string // JSON property name
+}
+
+// propertyFields maps schema properties to unique, valid GraphQL field names.
+// The mapping is deterministic so inputs can be translated back to properties.
+func propertyFields(schema *jsonschema.Schema, topLevel bool) []propertyField {
+	taken := map[string]bool{}
+	if topLevel {
+		for name := range systemEntryFields {
+			taken[name] = true
+		}
+	}
+
+	var result []propertyField
+	for _, property := range schema.PropertyNames() {
+		// Properties that accept no value cannot be read or written
+		if schema.Properties[property].MatchesNothing {
+			continue
+		}
+		field := sanitizeFieldName(property)
+		if taken[field] {
+			continue
+		}
+		taken[field] = true
+		result = append(result, propertyField{field: field, property: property})
+	}
+	return result
+}
+
+// inputToData converts a GraphQL input value back into JSON data keyed by schema property names
+func inputToData(value interface{}, schema *jsonschema.Schema, topLevel bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if schema == nil || len(schema.Properties) == 0 {
+			return v
+		}
+		result := map[string]interface{}{}
+		for _, pf := range propertyFields(schema, topLevel) {
+			if fieldValue, ok := v[pf.field]; ok {
+				result[pf.property] = inputToData(fieldValue, schema.Properties[pf.property], false)
+			}
+		}
+		return result
+	case []interface{}:
+		var items *jsonschema.Schema
+		if schema != nil {
+			items = schema.Items
+		}
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = inputToData(item, items, false)
+		}
+		return result
+	}
+	return value
+}
+
+// getTypedEntry loads an entry and checks that it belongs to the content type
+func getTypedEntry(s *Schema, id, contentTypeID int) (*models.ContentEntry, error) {
+	entry, err := models.GetContentEntry(s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if entry.ContentTypeID != contentTypeID {
+		return nil, fmt.Errorf("content entry not found")
+	}
+	return entry, nil
+}
+
+// typedEntryToMap converts an entry to the source map of a typed object
+func typedEntryToMap(entry *models.ContentEntry) interface{} {
+	result := entryToMap(entry)
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(entry.Data, &data); err != nil {
+		log.Printf("Failed to decode data of content entry %d: %v", entry.ID, err)
+	}
+	result[typedDataKey] = data
+	return result
+}
+
+func sanitizeFieldName(name string) string {
+	if graphqlNamePattern.MatchString(name) && !strings.HasPrefix(name, "__") {
+		return name
+	}
+	var sb strings.Builder
+	for i, r := range name {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || r == '_'):
+			sb.WriteRune(r)
+		case r < unicode.MaxASCII && unicode.IsDigit(r):
+			if i == 0 {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	result := strings.TrimLeft(sb.String(), "_")
+	if result == "" || unicode.IsDigit(rune(result[0])) {
+		result = "f_" + result
+	}
+	return result
+}
+
+// pascalCase turns "blog-post" or "blog_post" into "BlogPost"
+func pascalCase(s string) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range s {
+		if r >= unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			upper = true
+			continue
+		}
+		if upper {
+			sb.WriteRune(unicode.ToUpper(r))
+			upper = false
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// pluralize applies simple English plural rules to a camelCase name
+func pluralize(s string) string {
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasSuffix(lower, "s"), strings.HasSuffix(lower, "x"), strings.HasSuffix(lower, "z"),
+		strings.HasSuffix(lower, "ch"), strings.HasSuffix(lower, "sh"):
+		return s + "es"
+	case strings.HasSuffix(lower, "y") && len(lower) > 1 && !strings.ContainsRune("aeiou", rune(lower[len(lower)-2])):
+		return s[:len(s)-1] + "ies"
+	}
+	return s + "s"
+}
@@ -0,0 +1,84 @@
+package graphql
+
+import (
+	"fmt"
+
+	"gofrik/internal/jsonschema"
+)
+
+// ValidationError is returned when content data does not match its content type schema.
+// It implements gqlerrors.ExtendedError so violations are exposed under "extensions".
+type ValidationError struct {
+	Violations []jsonschema.Violation
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Violations) == 1 {
+		v := e.Violations[0]
+		return fmt.Sprintf("data does not match content type schema: %s: %s", pointerOrRoot(v.Path), v.Message)
+	}
+	return fmt.Sprintf("data does not match content type schema: %d violations", len(e.Violations))
+}
+
+func (e *ValidationError) Extensions() map[string]interface{} {
+	violations := make([]map[string]interface{}, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		violations = append(violations, map[string]interface{}{
+			"path":    v.Path,
+			"keyword": v.Keyword,
+			"message": v.Message,
+		})
+	}
+	return map[string]interface{}{
+		"code":       "VALIDATION_FAILED",
+		"violations": violations,
+	}
+}
+
+func pointerOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// ForbiddenError is returned when the current user lacks a permission
+type ForbiddenError struct {
+	Permission  string
+	ContentType string
+}
+
+func (e *ForbiddenError) Error() string {
+	if e.ContentType == "" {
+		return fmt.Sprintf("permission denied: %s", e.Permission)
+	}
+	return fmt.Sprintf("permission denied: %s on %s", e.Permission, e.ContentType)
+}
+
+func (e *ForbiddenError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":       "FORBIDDEN",
+		"permission": e.Permission,
+	}
+}
+
+// ConflictError is returned when a mutation's expectedVersion no longer matches.
+// The current server state is included so clients can merge and retry.
+type ConflictError struct {
+	ExpectedVersion int
+	CurrentVersion  int
+	Current         map[string]interface{}
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict: expected version %d, current version is %d", e.ExpectedVersion, e.CurrentVersion)
+}
+
+func (e *ConflictError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":            "CONFLICT",
+		"expectedVersion": e.ExpectedVersion,
+		"currentVersion":  e.CurrentVersion,
+		"current":         e.Current,
+	}
+}
@@ -0,0 +1,239 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+
+	"gofrik/internal/jsonschema"
+	"gofrik/internal/models"
+	"gofrik/internal/query"
+
+	"github.com/graphql-go/graphql"
+)
+
+// getContentWhereType returns the recursive filter input of content queries
+func getContentWhereType(jsonType *graphql.Scalar) *graphql.InputObject {
+	var where *graphql.InputObject
+	where = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "ContentWhere",
+		Description: "A filter on entry data. Conditions on path and the and, or and not filters must all match.",
+		Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+			return graphql.InputObjectConfigFieldMap{
+				"and": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewList(graphql.NewNonNull(where)),
+					Description: "Every filter must match",
+				},
+				"or": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewList(graphql.NewNonNull(where)),
+					Description: "At least one filter must match",
+				},
+				"not": &graphql.InputObjectFieldConfig{
+					Type:        where,
+					Description: "The filter must not match",
+				},
+				"path": &graphql.InputObjectFieldConfig{
+					Type:        graphql.String,
+					Description: "Dot-separated schema property path, such as author.name",
+				},
+				query.OpEq: &graphql.InputObjectFieldConfig{
+					Type: jsonType,
+				},
+				query.OpNe: &graphql.InputObjectFieldConfig{
+					Type: jsonType,
+				},
+				query.OpIn: &graphql.InputObjectFieldConfig{
+					Type: graphql.NewList(graphql.NewNonNull(jsonType)),
+				},
+				query.OpNin: &graphql.InputObjectFieldConfig{
+					Type: graphql.NewList(graphql.NewNonNull(jsonType)),
+				},
+				query.OpLt: &graphql.InputObjectFieldConfig{
+					Type: jsonType,
+				},
+				query.OpLte: &graphql.InputObjectFieldConfig{
+					Type: jsonType,
+				},
+				query.OpGt: &graphql.InputObjectFieldConfig{
+					Type: jsonType,
+				},
+				query.OpGte: &graphql.InputObjectFieldConfig{
+					Type: jsonType,
+				},
+				query.OpContains: &graphql.InputObjectFieldConfig{
+					Type:        jsonType,
+					Description: "Substring of a string, or items of an array, or part of an object",
+				},
+				query.OpStartsWith: &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				query.OpExists: &graphql.InputObjectFieldConfig{
+					Type:        graphql.Boolean,
+					Description: "Whether the property is present, even if it is null",
+				},
+				query.OpIsNull: &graphql.InputObjectFieldConfig{
+					Type:        graphql.Boolean,
+					Description: "Whether the property is missing or null",
+				},
+			}
+		}),
+	})
+	return where
+}
+
+// whereArg filters content queries by entry data
+func whereArg(whereType *graphql.InputObject) *graphql.ArgumentConfig {
+	return &graphql.ArgumentConfig{
+		Type:        whereType,
+		Description: "Only entries whose data matches the filter",
+	}
+}
+
+// getContentSortType returns the input of one content sort key
+func getContentSortType() *graphql.InputObject {
+	return graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "ContentSort",
+		Description: "A sort key: an entry field or a schema property path",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"field": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Entry field (id, created_at, updated_at, published_at, status)",
+			},
+			"path": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Dot-separated path of a string, number, integer or boolean schema property",
+			},
+			"direction": &graphql.InputObjectFieldConfig{
+				Type:         graphql.String,
+				DefaultValue: "ASC",
+				Description:  "ASC or DESC",
+			},
+			"nulls": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "FIRST or LAST (default: last ascending, first descending)",
+			},
+			"collation": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "PostgreSQL collation for text properties, such as en-x-icu or C",
+			},
+		},
+	})
+}
+
+// sortArg orders content queries by one or more keys
+func sortArg(sortType *graphql.InputObject) *graphql.ArgumentConfig {
+	return &graphql.ArgumentConfig{
+		Type:        graphql.NewList(graphql.NewNonNull(sortType)),
+		Description: fmt.Sprintf("Sort keys, up to %d; overrides orderBy and orderDirection", query.MaxSortKeys),
+	}
+}
+
+// entryWhere parses the where argument and validates it against the content
+// type schema. It returns nil when there is no filter.
+func entryWhere(p graphql.ResolveParams, schema *jsonschema.Schema) (*query.Filter, error) {
+	input, ok := p.Args["where"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	filter, err := parseWhere(input)
+	if err != nil {
+		return nil, err
+	}
+	if err := filter.Validate(schema); err != nil {
+		return nil, fmt.Errorf("invalid where: %w", err)
+	}
+	return filter, nil
+}
+
+// parseWhere converts a ContentWhere input into a filter
+func parseWhere(input map[string]interface{}) (*query.Filter, error) {
+	filter := &query.Filter{}
+	filter.Path, _ = input["path"].(string)
+
+	for _, key := range []string{"and", "or"} {
+		children, _ := input[key].([]interface{})
+		for _, child := range children {
+			childInput, ok := child.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid where: %s must be a list of filters", key)
+			}
+			parsed, err := parseWhere(childInput)
+			if err != nil {
+				return nil, err
+			}
+			if key == "and" {
+				filter.And = append(filter.And, parsed)
+			} else {
+				filter.Or = append(filter.Or, parsed)
+			}
+		}
+	}
+
+	if not, ok := input["not"].(map[string]interface{}); ok {
+		parsed, err := parseWhere(not)
+		if err != nil {
+			return nil, err
+		}
+		filter.Not = parsed
+	}
+
+	for _, op := range query.Operators {
+		value, ok := input[op]
+		if !ok || value == nil {
+			continue
+		}
+		filter.Conditions = append(filter.Conditions, query.Condition{Op: op, Value: value})
+	}
+
+	return filter, nil
+}
+
+// entrySort reads the sort argument, or orderBy and orderDirection when it is
+// absent, and validates the keys against the content type schema. orderBy
+// takes an entry field or a schema property path.
+func entrySort(p graphql.ResolveParams, schema *jsonschema.Schema) ([]query.Sort, error) {
+	var keys []query.Sort
+	if inputs, ok := p.Args["sort"].([]interface{}); ok && len(inputs) > 0 {
+		if len(inputs) > query.MaxSortKeys {
+			return nil, fmt.Errorf("at most %d sort keys are allowed", query.MaxSortKeys)
+		}
+		for _, value := range inputs {
+			input, _ := value.(map[string]interface{})
+			key := query.Sort{}
+			key.Field, _ = input["field"].(string)
+			key.Path, _ = input["path"].(string)
+			key.Collation, _ = input["collation"].(string)
+			if nulls, ok := input["nulls"].(string); ok {
+				key.Nulls = strings.ToUpper(nulls)
+			}
+			direction, _ := input["direction"].(string)
+			switch strings.ToUpper(direction) {
+			case "", "ASC":
+			case "DESC":
+				key.Descending = true
+			default:
+				return nil, fmt.Errorf("invalid sort direction %q: must be ASC or DESC", direction)
+			}
+			if key.Field != "" && !models.IsEntrySortColumn(key.Field) {
+				return nil, fmt.Errorf("cannot sort by field %q", key.Field)
+			}
+			keys = append(keys, key)
+		}
+	} else if orderBy, _ := p.Args["orderBy"].(string); orderBy != "" {
+		orderDirection, _ := p.Args["orderDirection"].(string)
+		key := query.Sort{Descending: orderDirection != "ASC"}
+		if models.IsEntrySortColumn(orderBy) {
+			key.Field = orderBy
+		} else {
+			key.Path = orderBy
+		}
+		keys = append(keys, key)
+	}
+
+	for i := range keys {
+		if err := keys[i].Validate(schema); err != nil {
+			return nil, fmt.Errorf("invalid sort: %w", err)
+		}
+	}
+	return keys, nil
+}
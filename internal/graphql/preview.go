@@ -0,0 +1,116 @@
+package graphql
+
+import (
+	"fmt"
+	"time"
+
+	"gofrik/internal/auth"
+	"gofrik/internal/models"
+	"gofrik/internal/reference"
+
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	// defaultPreviewTokenTTL is how long preview tokens last unless expiresIn is given
+	defaultPreviewTokenTTL = time.Hour
+
+	// maxPreviewTokenTTL caps expiresIn so preview links cannot be shared indefinitely
+	maxPreviewTokenTTL = 7 * 24 * time.Hour
+)
+
+func getPreviewTokenType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "PreviewToken",
+		Description: "A signed token that lets its holder read the draft of one entry, and optionally of the entries it references",
+		Fields: graphql.Fields{
+			"token": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Send it in the X-Preview-Token header with preview: true queries",
+			},
+			"entry_id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"include_references": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether the drafts of the entries the entry references can be read too",
+			},
+			"expires_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
+
+func (s *Schema) resolveCreatePreviewToken(p graphql.ResolveParams) (interface{}, error) {
+	if err := requireCaller(p); err != nil {
+		return nil, err
+	}
+
+	entryID, _ := p.Args["entryId"].(int)
+	expiresIn, _ := p.Args["expiresIn"].(int)
+	includeReferences, _ := p.Args["includeReferences"].(bool)
+
+	ttl := defaultPreviewTokenTTL
+	if expiresIn != 0 {
+		ttl = time.Duration(expiresIn) * time.Second
+	}
+	if ttl <= 0 || ttl > maxPreviewTokenTTL {
+		return nil, fmt.Errorf("expiresIn must be between 1 and %d seconds", int(maxPreviewTokenTTL.Seconds()))
+	}
+
+	entry, err := models.GetContentEntry(s.db, entryID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only callers who can preview the entry themselves can share it
+	ct, err := s.authorizeEntry(p, models.PermRead, entry)
+	if err != nil {
+		return nil, err
+	}
+	if includeReferences {
+		if err := s.authorizeReferenceTypes(p, ct); err != nil {
+			return nil, err
+		}
+	}
+
+	grant := auth.PreviewGrant{
+		EntryID:    entry.ID,
+		References: includeReferences,
+		ExpiresAt:  time.Now().UTC().Add(ttl),
+	}
+	token, err := s.preview.Sign(grant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign preview token: %w", err)
+	}
+
+	return map[string]interface{}{
+		"token":              token,
+		"entry_id":           grant.EntryID,
+		"include_references": grant.References,
+		"expires_at":         grant.ExpiresAt,
+	}, nil
+}
+
+// authorizeReferenceTypes requires the read permission on every content type
+// the reference properties of a content type may point to
+func (s *Schema) authorizeReferenceTypes(p graphql.ResolveParams, ct *models.ContentType) error {
+	fields, err := reference.FromSchema(ct.Schema)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		for _, slug := range field.To {
+			target, err := models.GetContentTypeBySlug(s.db, slug)
+			if err != nil {
+				// Nothing can be referenced in content types that do not exist
+				continue
+			}
+			if err := s.authorize(p, models.PermRead, target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
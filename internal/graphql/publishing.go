@@ -0,0 +1,105 @@
+package graphql
+
+import (
+	"fmt"
+	"time"
+
+	"gofrik/internal/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+// transitionResolver returns a resolver that moves the entry given by the id
+// argument to status. Every status change needs the publish permission. If the
+// content type has a workflow, the entry takes a transition from its stage to a
+// stage with that status.
+func (s *Schema) transitionResolver(status string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		id, _ := p.Args["id"].(int)
+
+		entry, err := models.GetContentEntry(s.db, id)
+		if err != nil {
+			return nil, err
+		}
+
+		ct, err := s.authorizeEntry(p, models.PermPublish, entry)
+		if err != nil {
+			return nil, err
+		}
+
+		update := models.EntryUpdate{
+			Status:          status,
+			AuthorID:        actorID(p),
+			ExpectedVersion: expectedVersion(p),
+		}
+
+		w, err := models.GetWorkflow(s.db, ct.ID)
+		if err != nil {
+			return nil, err
+		}
+		if w != nil {
+			if update.Transition, err = s.workflowTransitionTo(p, ct, w, entry, status); err != nil {
+				return nil, err
+			}
+		} else if !models.CanTransition(entry.Status, status) {
+			return nil, &models.TransitionError{From: entry.Status, To: status}
+		}
+
+		updated, err := models.UpdateContentEntry(s.db, id, update, time.Now().UTC())
+		if err != nil {
+			return nil, s.entryConflict(err, id)
+		}
+		return entryToMap(updated), nil
+	}
+}
+
+func (s *Schema) resolveScheduleContent(p graphql.ResolveParams) (interface{}, error) {
+	id, _ := p.Args["id"].(int)
+
+	entry, err := models.GetContentEntry(s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ct, err := s.authorizeEntry(p, models.PermPublish, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Status == models.StatusArchived {
+		return nil, fmt.Errorf("archived entries cannot be scheduled")
+	}
+
+	publishAt := timeArg(p, "publishAt")
+	unpublishAt := timeArg(p, "unpublishAt")
+
+	// With a workflow the scheduler publishes through it; the caller must be
+	// allowed to do that now, approvals are checked when the time comes
+	if publishAt != nil {
+		w, err := models.GetWorkflow(s.db, ct.ID)
+		if err != nil {
+			return nil, err
+		}
+		if w != nil {
+			if _, err := s.workflowTransitionTo(p, ct, w, entry, models.StatusPublished); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	updated, err := models.ScheduleContentEntry(s.db, id, publishAt, unpublishAt, expectedVersion(p))
+	if err != nil {
+		return nil, s.entryConflict(err, id)
+	}
+	return entryToMap(updated), nil
+}
+
+// timeArg returns a DateTime argument in UTC, or nil if it was not given
+func timeArg(p graphql.ResolveParams, name string) *time.Time {
+	t, ok := p.Args[name].(time.Time)
+	if !ok {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
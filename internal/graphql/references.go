@@ -0,0 +1,147 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gofrik/internal/models"
+	"gofrik/internal/reference"
+
+	"github.com/graphql-go/graphql"
+)
+
+// referencePreviewArg switches a reference field to the working drafts of the
+// entries it points to
+func referencePreviewArg() *graphql.ArgumentConfig {
+	return &graphql.ArgumentConfig{
+		Type:         graphql.Boolean,
+		DefaultValue: false,
+		Description:  "Return the working drafts of the referenced entries; needs the read permission on their content type or a preview token for this entry created with includeReferences",
+	}
+}
+
+// addReferencesField adds the references field to the generic ContentEntry type
+func (s *Schema) addReferencesField(contentEntryType *graphql.Object) {
+	referenceType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ContentReference",
+		Description: "An entry referenced by a reference property",
+		Fields: graphql.Fields{
+			"property": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The reference property holding the entry's id",
+			},
+			"entry": &graphql.Field{
+				Type: contentEntryType,
+			},
+		},
+	})
+
+	contentEntryType.AddFieldConfig("references", &graphql.Field{
+		Type:        graphql.NewList(referenceType),
+		Description: "The entries this entry's reference properties point to, in property and then array order; entries that are not published are left out unless preview is set",
+		Args: graphql.FieldConfigArgument{
+			"property": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "Only follow this reference property",
+			},
+			"preview": referencePreviewArg(),
+		},
+		Resolve: s.resolveEntryReferences,
+	})
+}
+
+func (s *Schema) resolveEntryReferences(p graphql.ResolveParams) (interface{}, error) {
+	source, _ := p.Source.(map[string]interface{})
+	parentID, _ := source["id"].(int)
+	contentTypeID, _ := source["content_type_id"].(int)
+	raw, _ := source["data"].(string)
+	property, _ := p.Args["property"].(string)
+
+	ct, err := models.GetContentType(s.db, contentTypeID)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := reference.FromSchema(ct.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("content type %q has invalid references: %w", ct.Slug, err)
+	}
+
+	data := map[string]interface{}{}
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &data); err != nil {
+			return nil, fmt.Errorf("invalid stored data: %w", err)
+		}
+	}
+
+	var results []interface{}
+	for _, field := range fields {
+		if property != "" && field.Property != property {
+			continue
+		}
+		entries, err := s.referencedEntries(p, parentID, field.IDs(data))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			results = append(results, map[string]interface{}{
+				"property": field.Property,
+				"entry":    entryToMap(entry),
+			})
+		}
+	}
+	return results, nil
+}
+
+// resolveReference resolves a typed reference field from the data of its
+// typed object: the referenced entry, or a list of them for multiple references
+func (s *Schema) resolveReference(p graphql.ResolveParams, field reference.Field, toNode func(*models.ContentEntry) interface{}) (interface{}, error) {
+	source, _ := p.Source.(map[string]interface{})
+	parentID, _ := source["id"].(int)
+	data, _ := source[typedDataKey].(map[string]interface{})
+
+	entries, err := s.referencedEntries(p, parentID, field.IDs(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if !field.Multiple {
+		if len(entries) == 0 {
+			return nil, nil
+		}
+		return toNode(entries[0]), nil
+	}
+	nodes := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		nodes[i] = toNode(entry)
+	}
+	return nodes, nil
+}
+
+// referencedEntries returns the readable versions of the entries with the
+// given ids referenced by entry parentID, in order. Entries that no longer
+// exist or are not published outside preview mode are left out.
+func (s *Schema) referencedEntries(p graphql.ResolveParams, parentID int, ids []int) ([]*models.ContentEntry, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	entries, err := models.GetContentEntries(s.db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.ContentEntry
+	for _, id := range ids {
+		entry, ok := entries[id]
+		if !ok {
+			continue
+		}
+		readable, err := s.readableReference(p, parentID, entry)
+		if err != nil {
+			return nil, err
+		}
+		if readable != nil {
+			result = append(result, readable)
+		}
+	}
+	return result, nil
+}
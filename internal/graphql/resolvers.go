@@ -3,9 +3,11 @@ package graphql
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"gofrik/internal/auth"
 	"gofrik/internal/models"
+	"gofrik/internal/query"
 
 	"github.com/graphql-go/graphql"
 )
@@ -19,17 +21,59 @@ func requireAuth(p graphql.ResolveParams) (*auth.Session, error) {
 	return session, nil
 }
 
-// Query Resolvers
-func (s *Schema) resolveContentTypes(p graphql.ResolveParams) (interface{}, error) {
-	// Require authentication
-	if _, err := requireAuth(p); err != nil {
-		return nil, err
+// contentTypeToMap converts a content type to the map format used by GraphQL
+func contentTypeToMap(ct *models.ContentType) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          ct.ID,
+		"name":        ct.Name,
+		"slug":        ct.Slug,
+		"description": ct.Description,
+		"schema":      string(ct.Schema),
+		"created_at":  ct.CreatedAt,
+		"updated_at":  ct.UpdatedAt,
+		"version":     ct.Version,
 	}
-	// Get pagination parameters
-	limit, _ := p.Args["limit"].(int)
-	offset, _ := p.Args["offset"].(int)
-	orderBy, _ := p.Args["orderBy"].(string)
-	orderDirection, _ := p.Args["orderDirection"].(string)
+}
+
+// entryToMap converts a content entry to the map format used by GraphQL
+func entryToMap(entry *models.ContentEntry) map[string]interface{} {
+	result := map[string]interface{}{
+		"id":                      entry.ID,
+		"content_type_id":         entry.ContentTypeID,
+		"data":                    string(entry.Data),
+		"status":                  entry.Status,
+		"created_at":              entry.CreatedAt,
+		"updated_at":              entry.UpdatedAt,
+		"version":                 entry.Version,
+		"has_unpublished_changes": entry.HasUnpublishedChanges(),
+	}
+	if entry.PublishedData != nil {
+		result["published_data"] = string(entry.PublishedData)
+	}
+	if entry.CreatedBy != nil {
+		result["created_by"] = *entry.CreatedBy
+	}
+	if entry.PublishedAt != nil {
+		result["published_at"] = *entry.PublishedAt
+	}
+	if entry.PublishAt != nil {
+		result["publish_at"] = *entry.PublishAt
+	}
+	if entry.UnpublishAt != nil {
+		result["unpublish_at"] = *entry.UnpublishAt
+	}
+	if entry.Stage != nil {
+		result["stage"] = *entry.Stage
+	}
+	return result
+}
+
+// paginationArgs reads limit/offset/orderBy/orderDirection and enforces the limits
+func paginationArgs(p graphql.ResolveParams) (limit, offset int, orderBy, orderDirection string) {
+	limit, _ = p.Args["limit"].(int)
+	offset, _ = p.Args["offset"].(int)
+	orderBy, _ = p.Args["orderBy"].(string)
+	orderDirection, _ = p.Args["orderDirection"].(string)
 
 	// Enforce max limit
 	if limit <= 0 || limit > 100 {
@@ -38,99 +82,87 @@ func (s *Schema) resolveContentTypes(p graphql.ResolveParams) (interface{}, erro
 	if offset < 0 {
 		offset = 0
 	}
+	return limit, offset, orderBy, orderDirection
+}
+
+func pageInfo(totalCount, limit, offset int) map[string]interface{} {
+	return map[string]interface{}{
+		"totalCount": totalCount,
+		"hasMore":    offset+limit < totalCount,
+		"limit":      limit,
+		"offset":     offset,
+	}
+}
+
+// Query Resolvers
+func (s *Schema) resolveContentTypes(p graphql.ResolveParams) (interface{}, error) {
+	// List only the content types the caller may read
+	scope, err := s.readableContentTypes(p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get pagination parameters
+	limit, offset, orderBy, orderDirection := paginationArgs(p)
 
 	// Get total count
-	totalCount, err := models.CountContentTypes(s.db)
+	totalCount, err := models.CountContentTypes(s.db, scope)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get data
-	types, err := models.ListContentTypes(s.db, limit, offset, orderBy, orderDirection)
+	types, err := models.ListContentTypes(s.db, scope, limit, offset, orderBy, orderDirection)
 	if err != nil {
 		return nil, err
 	}
 
 	// Convert to map format for GraphQL
 	var items []map[string]interface{}
-	for _, ct := range types {
-		items = append(items, map[string]interface{}{
-			"id":          ct.ID,
-			"name":        ct.Name,
-			"slug":        ct.Slug,
-			"description": ct.Description,
-			"schema":      string(ct.Schema),
-			"created_at":  ct.CreatedAt,
-			"updated_at":  ct.UpdatedAt,
-		})
+	for i := range types {
+		items = append(items, contentTypeToMap(&types[i]))
 	}
 
-	// Calculate if there are more items
-	hasMore := offset+limit < totalCount
-
 	return map[string]interface{}{
-		"items": items,
-		"pageInfo": map[string]interface{}{
-			"totalCount": totalCount,
-			"hasMore":    hasMore,
-			"limit":      limit,
-			"offset":     offset,
-		},
+		"items":    items,
+		"pageInfo": pageInfo(totalCount, limit, offset),
 	}, nil
 }
 
 func (s *Schema) resolveContentType(p graphql.ResolveParams) (interface{}, error) {
-	// Require authentication
-	if _, err := requireAuth(p); err != nil {
-		return nil, err
-	}
-	
 	id, ok := p.Args["id"].(int)
 	if !ok {
 		return nil, fmt.Errorf("invalid id")
 	}
-	
+
 	ct, err := models.GetContentType(s.db, id)
 	if err != nil {
 		return nil, err
 	}
-	
-	return map[string]interface{}{
-		"id":          ct.ID,
-		"name":        ct.Name,
-		"slug":        ct.Slug,
-		"description": ct.Description,
-		"schema":      string(ct.Schema),
-		"created_at":  ct.CreatedAt,
-		"updated_at":  ct.UpdatedAt,
-	}, nil
-}
 
-func (s *Schema) resolveContentTypeBySlug(p graphql.ResolveParams) (interface{}, error) {
-	// Require authentication
-	if _, err := requireAuth(p); err != nil {
+	if err := s.authorize(p, models.PermRead, ct); err != nil {
 		return nil, err
 	}
-	
+
+	return contentTypeToMap(ct), nil
+}
+
+func (s *Schema) resolveContentTypeBySlug(p graphql.ResolveParams) (interface{}, error) {
 	slug, ok := p.Args["slug"].(string)
 	if !ok {
 		return nil, fmt.Errorf("invalid slug")
 	}
-	
+
 	ct, err := models.GetContentTypeBySlug(s.db, slug)
 	if err != nil {
 		return nil, err
 	}
-	
-	return map[string]interface{}{
-		"id":          ct.ID,
-		"name":        ct.Name,
-		"slug":        ct.Slug,
-		"description": ct.Description,
-		"schema":      string(ct.Schema),
-		"created_at":  ct.CreatedAt,
-		"updated_at":  ct.UpdatedAt,
-	}, nil
+
+	if err := s.authorize(p, models.PermRead, ct); err != nil {
+		return nil, err
+	}
+
+	return contentTypeToMap(ct), nil
 }
 
 func (s *Schema) resolveContent(p graphql.ResolveParams) (interface{}, error) {
@@ -139,267 +171,270 @@ func (s *Schema) resolveContent(p graphql.ResolveParams) (interface{}, error) {
 		return nil, fmt.Errorf("invalid typeSlug")
 	}
 
-	// Get pagination parameters
-	limit, _ := p.Args["limit"].(int)
-	offset, _ := p.Args["offset"].(int)
-	orderBy, _ := p.Args["orderBy"].(string)
-	orderDirection, _ := p.Args["orderDirection"].(string)
-
-	// Enforce max limit
-	if limit <= 0 || limit > 100 {
-		limit = 10
-	}
-	if offset < 0 {
-		offset = 0
-	}
-
 	// Get content type
 	ct, err := models.GetContentTypeBySlug(s.db, typeSlug)
 	if err != nil {
 		return nil, err
 	}
 
+	return s.listEntries(p, ct, func(entry *models.ContentEntry) interface{} {
+		return entryToMap(entry)
+	})
+}
+
+// listEntries lists entries of a content type as a paginated response,
+// converting each entry with toItem
+func (s *Schema) listEntries(p graphql.ResolveParams, ct *models.ContentType, toItem func(*models.ContentEntry) interface{}) (interface{}, error) {
+	filter, order, err := s.entryQuery(p, ct)
+	if err != nil {
+		return nil, err
+	}
+	preview, _ := p.Args["preview"].(bool)
+
+	// Get pagination parameters
+	limit, offset, _, _ := paginationArgs(p)
+
 	// Get total count
-	totalCount, err := models.CountContentEntries(s.db, ct.ID)
+	totalCount, err := models.CountContentEntries(s.db, filter)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get data
-	entries, err := models.ListContentEntries(s.db, ct.ID, limit, offset, orderBy, orderDirection)
+	entries, err := models.ListContentEntries(s.db, filter, order, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 
 	// Convert to map format for GraphQL
-	var items []map[string]interface{}
-	for _, entry := range entries {
-		item := map[string]interface{}{
-			"id":              entry.ID,
-			"content_type_id": entry.ContentTypeID,
-			"data":            string(entry.Data),
-			"status":          entry.Status,
-			"created_at":      entry.CreatedAt,
-			"updated_at":      entry.UpdatedAt,
+	var items []interface{}
+	for i := range entries {
+		entry := &entries[i]
+		if !preview {
+			// Public delivery serves the published snapshot, not the draft
+			entry = entry.Published()
 		}
-		if entry.CreatedBy != nil {
-			item["created_by"] = *entry.CreatedBy
-		}
-		if entry.PublishedAt != nil {
-			item["published_at"] = *entry.PublishedAt
-		}
-		items = append(items, item)
+		items = append(items, toItem(entry))
 	}
 
-	// Calculate if there are more items
-	hasMore := offset+limit < totalCount
-
 	return map[string]interface{}{
-		"items": items,
-		"pageInfo": map[string]interface{}{
-			"totalCount": totalCount,
-			"hasMore":    hasMore,
-			"limit":      limit,
-			"offset":     offset,
-		},
+		"items":    items,
+		"pageInfo": pageInfo(totalCount, limit, offset),
 	}, nil
 }
 
+// entryQuery authorizes a content list query and reads its filter and sort
+// keys. Without preview only published entries are listed.
+func (s *Schema) entryQuery(p graphql.ResolveParams, ct *models.ContentType) (models.EntryFilter, []query.Sort, error) {
+	filter := models.EntryFilter{ContentTypeID: ct.ID}
+	preview, _ := p.Args["preview"].(bool)
+	if preview {
+		if err := s.authorize(p, models.PermRead, ct); err != nil {
+			return filter, nil, err
+		}
+	} else {
+		// Public delivery: only published entries
+		filter.Status = "published"
+	}
+
+	schema, err := compileSchema(ct.Schema)
+	if err != nil {
+		return filter, nil, fmt.Errorf("content type %q has an %w", ct.Slug, err)
+	}
+	where, err := entryWhere(p, schema)
+	if err != nil {
+		return filter, nil, err
+	}
+	filter.Where = where
+	order, err := entrySort(p, schema)
+	if err != nil {
+		return filter, nil, err
+	}
+	return filter, order, nil
+}
+
 func (s *Schema) resolveContentEntry(p graphql.ResolveParams) (interface{}, error) {
 	id, ok := p.Args["id"].(int)
 	if !ok {
 		return nil, fmt.Errorf("invalid id")
 	}
-	
+
 	entry, err := models.GetContentEntry(s.db, id)
 	if err != nil {
 		return nil, err
 	}
-	
-	result := map[string]interface{}{
-		"id":              entry.ID,
-		"content_type_id": entry.ContentTypeID,
-		"data":            string(entry.Data),
-		"status":          entry.Status,
-		"created_at":      entry.CreatedAt,
-		"updated_at":      entry.UpdatedAt,
-	}
-	if entry.CreatedBy != nil {
-		result["created_by"] = *entry.CreatedBy
-	}
-	if entry.PublishedAt != nil {
-		result["published_at"] = *entry.PublishedAt
+
+	entry, err = s.readableEntry(p, entry)
+	if err != nil {
+		return nil, err
 	}
-	
-	return result, nil
+
+	return entryToMap(entry), nil
 }
 
 // Mutation Resolvers
 func (s *Schema) resolveRegister(p graphql.ResolveParams) (interface{}, error) {
 	email, _ := p.Args["email"].(string)
 	password, _ := p.Args["password"].(string)
-	
+
 	if email == "" || password == "" {
 		return nil, fmt.Errorf("email and password are required")
 	}
-	
-	// Check if a user already exists (only allow 1 user total)
+
+	// Registration only bootstraps the first user, who becomes admin;
+	// everyone else joins through an invitation
 	count, err := models.CountUsers(s.db)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	if count >= 1 {
-		return nil, fmt.Errorf("registration is not allowed: a user is already registered")
+		return nil, fmt.Errorf("registration is closed: ask an existing user for an invitation")
 	}
-	
-	user, err := models.CreateUser(s.db, email, password)
+
+	user, err := models.CreateUser(s.db, email, password, models.RoleAdmin)
 	if err != nil {
 		return nil, err
 	}
-	
-	return map[string]interface{}{
-		"id":         user.ID,
-		"email":      user.Email,
-		"created_at": user.CreatedAt,
-		"updated_at": user.UpdatedAt,
-	}, nil
+
+	return userToMap(user), nil
 }
 
 func (s *Schema) resolveLogin(p graphql.ResolveParams) (interface{}, error) {
 	email, _ := p.Args["email"].(string)
 	password, _ := p.Args["password"].(string)
-	
+
 	user, err := models.GetUserByEmail(s.db, email)
 	if err != nil {
 		return nil, fmt.Errorf("invalid credentials")
 	}
-	
-	if !user.CheckPassword(password) {
+
+	if !user.CheckPassword(password) || !user.Active {
 		return nil, fmt.Errorf("invalid credentials")
 	}
-	
-	token, err := s.auth.CreateSession(user.ID, user.Email)
+
+	return s.startSession(p, user)
+}
+
+// startSession creates a session for user and returns the AuthPayload
+func (s *Schema) startSession(p graphql.ResolveParams, user *models.User) (interface{}, error) {
+	client, _ := p.Context.Value("client").(auth.ClientInfo)
+	token, err := s.auth.CreateSession(user.ID, user.Email, client)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	return map[string]interface{}{
 		"token": token,
-		"user": map[string]interface{}{
-			"id":         user.ID,
-			"email":      user.Email,
-			"created_at": user.CreatedAt,
-			"updated_at": user.UpdatedAt,
-		},
+		"user":  userToMap(user),
 	}, nil
 }
 
 func (s *Schema) resolveCreateContentType(p graphql.ResolveParams) (interface{}, error) {
-	// Require authentication
-	if _, err := requireAuth(p); err != nil {
+	// Creating content types requires a global manage_schema grant
+	if err := s.authorize(p, models.PermManageSchema, nil); err != nil {
 		return nil, err
 	}
-	
+
 	name, _ := p.Args["name"].(string)
 	slug, _ := p.Args["slug"].(string)
 	description, _ := p.Args["description"].(string)
 	schemaStr, _ := p.Args["schema"].(string)
-	
+
 	if name == "" || slug == "" || schemaStr == "" {
 		return nil, fmt.Errorf("name, slug, and schema are required")
 	}
-	
+
 	// Validate JSON schema
-	var schemaData interface{}
-	if err := json.Unmarshal([]byte(schemaStr), &schemaData); err != nil {
-		return nil, fmt.Errorf("invalid schema JSON: %w", err)
+	if err := checkContentTypeSchema([]byte(schemaStr)); err != nil {
+		return nil, err
+	}
+	if err := s.checkReferenceTypes(slug, []byte(schemaStr)); err != nil {
+		return nil, err
 	}
-	
+
 	ct, err := models.CreateContentType(s.db, name, slug, description, json.RawMessage(schemaStr))
 	if err != nil {
 		return nil, err
 	}
-	
-	return map[string]interface{}{
-		"id":          ct.ID,
-		"name":        ct.Name,
-		"slug":        ct.Slug,
-		"description": ct.Description,
-		"schema":      string(ct.Schema),
-		"created_at":  ct.CreatedAt,
-		"updated_at":  ct.UpdatedAt,
-	}, nil
+
+	// Expose the typed API for the new content type
+	s.rebuildLogged()
+
+	return contentTypeToMap(ct), nil
 }
 
 func (s *Schema) resolveUpdateContentType(p graphql.ResolveParams) (interface{}, error) {
-	// Require authentication
-	if _, err := requireAuth(p); err != nil {
-		return nil, err
-	}
-	
 	id, _ := p.Args["id"].(int)
-	
+
 	// Get existing content type
 	ct, err := models.GetContentType(s.db, id)
 	if err != nil {
 		return nil, err
 	}
-	
+
+	if err := s.authorize(p, models.PermManageSchema, ct); err != nil {
+		return nil, err
+	}
+
 	// Update fields if provided
 	name := ct.Name
 	if n, ok := p.Args["name"].(string); ok && n != "" {
 		name = n
 	}
-	
+
 	description := ct.Description
 	if d, ok := p.Args["description"].(string); ok {
 		description = d
 	}
-	
+
 	schema := ct.Schema
 	if s, ok := p.Args["schema"].(string); ok && s != "" {
-		// Validate JSON
-		var schemaData interface{}
-		if err := json.Unmarshal([]byte(s), &schemaData); err != nil {
-			return nil, fmt.Errorf("invalid schema JSON: %w", err)
+		// Validate JSON schema
+		if err := checkContentTypeSchema([]byte(s)); err != nil {
+			return nil, err
 		}
 		schema = json.RawMessage(s)
 	}
-	
-	if err := models.UpdateContentType(s.db, id, name, description, schema); err != nil {
+	if err := s.checkReferenceTypes(ct.Slug, schema); err != nil {
 		return nil, err
 	}
-	
+
+	if err := models.UpdateContentType(s.db, id, name, description, schema, expectedVersion(p)); err != nil {
+		return nil, s.contentTypeConflict(err, id)
+	}
+
 	// Fetch updated content type
 	updated, err := models.GetContentType(s.db, id)
 	if err != nil {
 		return nil, err
 	}
-	
-	return map[string]interface{}{
-		"id":          updated.ID,
-		"name":        updated.Name,
-		"slug":        updated.Slug,
-		"description": updated.Description,
-		"schema":      string(updated.Schema),
-		"created_at":  updated.CreatedAt,
-		"updated_at":  updated.UpdatedAt,
-	}, nil
+
+	// Regenerate the typed API from the new schema
+	s.rebuildLogged()
+
+	return contentTypeToMap(updated), nil
 }
 
 func (s *Schema) resolveDeleteContentType(p graphql.ResolveParams) (interface{}, error) {
-	// Require authentication
-	if _, err := requireAuth(p); err != nil {
-		return nil, err
-	}
-	
 	id, _ := p.Args["id"].(int)
-	
-	if err := models.DeleteContentType(s.db, id); err != nil {
+
+	ct, err := models.GetContentType(s.db, id)
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.authorize(p, models.PermManageSchema, ct); err != nil {
+		return false, err
+	}
+
+	if err := models.DeleteContentType(s.db, id, s.authorizeDeletion(p), time.Now().UTC()); err != nil {
 		return false, err
 	}
-	
+
+	// Drop the typed API of the deleted content type
+	s.rebuildLogged()
+
 	return true, nil
 }
 
@@ -407,116 +442,154 @@ func (s *Schema) resolveCreateContent(p graphql.ResolveParams) (interface{}, err
 	typeSlug, _ := p.Args["typeSlug"].(string)
 	dataStr, _ := p.Args["data"].(string)
 	status, _ := p.Args["status"].(string)
-	
+
 	if typeSlug == "" || dataStr == "" {
 		return nil, fmt.Errorf("typeSlug and data are required")
 	}
-	
-	if status == "" {
-		status = "draft"
-	}
-	
+
 	// Get content type
 	ct, err := models.GetContentTypeBySlug(s.db, typeSlug)
 	if err != nil {
 		return nil, err
 	}
-	
-	// Validate JSON data
-	var data interface{}
-	if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
-		return nil, fmt.Errorf("invalid data JSON: %w", err)
-	}
-	
-	// Get user from context if available
-	var createdBy *int
-	if session, ok := p.Context.Value("session").(*auth.Session); ok && session != nil {
-		createdBy = &session.UserID
-	}
-	
-	entry, err := models.CreateContentEntry(s.db, ct.ID, json.RawMessage(dataStr), status, createdBy)
+
+	entry, err := s.createEntry(p, ct, json.RawMessage(dataStr), status)
 	if err != nil {
 		return nil, err
 	}
-	
-	result := map[string]interface{}{
-		"id":              entry.ID,
-		"content_type_id": entry.ContentTypeID,
-		"data":            string(entry.Data),
-		"status":          entry.Status,
-		"created_at":      entry.CreatedAt,
-		"updated_at":      entry.UpdatedAt,
+
+	return entryToMap(entry), nil
+}
+
+// createEntry validates data and creates a new entry of the given content type
+func (s *Schema) createEntry(p graphql.ResolveParams, ct *models.ContentType, data json.RawMessage, status string) (*models.ContentEntry, error) {
+	message, _ := p.Args["message"].(string)
+
+	if status == "" {
+		status = models.StatusDraft
 	}
-	if entry.CreatedBy != nil {
-		result["created_by"] = *entry.CreatedBy
+	if status != models.StatusDraft && status != models.StatusPublished {
+		return nil, fmt.Errorf("invalid status %q (new entries are draft or published)", status)
 	}
-	if entry.PublishedAt != nil {
-		result["published_at"] = *entry.PublishedAt
+
+	if err := s.authorize(p, models.PermCreate, ct); err != nil {
+		return nil, err
+	}
+	// Creating an entry that is already published also needs publish rights
+	if status == models.StatusPublished {
+		if err := s.authorize(p, models.PermPublish, ct); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate data against the content type schema
+	if err := s.validateEntryData(ct, data); err != nil {
+		return nil, err
 	}
-	
-	return result, nil
+
+	return models.CreateContentEntry(s.db, ct.ID, data, status, actorID(p), message, time.Now().UTC())
 }
 
 func (s *Schema) resolveUpdateContent(p graphql.ResolveParams) (interface{}, error) {
 	id, _ := p.Args["id"].(int)
-	
+
 	// Get existing entry
 	entry, err := models.GetContentEntry(s.db, id)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	// Update fields if provided
-	data := entry.Data
+	var data json.RawMessage
 	if d, ok := p.Args["data"].(string); ok && d != "" {
-		// Validate JSON
-		var jsonData interface{}
-		if err := json.Unmarshal([]byte(d), &jsonData); err != nil {
-			return nil, fmt.Errorf("invalid data JSON: %w", err)
-		}
 		data = json.RawMessage(d)
 	}
-	
-	status := entry.Status
-	if s, ok := p.Args["status"].(string); ok && s != "" {
-		status = s
-	}
-	
-	if err := models.UpdateContentEntry(s.db, id, data, status); err != nil {
+	status, _ := p.Args["status"].(string)
+
+	updated, err := s.updateEntry(p, entry, data, status, expectedVersion(p))
+	if err != nil {
 		return nil, err
 	}
-	
-	// Fetch updated entry
-	updated, err := models.GetContentEntry(s.db, id)
+
+	return entryToMap(updated), nil
+}
+
+// updateEntry validates and saves new data and/or status for an existing entry.
+// Nil data or an empty status keeps the current value. Changing data needs the
+// update permission and changing status needs the publish permission and an
+// allowed transition. A non-nil expectedVersion must match the stored version.
+func (s *Schema) updateEntry(p graphql.ResolveParams, entry *models.ContentEntry, data json.RawMessage, status string, expectedVersion *int) (*models.ContentEntry, error) {
+	ct, err := models.GetContentType(s.db, entry.ContentTypeID)
 	if err != nil {
 		return nil, err
 	}
-	
-	result := map[string]interface{}{
-		"id":              updated.ID,
-		"content_type_id": updated.ContentTypeID,
-		"data":            string(updated.Data),
-		"status":          updated.Status,
-		"created_at":      updated.CreatedAt,
-		"updated_at":      updated.UpdatedAt,
+
+	if data != nil {
+		if err := s.authorize(p, models.PermUpdate, ct); err != nil {
+			return nil, err
+		}
+		// Validate data against the content type schema
+		if err := s.validateEntryData(ct, data); err != nil {
+			return nil, err
+		}
 	}
-	if updated.CreatedBy != nil {
-		result["created_by"] = *updated.CreatedBy
+
+	message, _ := p.Args["message"].(string)
+	update := models.EntryUpdate{
+		Data:            data,
+		Status:          status,
+		AuthorID:        actorID(p),
+		Message:         message,
+		ExpectedVersion: expectedVersion,
 	}
-	if updated.PublishedAt != nil {
-		result["published_at"] = *updated.PublishedAt
+
+	// Publishing an already published entry promotes its draft, so it is a
+	// status change too
+	if status != "" && (status != entry.Status || status == models.StatusPublished) {
+		w, err := models.GetWorkflow(s.db, ct.ID)
+		if err != nil {
+			return nil, err
+		}
+		if w != nil {
+			if update.Transition, err = s.workflowTransitionTo(p, ct, w, entry, status); err != nil {
+				return nil, err
+			}
+			// Approvals are for the current revision, not for data saved with the move
+			if data != nil && update.Transition.RequiredApprovals > 0 {
+				return nil, &models.WorkflowError{Reason: "save the data first, the move needs approvals of it"}
+			}
+		} else {
+			if !models.CanTransition(entry.Status, status) {
+				return nil, &models.TransitionError{From: entry.Status, To: status}
+			}
+			if err := s.authorize(p, models.PermPublish, ct); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	updated, err := models.UpdateContentEntry(s.db, entry.ID, update, time.Now().UTC())
+	if err != nil {
+		return nil, s.entryConflict(err, entry.ID)
 	}
-	
-	return result, nil
+	return updated, nil
 }
 
 func (s *Schema) resolveDeleteContent(p graphql.ResolveParams) (interface{}, error) {
 	id, _ := p.Args["id"].(int)
-	
-	if err := models.DeleteContentEntry(s.db, id); err != nil {
+
+	entry, err := models.GetContentEntry(s.db, id)
+	if err != nil {
 		return false, err
 	}
-	
+
+	if _, err := s.authorizeEntry(p, models.PermDelete, entry); err != nil {
+		return false, err
+	}
+
+	if err := models.DeleteContentEntry(s.db, id, expectedVersion(p), s.authorizeDeletion(p), time.Now().UTC()); err != nil {
+		return false, s.entryConflict(err, id)
+	}
+
 	return true, nil
 }
-
@@ -0,0 +1,229 @@
+package graphql
+
+import (
+	"fmt"
+	"time"
+
+	"gofrik/internal/jsondiff"
+	"gofrik/internal/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+func revisionToMap(rev *models.ContentRevision) map[string]interface{} {
+	result := map[string]interface{}{
+		"id":         rev.ID,
+		"entry_id":   rev.EntryID,
+		"revision":   rev.Revision,
+		"data":       string(rev.Data),
+		"status":     rev.Status,
+		"message":    rev.Message,
+		"created_at": rev.CreatedAt,
+	}
+	if rev.AuthorID != nil {
+		result["author_id"] = *rev.AuthorID
+	}
+	if rev.RestoredFrom != nil {
+		result["restored_from"] = *rev.RestoredFrom
+	}
+	return result
+}
+
+func (s *Schema) getContentRevisionType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ContentRevision",
+		Description: "An immutable snapshot of a content entry, recorded on every save",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"entry_id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"revision": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Revision number, counting from 1 for each entry",
+			},
+			"data": &graphql.Field{
+				Type: graphql.String,
+			},
+			"status": &graphql.Field{
+				Type: graphql.String,
+			},
+			"author_id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"message": &graphql.Field{
+				Type: graphql.String,
+			},
+			"restored_from": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "ID of the revision this one restored",
+			},
+			"created_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
+
+func getContentRevisionsResponseType(revisionType *graphql.Object, pageInfoType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ContentRevisionsResponse",
+		Description: "List of revisions with pagination info",
+		Fields: graphql.Fields{
+			"items": &graphql.Field{
+				Type: graphql.NewList(revisionType),
+			},
+			"pageInfo": &graphql.Field{
+				Type: pageInfoType,
+			},
+		},
+	})
+}
+
+func getJSONChangeType(jsonType *graphql.Scalar) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "JSONChange",
+		Description: "A difference between two JSON documents",
+		Fields: graphql.Fields{
+			"op": &graphql.Field{
+				Type:        graphql.String,
+				Description: "add, remove or replace",
+			},
+			"path": &graphql.Field{
+				Type:        graphql.String,
+				Description: "JSON pointer to the changed value",
+			},
+			"from": &graphql.Field{
+				Type:        jsonType,
+				Description: "Previous value; null for additions",
+			},
+			"to": &graphql.Field{
+				Type:        jsonType,
+				Description: "New value; null for removals",
+			},
+		},
+	})
+}
+
+// authorizeRevision loads a revision and requires read access to its entry
+func (s *Schema) authorizeRevision(p graphql.ResolveParams, id int) (*models.ContentRevision, error) {
+	rev, err := models.GetContentRevision(s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := models.GetContentEntry(s.db, rev.EntryID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorizeEntry(p, models.PermRead, entry); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+func (s *Schema) resolveContentRevisions(p graphql.ResolveParams) (interface{}, error) {
+	entryID, _ := p.Args["entryId"].(int)
+
+	entry, err := models.GetContentEntry(s.db, entryID)
+	if err != nil {
+		return nil, err
+	}
+
+	// History includes drafts, so it needs the read permission
+	if _, err := s.authorizeEntry(p, models.PermRead, entry); err != nil {
+		return nil, err
+	}
+
+	limit, offset, _, _ := paginationArgs(p)
+
+	totalCount, err := models.CountContentRevisions(s.db, entryID)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := models.ListContentRevisions(s.db, entryID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []map[string]interface{}
+	for i := range revisions {
+		items = append(items, revisionToMap(&revisions[i]))
+	}
+
+	return map[string]interface{}{
+		"items":    items,
+		"pageInfo": pageInfo(totalCount, limit, offset),
+	}, nil
+}
+
+func (s *Schema) resolveContentRevisionDiff(p graphql.ResolveParams) (interface{}, error) {
+	fromID, _ := p.Args["fromRevisionId"].(int)
+	toID, _ := p.Args["toRevisionId"].(int)
+
+	from, err := s.authorizeRevision(p, fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.authorizeRevision(p, toID)
+	if err != nil {
+		return nil, err
+	}
+	if from.EntryID != to.EntryID {
+		return nil, fmt.Errorf("revisions belong to different entries")
+	}
+
+	changes, err := jsondiff.Diff(from.Data, to.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]map[string]interface{}, 0, len(changes))
+	for _, c := range changes {
+		items = append(items, map[string]interface{}{
+			"op":   c.Op,
+			"path": pointerOrRoot(c.Path),
+			"from": c.From,
+			"to":   c.To,
+		})
+	}
+	return items, nil
+}
+
+func (s *Schema) resolveRestoreRevision(p graphql.ResolveParams) (interface{}, error) {
+	entryID, _ := p.Args["entryId"].(int)
+	revisionID, _ := p.Args["revisionId"].(int)
+	message, _ := p.Args["message"].(string)
+
+	rev, err := models.GetContentRevision(s.db, revisionID)
+	if err != nil {
+		return nil, err
+	}
+	if rev.EntryID != entryID {
+		return nil, fmt.Errorf("revision not found")
+	}
+
+	entry, err := models.GetContentEntry(s.db, entryID)
+	if err != nil {
+		return nil, err
+	}
+
+	ct, err := s.authorizeEntry(p, models.PermUpdate, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// The schema may have changed since the revision was saved
+	if err := s.validateEntryData(ct, rev.Data); err != nil {
+		return nil, err
+	}
+
+	restored, err := models.RestoreContentRevision(s.db, rev, actorID(p), message, expectedVersion(p), time.Now().UTC())
+	if err != nil {
+		return nil, s.entryConflict(err, entryID)
+	}
+	return entryToMap(restored), nil
+}
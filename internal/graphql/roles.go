@@ -0,0 +1,195 @@
+package graphql
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gofrik/internal/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,49}$`)
+
+func roleToMap(role *models.Role) map[string]interface{} {
+	permissions := make([]map[string]interface{}, 0, len(role.Permissions))
+	for _, rp := range role.Permissions {
+		permission := map[string]interface{}{
+			"permission": rp.Permission,
+		}
+		if rp.ContentTypeID != nil {
+			permission["content_type_id"] = *rp.ContentTypeID
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return map[string]interface{}{
+		"name":        role.Name,
+		"description": role.Description,
+		"builtin":     role.Builtin,
+		"permissions": permissions,
+		"created_at":  role.CreatedAt,
+	}
+}
+
+// permissionArgs are the arguments of grantPermission and revokePermission
+func permissionArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"role": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+		"permission": &graphql.ArgumentConfig{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "read, create, update, publish, delete or manage_schema",
+		},
+		"typeSlug": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "Content type to scope the permission to; omit for every content type",
+		},
+	}
+}
+
+func (s *Schema) resolveRoles(p graphql.ResolveParams) (interface{}, error) {
+	// Role management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return nil, err
+	}
+
+	roles, err := models.ListRoles(s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []map[string]interface{}
+	for i := range roles {
+		items = append(items, roleToMap(&roles[i]))
+	}
+	return items, nil
+}
+
+func (s *Schema) resolveCreateRole(p graphql.ResolveParams) (interface{}, error) {
+	// Role management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(p.Args["name"].(string))
+	description, _ := p.Args["description"].(string)
+
+	if !roleNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid role name %q (lowercase letters, digits, - and _)", name)
+	}
+	if _, err := models.GetRole(s.db, name); err == nil {
+		return nil, fmt.Errorf("role %q already exists", name)
+	}
+
+	role, err := models.CreateRole(s.db, name, description)
+	if err != nil {
+		return nil, err
+	}
+	return roleToMap(role), nil
+}
+
+func (s *Schema) resolveDeleteRole(p graphql.ResolveParams) (interface{}, error) {
+	// Role management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return false, err
+	}
+
+	name, _ := p.Args["name"].(string)
+	if err := models.DeleteRole(s.db, name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Schema) resolveGrantPermission(p graphql.ResolveParams) (interface{}, error) {
+	return s.changePermission(p, models.GrantPermission)
+}
+
+func (s *Schema) resolveRevokePermission(p graphql.ResolveParams) (interface{}, error) {
+	return s.changePermission(p, models.RevokePermission)
+}
+
+// changePermission validates the permission arguments, applies change and returns the updated role
+func (s *Schema) changePermission(p graphql.ResolveParams, change func(db *sql.DB, role, permission string, contentTypeID *int) error) (interface{}, error) {
+	// Role management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return nil, err
+	}
+
+	roleName, _ := p.Args["role"].(string)
+	permission, _ := p.Args["permission"].(string)
+	typeSlug, _ := p.Args["typeSlug"].(string)
+
+	if roleName == models.RoleAdmin {
+		return nil, fmt.Errorf("the admin role always has every permission")
+	}
+	if !models.IsValidPermission(permission) {
+		return nil, fmt.Errorf("invalid permission %q (use one of: %s)", permission, strings.Join(models.Permissions, ", "))
+	}
+	if _, err := models.GetRole(s.db, roleName); err != nil {
+		return nil, err
+	}
+
+	var contentTypeID *int
+	if typeSlug != "" {
+		ct, err := models.GetContentTypeBySlug(s.db, typeSlug)
+		if err != nil {
+			return nil, err
+		}
+		contentTypeID = &ct.ID
+	}
+
+	if err := change(s.db, roleName, permission, contentTypeID); err != nil {
+		return nil, err
+	}
+
+	role, err := models.GetRole(s.db, roleName)
+	if err != nil {
+		return nil, err
+	}
+	return roleToMap(role), nil
+}
+
+func (s *Schema) resolveAssignRole(p graphql.ResolveParams) (interface{}, error) {
+	// Role management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return nil, err
+	}
+
+	userID, _ := p.Args["userId"].(int)
+	roleName, _ := p.Args["role"].(string)
+
+	if _, err := models.GetRole(s.db, roleName); err != nil {
+		return nil, fmt.Errorf("invalid role %q", roleName)
+	}
+
+	user, err := models.GetUserByID(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never leave the instance without an active admin
+	if user.Role == models.RoleAdmin && roleName != models.RoleAdmin && user.Active {
+		admins, err := models.CountActiveAdmins(s.db)
+		if err != nil {
+			return nil, err
+		}
+		if admins <= 1 {
+			return nil, fmt.Errorf("cannot remove the last admin")
+		}
+	}
+
+	if err := models.SetUserRole(s.db, userID, roleName); err != nil {
+		return nil, err
+	}
+
+	user, err = models.GetUserByID(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return userToMap(user), nil
+}
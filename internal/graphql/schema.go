@@ -1,33 +1,148 @@
 package graphql
 
 import (
+	"context"
 	"database/sql"
+	"log"
+	"sync"
+	"time"
 
 	"gofrik/internal/auth"
+	"gofrik/internal/imaging"
+	"gofrik/internal/models"
+	"gofrik/internal/storage"
 
 	"github.com/graphql-go/graphql"
 )
 
 type Schema struct {
-	db     *sql.DB
-	auth   *auth.Middleware
+	db      *sql.DB
+	auth    *auth.Middleware
+	preview *auth.PreviewSigner
+	// storage holds the files of assets; nil when storage is not configured
+	storage *storage.Storage
+	// images signs the URLs of image variants
+	images *imaging.Signer
+	mu     sync.RWMutex
 	schema graphql.Schema
+	// version is the schema version the schema was built from
+	version int64
 }
 
-func NewSchema(db *sql.DB, authMW *auth.Middleware) (*Schema, error) {
+func NewSchema(db *sql.DB, authMW *auth.Middleware, preview *auth.PreviewSigner, storageClient *storage.Storage, images *imaging.Signer) (*Schema, error) {
 	s := &Schema{
-		db:   db,
-		auth: authMW,
+		db:      db,
+		auth:    authMW,
+		preview: preview,
+		storage: storageClient,
+		images:  images,
 	}
-	
+
+	if err := s.Rebuild(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Rebuild regenerates the GraphQL schema from the content types currently in
+// the database. It is called on startup, whenever a content type changes and
+// when RunSync notices that another server changed one.
+func (s *Schema) Rebuild() error {
+	// Read the version first, so that changes made while building bump it
+	// past the one recorded and are picked up by the next sync
+	version, err := models.GetSchemaVersion(s.db)
+	if err != nil {
+		return err
+	}
+	contentTypes, err := models.ListAllContentTypes(s.db)
+	if err != nil {
+		return err
+	}
+
+	schema, err := s.build(contentTypes)
+	if err != nil {
+		// A content type that cannot be expressed in GraphQL must not take the
+		// whole API down, so fall back to the generic schema
+		log.Printf("Failed to build typed GraphQL schema, serving generic schema only: %v", err)
+		schema, err = s.build(nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A concurrent rebuild may have finished first with newer content types
+	if version < s.version {
+		return nil
+	}
+	s.schema = schema
+	s.version = version
+	return nil
+}
+
+// rebuildLogged rebuilds the schema after a content type mutation; failures are
+// logged rather than returned because the mutation itself already succeeded
+func (s *Schema) rebuildLogged() {
+	if err := s.Rebuild(); err != nil {
+		log.Printf("Failed to rebuild GraphQL schema: %v", err)
+	}
+}
+
+// RunSync checks the schema version every interval and rebuilds the schema
+// when content types were changed, possibly through another server, until ctx
+// is cancelled
+func (s *Schema) RunSync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			version, err := models.GetSchemaVersion(s.db)
+			if err != nil {
+				log.Printf("Failed to check schema version: %v", err)
+				continue
+			}
+			s.mu.RLock()
+			stale := version > s.version
+			s.mu.RUnlock()
+			if stale {
+				s.rebuildLogged()
+			}
+		}
+	}
+}
+
+// build assembles the generic API plus a typed API for every content type
+func (s *Schema) build(contentTypes []models.ContentType) (graphql.Schema, error) {
 	// Define types
 	userType := s.getUserType()
+	sessionType := s.getSessionType()
+	authPayloadType := getAuthPayloadType(userType)
+	invitationType := s.getInvitationType()
+	roleType := s.getRoleType()
+	apiKeyType := s.getAPIKeyType()
+	apiKeyPayloadType := getAPIKeyPayloadType(apiKeyType)
+	previewTokenType := getPreviewTokenType()
 	contentTypeType := s.getContentTypeType()
 	contentEntryType := s.getContentEntryType()
+	s.addReferencesField(contentEntryType)
 	pageInfoType := getPageInfoType()
+	jsonType := getJSONScalar()
+	whereType := getContentWhereType(jsonType)
+	sortType := getContentSortType()
+	connectionPageInfoType := getConnectionPageInfoType()
 	contentTypesResponseType := getContentTypesResponseType(contentTypeType, pageInfoType)
 	contentEntriesResponseType := getContentEntriesResponseType(contentEntryType, pageInfoType)
-	
+	searchResponseType := getSearchResponseType(contentEntryType, pageInfoType)
+	contentRevisionType := s.getContentRevisionType()
+	workflowType := s.getWorkflowType()
+	contentReviewType := getContentReviewType()
+	assetType := s.getAssetType()
+
 	// Define root query
 	rootQuery := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Query",
@@ -59,6 +174,23 @@ func NewSchema(db *sql.DB, authMW *auth.Middleware) (*Schema, error) {
 				},
 				Resolve: s.resolveContentTypes,
 			},
+			"contentTypesConnection": &graphql.Field{
+				Type:        newConnectionType("ContentType", contentTypeType, connectionPageInfoType),
+				Description: "Get content types as a Relay connection with cursor pagination",
+				Args: withArgs(connectionArgs(), graphql.FieldConfigArgument{
+					"orderBy": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "created_at",
+						Description:  "Field to order by (id, name, slug, created_at, updated_at)",
+					},
+					"orderDirection": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "DESC",
+						Description:  "Order direction (ASC or DESC)",
+					},
+				}),
+				Resolve: s.resolveContentTypesConnection,
+			},
 			"contentType": &graphql.Field{
 				Type:        contentTypeType,
 				Description: "Get a content type by ID",
@@ -81,12 +213,50 @@ func NewSchema(db *sql.DB, authMW *auth.Middleware) (*Schema, error) {
 			},
 			"content": &graphql.Field{
 				Type:        contentEntriesResponseType,
-				Description: "Get content entries by type slug",
-				Args: graphql.FieldConfigArgument{
+				Description: "Get content entries by type slug; only published entries unless preview is set",
+				Args: withArgs(listArgs(whereType, sortType), graphql.FieldConfigArgument{
+					"typeSlug": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(graphql.String),
+						Description: "Content type slug",
+					},
+				}),
+				Resolve: s.resolveContent,
+			},
+			"contentConnection": &graphql.Field{
+				Type:        newConnectionType("ContentEntry", contentEntryType, connectionPageInfoType),
+				Description: "Get content entries by type slug as a Relay connection with cursor pagination; only published entries unless preview is set",
+				Args: withArgs(withArgs(connectionArgs(), entryQueryArgs(whereType, sortType)), graphql.FieldConfigArgument{
 					"typeSlug": &graphql.ArgumentConfig{
 						Type:        graphql.NewNonNull(graphql.String),
 						Description: "Content type slug",
 					},
+				}),
+				Resolve: s.resolveContentConnection,
+			},
+			"search": &graphql.Field{
+				Type:        searchResponseType,
+				Description: "Full-text search over published entries, best matches first",
+				Args:        searchArgs(),
+				Resolve:     s.resolveSearch,
+			},
+			"contentEntry": &graphql.Field{
+				Type:        contentEntryType,
+				Description: "Get a content entry by ID",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"preview": previewArg(),
+				},
+				Resolve: s.resolveContentEntry,
+			},
+			"contentRevisions": &graphql.Field{
+				Type:        getContentRevisionsResponseType(contentRevisionType, pageInfoType),
+				Description: "List the revisions of a content entry, newest first",
+				Args: graphql.FieldConfigArgument{
+					"entryId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
 					"limit": &graphql.ArgumentConfig{
 						Type:         graphql.Int,
 						DefaultValue: 10,
@@ -97,39 +267,103 @@ func NewSchema(db *sql.DB, authMW *auth.Middleware) (*Schema, error) {
 						DefaultValue: 0,
 						Description:  "Number of items to skip (default: 0)",
 					},
-					"orderBy": &graphql.ArgumentConfig{
-						Type:         graphql.String,
-						DefaultValue: "created_at",
-						Description:  "Field to order by (id, created_at, updated_at, published_at, status)",
+				},
+				Resolve: s.resolveContentRevisions,
+			},
+			"contentRevisionDiff": &graphql.Field{
+				Type:        graphql.NewList(getJSONChangeType(jsonType)),
+				Description: "Compare the data of two revisions of the same entry",
+				Args: graphql.FieldConfigArgument{
+					"fromRevisionId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
 					},
-					"orderDirection": &graphql.ArgumentConfig{
-						Type:         graphql.String,
-						DefaultValue: "DESC",
-						Description:  "Order direction (ASC or DESC)",
+					"toRevisionId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
 					},
 				},
-				Resolve: s.resolveContent,
+				Resolve: s.resolveContentRevisionDiff,
 			},
-			"contentEntry": &graphql.Field{
-				Type:        contentEntryType,
-				Description: "Get a content entry by ID",
+			"workflow": &graphql.Field{
+				Type:        workflowType,
+				Description: "Get the workflow of a content type; null if it has none",
+				Args: graphql.FieldConfigArgument{
+					"typeSlug": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveWorkflow,
+			},
+			"contentReviews": &graphql.Field{
+				Type:        graphql.NewList(contentReviewType),
+				Description: "List the reviews of a content entry, newest first",
+				Args: graphql.FieldConfigArgument{
+					"entryId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: s.resolveContentReviews,
+			},
+			"contentStageHistory": &graphql.Field{
+				Type:        graphql.NewList(getStageChangeType()),
+				Description: "List the workflow stage changes of a content entry, newest first",
+				Args: graphql.FieldConfigArgument{
+					"entryId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: s.resolveContentStageHistory,
+			},
+			"users": &graphql.Field{
+				Type:        graphql.NewList(userType),
+				Description: "List all users",
+				Resolve:     s.resolveUsers,
+			},
+			"invitations": &graphql.Field{
+				Type:        graphql.NewList(invitationType),
+				Description: "List pending invitations",
+				Resolve:     s.resolveInvitations,
+			},
+			"roles": &graphql.Field{
+				Type:        graphql.NewList(roleType),
+				Description: "List roles and their permissions",
+				Resolve:     s.resolveRoles,
+			},
+			"apiKeys": &graphql.Field{
+				Type:        graphql.NewList(apiKeyType),
+				Description: "List API keys, including revoked ones",
+				Resolve:     s.resolveAPIKeys,
+			},
+			"mySessions": &graphql.Field{
+				Type:        graphql.NewList(sessionType),
+				Description: "List the active sessions of the current user",
+				Resolve:     s.resolveMySessions,
+			},
+			"assets": &graphql.Field{
+				Type:        getAssetsResponseType(assetType, pageInfoType),
+				Description: "List the media library, newest first",
+				Args:        assetsArgs(),
+				Resolve:     s.resolveAssets,
+			},
+			"asset": &graphql.Field{
+				Type:        assetType,
+				Description: "Get an asset by ID",
 				Args: graphql.FieldConfigArgument{
 					"id": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.Int),
 					},
 				},
-				Resolve: s.resolveContentEntry,
+				Resolve: s.resolveAsset,
 			},
 		},
 	})
-	
+
 	// Define root mutation
 	rootMutation := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Mutation",
 		Fields: graphql.Fields{
 			"register": &graphql.Field{
 				Type:        userType,
-				Description: "Register a new user",
+				Description: "Register the first user (admin); later users join by invitation",
 				Args: graphql.FieldConfigArgument{
 					"email": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.String),
@@ -141,13 +375,7 @@ func NewSchema(db *sql.DB, authMW *auth.Middleware) (*Schema, error) {
 				Resolve: s.resolveRegister,
 			},
 			"login": &graphql.Field{
-				Type: graphql.NewObject(graphql.ObjectConfig{
-					Name: "AuthPayload",
-					Fields: graphql.Fields{
-						"token": &graphql.Field{Type: graphql.String},
-						"user":  &graphql.Field{Type: userType},
-					},
-				}),
+				Type:        authPayloadType,
 				Description: "Login user",
 				Args: graphql.FieldConfigArgument{
 					"email": &graphql.ArgumentConfig{
@@ -159,6 +387,246 @@ func NewSchema(db *sql.DB, authMW *auth.Middleware) (*Schema, error) {
 				},
 				Resolve: s.resolveLogin,
 			},
+			"inviteUser": &graphql.Field{
+				Type:        getInvitePayloadType(invitationType),
+				Description: "Invite a new user; the returned token is single-use and expires after 7 days",
+				Args: graphql.FieldConfigArgument{
+					"email": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"role": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "editor",
+						Description:  "Name of an existing role (admin, editor, author, viewer or a custom role)",
+					},
+				},
+				Resolve: s.resolveInviteUser,
+			},
+			"acceptInvite": &graphql.Field{
+				Type:        authPayloadType,
+				Description: "Create an account from an invitation and log in",
+				Args: graphql.FieldConfigArgument{
+					"token": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"password": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveAcceptInvite,
+			},
+			"revokeInvitation": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Cancel a pending invitation",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: s.resolveRevokeInvitation,
+			},
+			"deactivateUser": &graphql.Field{
+				Type:        userType,
+				Description: "Deactivate a user and end all of their sessions",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: s.resolveDeactivateUser,
+			},
+			"activateUser": &graphql.Field{
+				Type:        userType,
+				Description: "Reactivate a deactivated user",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: s.resolveActivateUser,
+			},
+			"createRole": &graphql.Field{
+				Type:        roleType,
+				Description: "Create a custom role without permissions",
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"description": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: s.resolveCreateRole,
+			},
+			"deleteRole": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Delete a custom role that is not assigned to any user",
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveDeleteRole,
+			},
+			"grantPermission": &graphql.Field{
+				Type:        roleType,
+				Description: "Grant a permission to a role",
+				Args:        permissionArgs(),
+				Resolve:     s.resolveGrantPermission,
+			},
+			"revokePermission": &graphql.Field{
+				Type:        roleType,
+				Description: "Revoke a permission from a role",
+				Args:        permissionArgs(),
+				Resolve:     s.resolveRevokePermission,
+			},
+			"assignRole": &graphql.Field{
+				Type:        userType,
+				Description: "Assign a role to a user",
+				Args: graphql.FieldConfigArgument{
+					"userId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"role": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveAssignRole,
+			},
+			"createApiKey": &graphql.Field{
+				Type:        apiKeyPayloadType,
+				Description: "Create an API key; the key is returned only once",
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"access": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "read",
+						Description:  "read or read_write",
+					},
+					"typeSlugs": &graphql.ArgumentConfig{
+						Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+						Description: "Content types the key can access; omit for every content type",
+					},
+					"environment": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "Only accept the key when ENVIRONMENT matches; omit for every environment",
+					},
+					"expiresAt": &graphql.ArgumentConfig{
+						Type: graphql.DateTime,
+					},
+				},
+				Resolve: s.resolveCreateAPIKey,
+			},
+			"rotateApiKey": &graphql.Field{
+				Type:        apiKeyPayloadType,
+				Description: "Replace the secret of an API key, keeping its settings; the old key stops working",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: s.resolveRotateAPIKey,
+			},
+			"revokeApiKey": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Permanently disable an API key",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: s.resolveRevokeAPIKey,
+			},
+			"createUploadUrl": &graphql.Field{
+				Type:        getDirectUploadType(),
+				Description: "Start a direct upload to the storage bucket: returns a presigned PUT request and a pending asset",
+				Args: graphql.FieldConfigArgument{
+					"filename": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"contentType": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(graphql.String),
+						Description: "MIME type of the file; the upload must send the same Content-Type",
+					},
+					"size": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(graphql.Int),
+						Description: "Size of the file in bytes; the upload must have exactly this size",
+					},
+					"altText": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+					"folder": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+					"tags": &graphql.ArgumentConfig{
+						Type: graphql.NewList(graphql.NewNonNull(graphql.String)),
+					},
+				},
+				Resolve: s.resolveCreateUploadURL,
+			},
+			"completeUpload": &graphql.Field{
+				Type:        assetType,
+				Description: "Verify the file of a direct upload and add the asset to the media library; files breaking the upload policy are deleted",
+				Args: graphql.FieldConfigArgument{
+					"assetId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: s.resolveCompleteUpload,
+			},
+			"updateAsset": &graphql.Field{
+				Type:        assetType,
+				Description: "Change the metadata of an asset; omitted arguments are kept",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"altText": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+					"folder": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: `Slash separated folder path; "" moves the asset to the root folder`,
+					},
+					"tags": &graphql.ArgumentConfig{
+						Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+						Description: "Replaces every tag of the asset",
+					},
+				},
+				Resolve: s.resolveUpdateAsset,
+			},
+			"deleteAsset": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Delete an asset and its file from storage",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: s.resolveDeleteAsset,
+			},
+			"logout": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "End the current session",
+				Resolve:     s.resolveLogout,
+			},
+			"logoutAll": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "End every session of the current user, including this one",
+				Resolve:     s.resolveLogoutAll,
+			},
+			"revokeSession": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "End one of the current user's sessions",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveRevokeSession,
+			},
 			"createContentType": &graphql.Field{
 				Type:        contentTypeType,
 				Description: "Create a new content type",
@@ -194,6 +662,7 @@ func NewSchema(db *sql.DB, authMW *auth.Middleware) (*Schema, error) {
 					"schema": &graphql.ArgumentConfig{
 						Type: graphql.String,
 					},
+					"expectedVersion": expectedVersionArg(),
 				},
 				Resolve: s.resolveUpdateContentType,
 			},
@@ -220,7 +689,9 @@ func NewSchema(db *sql.DB, authMW *auth.Middleware) (*Schema, error) {
 					"status": &graphql.ArgumentConfig{
 						Type:         graphql.String,
 						DefaultValue: "draft",
+						Description:  "draft or published",
 					},
+					"message": messageArg(),
 				},
 				Resolve: s.resolveCreateContent,
 			},
@@ -235,38 +706,153 @@ func NewSchema(db *sql.DB, authMW *auth.Middleware) (*Schema, error) {
 						Type: graphql.String,
 					},
 					"status": &graphql.ArgumentConfig{
-						Type: graphql.String,
+						Type:        graphql.String,
+						Description: "draft, published or archived; must be an allowed transition",
 					},
+					"message":         messageArg(),
+					"expectedVersion": expectedVersionArg(),
 				},
 				Resolve: s.resolveUpdateContent,
 			},
-			"deleteContent": &graphql.Field{
+			"publishContent": &graphql.Field{
+				Type:        contentEntryType,
+				Description: "Publish a draft or archived entry and stamp published_at",
+				Args:        idArgs(),
+				Resolve:     s.transitionResolver(models.StatusPublished),
+			},
+			"unpublishContent": &graphql.Field{
+				Type:        contentEntryType,
+				Description: "Move a published or archived entry back to draft",
+				Args:        idArgs(),
+				Resolve:     s.transitionResolver(models.StatusDraft),
+			},
+			"archiveContent": &graphql.Field{
+				Type:        contentEntryType,
+				Description: "Archive a draft or published entry",
+				Args:        idArgs(),
+				Resolve:     s.transitionResolver(models.StatusArchived),
+			},
+			"scheduleContent": &graphql.Field{
+				Type:        contentEntryType,
+				Description: "Schedule an entry to be published and/or unpublished; omitted times are cleared",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"publishAt": &graphql.ArgumentConfig{
+						Type: graphql.DateTime,
+					},
+					"unpublishAt": &graphql.ArgumentConfig{
+						Type: graphql.DateTime,
+					},
+					"expectedVersion": expectedVersionArg(),
+				},
+				Resolve: s.resolveScheduleContent,
+			},
+			"createPreviewToken": &graphql.Field{
+				Type:        previewTokenType,
+				Description: "Create a short-lived token that lets anyone holding it read the draft of one entry",
+				Args: graphql.FieldConfigArgument{
+					"entryId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"expiresIn": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: int(defaultPreviewTokenTTL.Seconds()),
+						Description:  "Lifetime in seconds (at most 7 days)",
+					},
+					"includeReferences": &graphql.ArgumentConfig{
+						Type:         graphql.Boolean,
+						DefaultValue: false,
+						Description:  "Also let the holder read the drafts of the entries the entry references",
+					},
+				},
+				Resolve: s.resolveCreatePreviewToken,
+			},
+			"setWorkflow": &graphql.Field{
+				Type:        workflowType,
+				Description: "Create or replace the workflow of a content type; entries outside its stages move to the first stage matching their status",
+				Args:        workflowArgs(),
+				Resolve:     s.resolveSetWorkflow,
+			},
+			"deleteWorkflow": &graphql.Field{
 				Type:        graphql.Boolean,
-				Description: "Delete a content entry",
+				Description: "Remove the workflow of a content type",
+				Args: graphql.FieldConfigArgument{
+					"typeSlug": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveDeleteWorkflow,
+			},
+			"transitionContent": &graphql.Field{
+				Type:        contentEntryType,
+				Description: "Move an entry to another stage of its workflow",
 				Args: graphql.FieldConfigArgument{
 					"id": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.Int),
 					},
+					"stage": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"message":         messageArg(),
+					"expectedVersion": expectedVersionArg(),
 				},
-				Resolve: s.resolveDeleteContent,
+				Resolve: s.resolveTransitionContent,
+			},
+			"reviewContent": &graphql.Field{
+				Type:        contentReviewType,
+				Description: "Approve or reject the latest revision of an entry in its current workflow stage",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"approved": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Boolean),
+					},
+					"comment": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: s.resolveReviewContent,
+			},
+			"restoreRevision": &graphql.Field{
+				Type:        contentEntryType,
+				Description: "Restore the data of an earlier revision as a new revision; the status is unchanged",
+				Args: graphql.FieldConfigArgument{
+					"entryId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"revisionId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"message":         messageArg(),
+					"expectedVersion": expectedVersionArg(),
+				},
+				Resolve: s.resolveRestoreRevision,
+			},
+			"deleteContent": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Delete a content entry",
+				Args:        idArgs(),
+				Resolve:     s.resolveDeleteContent,
 			},
 		},
 	})
-	
+
+	// Add typed queries and mutations generated from content type schemas
+	newTypeBuilder(s, jsonType, contentEntryType, whereType, sortType, pageInfoType, connectionPageInfoType, rootQuery, rootMutation).addContentTypes(contentTypes)
+
 	// Create schema
-	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+	return graphql.NewSchema(graphql.SchemaConfig{
 		Query:    rootQuery,
 		Mutation: rootMutation,
 	})
-	if err != nil {
-		return nil, err
-	}
-	
-	s.schema = schema
-	return s, nil
 }
 
-func (s *Schema) GetSchema() graphql.Schema {
-	return s.schema
+// GetSchema returns the current schema and the schema version it was built from
+func (s *Schema) GetSchema() (graphql.Schema, int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.schema, s.version
 }
-
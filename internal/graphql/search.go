@@ -0,0 +1,126 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+
+	"gofrik/internal/models"
+	"gofrik/internal/search"
+
+	"github.com/graphql-go/graphql"
+)
+
+// maxSearchTypes caps the content types one search may name
+const maxSearchTypes = 20
+
+func getSearchResponseType(contentEntryType, pageInfoType *graphql.Object) *graphql.Object {
+	resultType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "SearchResult",
+		Description: "A published entry matching a search",
+		Fields: graphql.Fields{
+			"entry": &graphql.Field{
+				Type: contentEntryType,
+			},
+			"rank": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Relevance; higher is better",
+			},
+			"snippet": &graphql.Field{
+				Type:        graphql.String,
+				Description: "HTML excerpt of the searchable text, escaped and with matches wrapped in <mark> tags",
+			},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "SearchResponse",
+		Description: "Search results with pagination info",
+		Fields: graphql.Fields{
+			"items": &graphql.Field{
+				Type: graphql.NewList(resultType),
+			},
+			"pageInfo": &graphql.Field{
+				Type:        pageInfoType,
+				Description: "Pagination information",
+			},
+		},
+	})
+}
+
+func searchArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"query": &graphql.ArgumentConfig{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: `Words to find; supports "quoted phrases", OR and -excluded words`,
+		},
+		"types": &graphql.ArgumentConfig{
+			Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+			Description: "Content type slugs to search (default: all)",
+		},
+		"locale": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "Only search content types indexed in this locale's language, such as en or de-AT",
+		},
+		"limit": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 10,
+			Description:  "Number of items per page (default: 10, max: 100)",
+		},
+		"offset": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 0,
+			Description:  "Number of items to skip (default: 0)",
+		},
+	}
+}
+
+func (s *Schema) resolveSearch(p graphql.ResolveParams) (interface{}, error) {
+	text, _ := p.Args["query"].(string)
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+
+	q := models.SearchQuery{Text: text}
+
+	slugs, _ := p.Args["types"].([]interface{})
+	if len(slugs) > maxSearchTypes {
+		return nil, fmt.Errorf("at most %d types can be searched at once", maxSearchTypes)
+	}
+	for _, slug := range slugs {
+		slug, _ := slug.(string)
+		ct, err := models.GetContentTypeBySlug(s.db, slug)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", slug, err)
+		}
+		q.ContentTypeIDs = append(q.ContentTypeIDs, ct.ID)
+	}
+
+	if locale, _ := p.Args["locale"].(string); locale != "" {
+		config, err := search.ConfigForLocale(locale)
+		if err != nil {
+			return nil, err
+		}
+		q.Config = config
+	}
+
+	limit, offset, _, _ := paginationArgs(p)
+	results, totalCount, err := models.SearchContent(s.db, q, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []interface{}
+	for i := range results {
+		items = append(items, map[string]interface{}{
+			// Search only covers published snapshots
+			"entry":   entryToMap(results[i].Entry.Published()),
+			"rank":    results[i].Rank,
+			"snippet": results[i].Snippet,
+		})
+	}
+
+	return map[string]interface{}{
+		"items":    items,
+		"pageInfo": pageInfo(totalCount, limit, offset),
+	}, nil
+}
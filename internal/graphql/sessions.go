@@ -0,0 +1,90 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+
+	"gofrik/internal/auth"
+
+	"github.com/google/uuid"
+	"github.com/graphql-go/graphql"
+)
+
+func sessionToMap(session *auth.Session, current *auth.Session) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           session.ID,
+		"user_agent":   session.UserAgent,
+		"ip":           session.IP,
+		"current":      current != nil && session.ID == current.ID,
+		"created_at":   session.CreatedAt,
+		"last_seen_at": session.LastSeenAt,
+		"expires_at":   session.ExpiresAt,
+	}
+}
+
+func (s *Schema) resolveMySessions(p graphql.ResolveParams) (interface{}, error) {
+	// Require authentication
+	current, err := requireAuth(p)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.auth.ListSessions(current.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []map[string]interface{}
+	for _, session := range sessions {
+		items = append(items, sessionToMap(session, current))
+	}
+	return items, nil
+}
+
+func (s *Schema) resolveLogout(p graphql.ResolveParams) (interface{}, error) {
+	// Require authentication
+	current, err := requireAuth(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.auth.RevokeSession(current.UserID, current.ID); err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Schema) resolveLogoutAll(p graphql.ResolveParams) (interface{}, error) {
+	// Require authentication
+	current, err := requireAuth(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.auth.RevokeAllSessions(current.UserID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Schema) resolveRevokeSession(p graphql.ResolveParams) (interface{}, error) {
+	// Require authentication
+	current, err := requireAuth(p)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := p.Args["id"].(string)
+	if _, err := uuid.Parse(id); err != nil {
+		return false, fmt.Errorf("session not found")
+	}
+
+	// Users can only revoke their own sessions
+	if err := s.auth.RevokeSession(current.UserID, id); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			return false, fmt.Errorf("session not found")
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -1,7 +1,10 @@
 package graphql
 
 import (
+	"strconv"
+
 	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
 )
 
 // Pagination info type
@@ -30,6 +33,127 @@ func getPageInfoType() *graphql.Object {
 	})
 }
 
+// getJSONScalar returns a scalar for arbitrary JSON values, used where a
+// content type schema does not pin a value down to a single type
+func getJSONScalar() *graphql.Scalar {
+	return graphql.NewScalar(graphql.ScalarConfig{
+		Name:        "JSON",
+		Description: "Arbitrary JSON value",
+		Serialize: func(value interface{}) interface{} {
+			return value
+		},
+		ParseValue: func(value interface{}) interface{} {
+			return value
+		},
+		ParseLiteral: parseJSONLiteral,
+	})
+}
+
+func parseJSONLiteral(valueAST ast.Value) interface{} {
+	switch v := valueAST.(type) {
+	case *ast.StringValue:
+		return v.Value
+	case *ast.BooleanValue:
+		return v.Value
+	case *ast.EnumValue:
+		return v.Value
+	case *ast.IntValue:
+		if i, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+			return i
+		}
+		f, _ := strconv.ParseFloat(v.Value, 64)
+		return f
+	case *ast.FloatValue:
+		f, _ := strconv.ParseFloat(v.Value, 64)
+		return f
+	case *ast.ListValue:
+		list := make([]interface{}, 0, len(v.Values))
+		for _, item := range v.Values {
+			list = append(list, parseJSONLiteral(item))
+		}
+		return list
+	case *ast.ObjectValue:
+		obj := make(map[string]interface{}, len(v.Fields))
+		for _, field := range v.Fields {
+			obj[field.Name.Value] = parseJSONLiteral(field.Value)
+		}
+		return obj
+	}
+	return nil
+}
+
+// listArgs returns the pagination and filter arguments shared by content entry list queries
+func listArgs(whereType, sortType *graphql.InputObject) graphql.FieldConfigArgument {
+	return withArgs(graphql.FieldConfigArgument{
+		"limit": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 10,
+			Description:  "Number of items per page (default: 10, max: 100)",
+		},
+		"offset": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 0,
+			Description:  "Number of items to skip (default: 0)",
+		},
+	}, entryQueryArgs(whereType, sortType))
+}
+
+// entryQueryArgs returns the order, filter and preview arguments shared by
+// content entry lists and connections
+func entryQueryArgs(whereType, sortType *graphql.InputObject) graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"orderBy": &graphql.ArgumentConfig{
+			Type:         graphql.String,
+			DefaultValue: "created_at",
+			Description:  "Field to order by (id, created_at, updated_at, published_at, status) or a schema property path",
+		},
+		"orderDirection": &graphql.ArgumentConfig{
+			Type:         graphql.String,
+			DefaultValue: "DESC",
+			Description:  "Order direction (ASC or DESC)",
+		},
+		"sort":    sortArg(sortType),
+		"preview": previewArg(),
+		"where":   whereArg(whereType),
+	}
+}
+
+// previewArg switches a content query from published entries to every entry.
+// Previews require an authorized caller.
+func previewArg() *graphql.ArgumentConfig {
+	return &graphql.ArgumentConfig{
+		Type:         graphql.Boolean,
+		DefaultValue: false,
+		Description:  "Include drafts and other unpublished entries (requires the read permission)",
+	}
+}
+
+// idArgs returns the required id argument and the optional expectedVersion
+func idArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"expectedVersion": expectedVersionArg(),
+	}
+}
+
+// messageArg describes the change made by a content mutation; it is stored with the revision
+func messageArg() *graphql.ArgumentConfig {
+	return &graphql.ArgumentConfig{
+		Type:        graphql.String,
+		Description: "Optional note stored with the revision",
+	}
+}
+
+// withArgs merges extra arguments into args
+func withArgs(args graphql.FieldConfigArgument, extra graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	for name, arg := range extra {
+		args[name] = arg
+	}
+	return args
+}
+
 func (s *Schema) getUserType() *graphql.Object {
 	return graphql.NewObject(graphql.ObjectConfig{
 		Name:        "User",
@@ -41,6 +165,13 @@ func (s *Schema) getUserType() *graphql.Object {
 			"email": &graphql.Field{
 				Type: graphql.String,
 			},
+			"role": &graphql.Field{
+				Type: graphql.String,
+			},
+			"active": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Deactivated users cannot log in",
+			},
 			"created_at": &graphql.Field{
 				Type: graphql.DateTime,
 			},
@@ -51,6 +182,186 @@ func (s *Schema) getUserType() *graphql.Object {
 	})
 }
 
+func getAuthPayloadType(userType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "AuthPayload",
+		Fields: graphql.Fields{
+			"token": &graphql.Field{Type: graphql.String},
+			"user":  &graphql.Field{Type: userType},
+		},
+	})
+}
+
+func (s *Schema) getInvitationType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Invitation",
+		Description: "A pending invitation to join",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"email": &graphql.Field{
+				Type: graphql.String,
+			},
+			"role": &graphql.Field{
+				Type: graphql.String,
+			},
+			"invited_by": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"expires_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"created_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
+
+func getInvitePayloadType(invitationType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "InvitePayload",
+		Fields: graphql.Fields{
+			"token": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Single-use invitation token, shown only once",
+			},
+			"invitation": &graphql.Field{Type: invitationType},
+		},
+	})
+}
+
+func (s *Schema) getRoleType() *graphql.Object {
+	permissionType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "RolePermission",
+		Description: "A permission granted to a role",
+		Fields: graphql.Fields{
+			"permission": &graphql.Field{
+				Type:        graphql.String,
+				Description: "read, create, update, publish, delete or manage_schema",
+			},
+			"content_type_id": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Content type the permission applies to; null for every content type",
+			},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Role",
+		Description: "A role and the permissions it grants",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"description": &graphql.Field{
+				Type: graphql.String,
+			},
+			"builtin": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Built-in roles cannot be deleted",
+			},
+			"permissions": &graphql.Field{
+				Type: graphql.NewList(permissionType),
+			},
+			"created_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
+
+func (s *Schema) getAPIKeyType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ApiKey",
+		Description: "A long-lived key for server-to-server access",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"prefix": &graphql.Field{
+				Type:        graphql.String,
+				Description: "First characters of the key, to tell keys apart",
+			},
+			"access": &graphql.Field{
+				Type:        graphql.String,
+				Description: "read or read_write",
+			},
+			"environment": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Environment the key is limited to; empty for every environment",
+			},
+			"content_type_ids": &graphql.Field{
+				Type:        graphql.NewList(graphql.Int),
+				Description: "Content types the key is limited to; empty for every content type",
+			},
+			"created_by": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"created_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"last_used_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"expires_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"revoked_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
+
+func getAPIKeyPayloadType(apiKeyType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "ApiKeyPayload",
+		Fields: graphql.Fields{
+			"key": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The API key, shown only once",
+			},
+			"apiKey": &graphql.Field{Type: apiKeyType},
+		},
+	})
+}
+
+func (s *Schema) getSessionType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Session",
+		Description: "A login session of the current user",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.String,
+			},
+			"user_agent": &graphql.Field{
+				Type: graphql.String,
+			},
+			"ip": &graphql.Field{
+				Type: graphql.String,
+			},
+			"current": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether this is the session making the request",
+			},
+			"created_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"last_seen_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"expires_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
+
 func (s *Schema) getContentTypeType() *graphql.Object {
 	return graphql.NewObject(graphql.ObjectConfig{
 		Name:        "ContentType",
@@ -77,6 +388,10 @@ func (s *Schema) getContentTypeType() *graphql.Object {
 			"updated_at": &graphql.Field{
 				Type: graphql.DateTime,
 			},
+			"version": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Increases on every change; pass it as expectedVersion to detect conflicts",
+			},
 		},
 	})
 }
@@ -93,11 +408,24 @@ func (s *Schema) getContentEntryType() *graphql.Object {
 				Type: graphql.Int,
 			},
 			"data": &graphql.Field{
-				Type: graphql.String,
+				Type:        graphql.String,
+				Description: "The working draft, or the published snapshot outside preview mode",
+			},
+			"published_data": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The published snapshot; null unless the entry is published",
+			},
+			"has_unpublished_changes": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether a published entry's draft differs from its published snapshot",
 			},
 			"status": &graphql.Field{
 				Type: graphql.String,
 			},
+			"stage": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Workflow stage; null unless the content type has a workflow",
+			},
 			"created_by": &graphql.Field{
 				Type: graphql.Int,
 			},
@@ -110,6 +438,18 @@ func (s *Schema) getContentEntryType() *graphql.Object {
 			"published_at": &graphql.Field{
 				Type: graphql.DateTime,
 			},
+			"publish_at": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "When the entry is scheduled to be published",
+			},
+			"unpublish_at": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "When the entry is scheduled to be unpublished",
+			},
+			"version": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Increases on every change; pass it as expectedVersion to detect conflicts",
+			},
 		},
 	})
 }
@@ -148,4 +488,3 @@ func getContentEntriesResponseType(contentEntryType *graphql.Object, pageInfoTyp
 		},
 	})
 }
-
@@ -0,0 +1,199 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gofrik/internal/auth"
+	"gofrik/internal/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+// invitationTTL is how long an invitation token can be accepted
+const invitationTTL = 7 * 24 * time.Hour
+
+func userToMap(user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         user.ID,
+		"email":      user.Email,
+		"role":       user.Role,
+		"active":     user.Active,
+		"created_at": user.CreatedAt,
+		"updated_at": user.UpdatedAt,
+	}
+}
+
+func invitationToMap(inv *models.Invitation) map[string]interface{} {
+	result := map[string]interface{}{
+		"id":         inv.ID,
+		"email":      inv.Email,
+		"role":       inv.Role,
+		"expires_at": inv.ExpiresAt,
+		"created_at": inv.CreatedAt,
+	}
+	if inv.InvitedBy != nil {
+		result["invited_by"] = *inv.InvitedBy
+	}
+	return result
+}
+
+func (s *Schema) resolveUsers(p graphql.ResolveParams) (interface{}, error) {
+	// User management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return nil, err
+	}
+
+	users, err := models.ListUsers(s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []map[string]interface{}
+	for i := range users {
+		items = append(items, userToMap(&users[i]))
+	}
+	return items, nil
+}
+
+func (s *Schema) resolveInvitations(p graphql.ResolveParams) (interface{}, error) {
+	// User management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return nil, err
+	}
+
+	invitations, err := models.ListPendingInvitations(s.db, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	var items []map[string]interface{}
+	for i := range invitations {
+		items = append(items, invitationToMap(&invitations[i]))
+	}
+	return items, nil
+}
+
+func (s *Schema) resolveInviteUser(p graphql.ResolveParams) (interface{}, error) {
+	// User management is reserved to admins
+	session, err := s.requireAdmin(p)
+	if err != nil {
+		return nil, err
+	}
+
+	email := strings.TrimSpace(p.Args["email"].(string))
+	role, _ := p.Args["role"].(string)
+
+	if email == "" {
+		return nil, fmt.Errorf("email is required")
+	}
+	if _, err := models.GetRole(s.db, role); err != nil {
+		return nil, fmt.Errorf("invalid role %q", role)
+	}
+	if _, err := models.GetUserByEmail(s.db, email); err == nil {
+		return nil, fmt.Errorf("a user with this email already exists")
+	}
+
+	token, err := auth.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := models.CreateInvitation(s.db, email, role, auth.HashToken(token), session.UserID, time.Now().UTC().Add(invitationTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	// The token is only returned here; deliver it to the invitee out of band
+	return map[string]interface{}{
+		"token":      token,
+		"invitation": invitationToMap(inv),
+	}, nil
+}
+
+func (s *Schema) resolveAcceptInvite(p graphql.ResolveParams) (interface{}, error) {
+	token, _ := p.Args["token"].(string)
+	password, _ := p.Args["password"].(string)
+
+	if password == "" {
+		return nil, fmt.Errorf("password is required")
+	}
+
+	inv, err := models.GetInvitationByTokenHash(s.db, auth.HashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired invitation")
+	}
+
+	now := time.Now().UTC()
+	if inv.AcceptedAt != nil || now.After(inv.ExpiresAt) {
+		return nil, fmt.Errorf("invalid or expired invitation")
+	}
+
+	user, err := models.AcceptInvitation(s.db, inv, password, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Log the new user in right away
+	return s.startSession(p, user)
+}
+
+func (s *Schema) resolveRevokeInvitation(p graphql.ResolveParams) (interface{}, error) {
+	// User management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return nil, err
+	}
+
+	id, _ := p.Args["id"].(int)
+	if err := models.DeleteInvitation(s.db, id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Schema) resolveDeactivateUser(p graphql.ResolveParams) (interface{}, error) {
+	// User management is reserved to admins
+	session, err := s.requireAdmin(p)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := p.Args["id"].(int)
+	if id == session.UserID {
+		return nil, fmt.Errorf("you cannot deactivate yourself")
+	}
+
+	if err := models.SetUserActive(s.db, id, false); err != nil {
+		return nil, err
+	}
+
+	// End every session of the deactivated user
+	if _, err := s.auth.RevokeAllSessions(id); err != nil {
+		return nil, err
+	}
+
+	user, err := models.GetUserByID(s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	return userToMap(user), nil
+}
+
+func (s *Schema) resolveActivateUser(p graphql.ResolveParams) (interface{}, error) {
+	// User management is reserved to admins
+	if _, err := s.requireAdmin(p); err != nil {
+		return nil, err
+	}
+
+	id, _ := p.Args["id"].(int)
+	if err := models.SetUserActive(s.db, id, true); err != nil {
+		return nil, err
+	}
+
+	user, err := models.GetUserByID(s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	return userToMap(user), nil
+}
@@ -0,0 +1,127 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gofrik/internal/jsonschema"
+	"gofrik/internal/models"
+	"gofrik/internal/reference"
+)
+
+// compileSchema checks that a content type schema is valid JSON and uses only supported keywords
+func compileSchema(raw []byte) (*jsonschema.Schema, error) {
+	schema, err := jsonschema.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return schema, nil
+}
+
+// checkContentTypeSchema compiles the schema of a content type being saved.
+// The root schema must accept some value, or no entry could ever be created.
+func checkContentTypeSchema(raw []byte) error {
+	schema, err := compileSchema(raw)
+	if err != nil {
+		return err
+	}
+	if schema.MatchesNothing {
+		return fmt.Errorf("invalid schema: the root schema cannot be false")
+	}
+	return nil
+}
+
+// validateEntryData validates entry data against the schema of its content
+// type and checks that its references point to existing entries of the allowed
+// content types
+func (s *Schema) validateEntryData(ct *models.ContentType, data []byte) error {
+	schema, err := compileSchema(ct.Schema)
+	if err != nil {
+		return fmt.Errorf("content type %q has an %w", ct.Slug, err)
+	}
+
+	violations, err := schema.Validate(data)
+	if err != nil {
+		return fmt.Errorf("invalid data JSON: %w", err)
+	}
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+
+	return s.validateReferences(ct, data)
+}
+
+// validateReferences checks that the entry ids held by reference properties
+// point to existing entries of the content types the properties allow
+func (s *Schema) validateReferences(ct *models.ContentType, data []byte) error {
+	fields, err := reference.FromSchema(ct.Schema)
+	if err != nil {
+		return fmt.Errorf("content type %q has invalid references: %w", ct.Slug, err)
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("invalid data JSON: %w", err)
+	}
+
+	var ids []int
+	for _, field := range fields {
+		ids = append(ids, field.IDs(decoded)...)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	targets, err := models.GetReferenceTargets(s.db, ids)
+	if err != nil {
+		return err
+	}
+
+	var violations []jsonschema.Violation
+	for _, field := range fields {
+		for _, link := range field.Links(decoded) {
+			slug, ok := targets[link.ID]
+			switch {
+			case !ok:
+				violations = append(violations, jsonschema.Violation{
+					Path:    link.Pointer,
+					Keyword: "x-reference",
+					Message: fmt.Sprintf("content entry %d does not exist", link.ID),
+				})
+			case !field.Allows(slug):
+				violations = append(violations, jsonschema.Violation{
+					Path:    link.Pointer,
+					Keyword: "x-reference",
+					Message: fmt.Sprintf("content entry %d is a %s, expected %s", link.ID, slug, strings.Join(field.To, " or ")),
+				})
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// checkReferenceTypes checks that the reference properties of a schema for the
+// content type slug point to existing content types or to slug itself
+func (s *Schema) checkReferenceTypes(slug string, schema []byte) error {
+	fields, err := reference.FromSchema(schema)
+	if err != nil {
+		return fmt.Errorf("invalid references: %w", err)
+	}
+	for _, field := range fields {
+		for _, to := range field.To {
+			if to == slug {
+				continue
+			}
+			if _, err := models.GetContentTypeBySlug(s.db, to); err != nil {
+				return fmt.Errorf("invalid references: x-reference of %q: content type %q: %w", field.Property, to, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+
+	"gofrik/internal/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+// expectedVersionArg makes a mutation fail with CONFLICT unless the object is still at this version
+func expectedVersionArg() *graphql.ArgumentConfig {
+	return &graphql.ArgumentConfig{
+		Type:        graphql.Int,
+		Description: "Fail with a CONFLICT error unless the current version matches",
+	}
+}
+
+// expectedVersion returns the expectedVersion argument, or nil if it was not given
+func expectedVersion(p graphql.ResolveParams) *int {
+	v, ok := p.Args["expectedVersion"].(int)
+	if !ok {
+		return nil
+	}
+	return &v
+}
+
+// entryConflict turns a version conflict on an entry into a ConflictError
+// carrying the entry's current state; other errors are returned unchanged
+func (s *Schema) entryConflict(err error, id int) error {
+	var conflict *models.VersionConflictError
+	if !errors.As(err, &conflict) {
+		return err
+	}
+
+	current, getErr := models.GetContentEntry(s.db, id)
+	if getErr != nil {
+		return err
+	}
+	state := entryToMap(current)
+	state["data"] = json.RawMessage(current.Data)
+
+	return &ConflictError{
+		ExpectedVersion: conflict.Expected,
+		CurrentVersion:  current.Version,
+		Current:         state,
+	}
+}
+
+// contentTypeConflict turns a version conflict on a content type into a ConflictError
+func (s *Schema) contentTypeConflict(err error, id int) error {
+	var conflict *models.VersionConflictError
+	if !errors.As(err, &conflict) {
+		return err
+	}
+
+	current, getErr := models.GetContentType(s.db, id)
+	if getErr != nil {
+		return err
+	}
+	state := contentTypeToMap(current)
+	state["schema"] = json.RawMessage(current.Schema)
+
+	return &ConflictError{
+		ExpectedVersion: conflict.Expected,
+		CurrentVersion:  current.Version,
+		Current:         state,
+	}
+}
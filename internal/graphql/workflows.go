@@ -0,0 +1,560 @@
+package graphql
+
+import (
+	"fmt"
+	"time"
+
+	"gofrik/internal/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+func workflowToMap(w *models.Workflow) map[string]interface{} {
+	stages := make([]map[string]interface{}, 0, len(w.Stages))
+	for _, stage := range w.Stages {
+		stages = append(stages, map[string]interface{}{
+			"name":   stage.Name,
+			"status": stage.Status,
+		})
+	}
+
+	transitions := make([]map[string]interface{}, 0, len(w.Transitions))
+	for _, t := range w.Transitions {
+		roles := t.Roles
+		if roles == nil {
+			roles = []string{}
+		}
+		transitions = append(transitions, map[string]interface{}{
+			"from":              t.From,
+			"to":                t.To,
+			"roles":             roles,
+			"requiredApprovals": t.RequiredApprovals,
+		})
+	}
+
+	return map[string]interface{}{
+		"content_type_id": w.ContentTypeID,
+		"stages":          stages,
+		"transitions":     transitions,
+		"updated_at":      w.UpdatedAt,
+	}
+}
+
+func stageChangeToMap(c *models.StageChange) map[string]interface{} {
+	result := map[string]interface{}{
+		"id":         c.ID,
+		"entry_id":   c.EntryID,
+		"to_stage":   c.ToStage,
+		"comment":    c.Comment,
+		"created_at": c.CreatedAt,
+	}
+	if c.FromStage != nil {
+		result["from_stage"] = *c.FromStage
+	}
+	if c.ActorID != nil {
+		result["actor_id"] = *c.ActorID
+	}
+	return result
+}
+
+func reviewToMap(r *models.ContentReview) map[string]interface{} {
+	result := map[string]interface{}{
+		"id":         r.ID,
+		"entry_id":   r.EntryID,
+		"revision":   r.Revision,
+		"stage":      r.Stage,
+		"approved":   r.Approved,
+		"comment":    r.Comment,
+		"created_at": r.CreatedAt,
+	}
+	if r.ReviewerID != nil {
+		result["reviewer_id"] = *r.ReviewerID
+	}
+	return result
+}
+
+func (s *Schema) getWorkflowType() *graphql.Object {
+	stageType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "WorkflowStage",
+		Description: "A step of a workflow",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"status": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Status entries get when they enter the stage; empty keeps their status",
+			},
+		},
+	})
+
+	transitionType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "WorkflowTransition",
+		Description: "A move between two workflow stages",
+		Fields: graphql.Fields{
+			"from": &graphql.Field{
+				Type: graphql.String,
+			},
+			"to": &graphql.Field{
+				Type: graphql.String,
+			},
+			"roles": &graphql.Field{
+				Type:        graphql.NewList(graphql.String),
+				Description: "Roles allowed to make the transition; empty allows every role with the permission",
+			},
+			"requiredApprovals": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Approving reviews of the latest revision needed first",
+			},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Workflow",
+		Description: "The editorial workflow of a content type",
+		Fields: graphql.Fields{
+			"content_type_id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"stages": &graphql.Field{
+				Type:        graphql.NewList(stageType),
+				Description: "Stages in order; new entries start in the first one",
+			},
+			"transitions": &graphql.Field{
+				Type: graphql.NewList(transitionType),
+			},
+			"updated_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
+
+// workflowArgs returns the arguments of setWorkflow
+func workflowArgs() graphql.FieldConfigArgument {
+	stageInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "WorkflowStageInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"status": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "draft, published or archived; omit to keep the entry's status",
+			},
+		},
+	})
+
+	transitionInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "WorkflowTransitionInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"from": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"to": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"roles": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewList(graphql.NewNonNull(graphql.String)),
+			},
+			"requiredApprovals": &graphql.InputObjectFieldConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+			},
+		},
+	})
+
+	return graphql.FieldConfigArgument{
+		"typeSlug": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+		"stages": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(stageInput))),
+		},
+		"transitions": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(transitionInput))),
+		},
+	}
+}
+
+func getStageChangeType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "StageChange",
+		Description: "A move of an entry between workflow stages",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"entry_id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"from_stage": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Null when the entry was created or the workflow was set",
+			},
+			"to_stage": &graphql.Field{
+				Type: graphql.String,
+			},
+			"actor_id": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Who made the move; null for the scheduler",
+			},
+			"comment": &graphql.Field{
+				Type: graphql.String,
+			},
+			"created_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
+
+func getContentReviewType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ContentReview",
+		Description: "A reviewer's verdict on a revision of an entry",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"entry_id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"revision": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "The revision that was reviewed; later saves need a new review",
+			},
+			"stage": &graphql.Field{
+				Type: graphql.String,
+			},
+			"reviewer_id": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"approved": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+			"comment": &graphql.Field{
+				Type: graphql.String,
+			},
+			"created_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
+
+// authorizeTransition checks that the caller may move entries of ct along t.
+// Moves into a stage with a status need the publish permission, other moves
+// the update permission, and the caller's role must be listed on t.
+func (s *Schema) authorizeTransition(p graphql.ResolveParams, ct *models.ContentType, w *models.Workflow, t *models.WorkflowTransition) error {
+	permission := models.PermUpdate
+	if target := w.Stage(t.To); target != nil && target.Status != "" {
+		permission = models.PermPublish
+	}
+	if err := s.authorize(p, permission, ct); err != nil {
+		return err
+	}
+
+	denied := &ForbiddenError{
+		Permission:  fmt.Sprintf("transition from %s to %s", t.From, t.To),
+		ContentType: ct.Slug,
+	}
+
+	// API keys have no role, so they only get transitions open to every role
+	if apiKeyFromContext(p) != nil {
+		if len(t.Roles) > 0 {
+			return denied
+		}
+		return nil
+	}
+
+	session, err := requireAuth(p)
+	if err != nil {
+		return err
+	}
+	user, err := models.GetUserByID(s.db, session.UserID)
+	if err != nil {
+		return err
+	}
+	if !t.AllowsRole(user.Role) {
+		return denied
+	}
+	return nil
+}
+
+// workflowTransitionTo returns a transition out of the entry's stage into a
+// stage with status that the caller may make
+func (s *Schema) workflowTransitionTo(p graphql.ResolveParams, ct *models.ContentType, w *models.Workflow, entry *models.ContentEntry, status string) (*models.WorkflowTransition, error) {
+	var denied error
+	for _, t := range w.TransitionsFrom(entry.Stage) {
+		if target := w.Stage(t.To); target == nil || target.Status != status {
+			continue
+		}
+		t := t
+		if err := s.authorizeTransition(p, ct, w, &t); err != nil {
+			denied = err
+			continue
+		}
+		return &t, nil
+	}
+	if denied != nil {
+		return nil, denied
+	}
+
+	stage := ""
+	if entry.Stage != nil {
+		stage = *entry.Stage
+	}
+	return nil, &models.WorkflowError{Reason: fmt.Sprintf("no transition from stage %q leads to a %s stage", stage, status)}
+}
+
+// contentTypeWorkflow loads a content type by slug together with its workflow
+func (s *Schema) contentTypeWorkflow(typeSlug string) (*models.ContentType, *models.Workflow, error) {
+	ct, err := models.GetContentTypeBySlug(s.db, typeSlug)
+	if err != nil {
+		return nil, nil, err
+	}
+	w, err := models.GetWorkflow(s.db, ct.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ct, w, nil
+}
+
+func (s *Schema) resolveWorkflow(p graphql.ResolveParams) (interface{}, error) {
+	typeSlug, _ := p.Args["typeSlug"].(string)
+
+	ct, w, err := s.contentTypeWorkflow(typeSlug)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(p, models.PermRead, ct); err != nil {
+		return nil, err
+	}
+
+	if w == nil {
+		return nil, nil
+	}
+	return workflowToMap(w), nil
+}
+
+func (s *Schema) resolveSetWorkflow(p graphql.ResolveParams) (interface{}, error) {
+	typeSlug, _ := p.Args["typeSlug"].(string)
+
+	ct, err := models.GetContentTypeBySlug(s.db, typeSlug)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(p, models.PermManageSchema, ct); err != nil {
+		return nil, err
+	}
+
+	w := &models.Workflow{ContentTypeID: ct.ID}
+	stages, _ := p.Args["stages"].([]interface{})
+	for _, raw := range stages {
+		stage, _ := raw.(map[string]interface{})
+		name, _ := stage["name"].(string)
+		status, _ := stage["status"].(string)
+		w.Stages = append(w.Stages, models.WorkflowStage{Name: name, Status: status})
+	}
+
+	transitions, _ := p.Args["transitions"].([]interface{})
+	for _, raw := range transitions {
+		transition, _ := raw.(map[string]interface{})
+		t := models.WorkflowTransition{}
+		t.From, _ = transition["from"].(string)
+		t.To, _ = transition["to"].(string)
+		t.RequiredApprovals, _ = transition["requiredApprovals"].(int)
+		roles, _ := transition["roles"].([]interface{})
+		for _, role := range roles {
+			name, _ := role.(string)
+			if _, err := models.GetRole(s.db, name); err != nil {
+				return nil, fmt.Errorf("invalid role %q", name)
+			}
+			t.Roles = append(t.Roles, name)
+		}
+		w.Transitions = append(w.Transitions, t)
+	}
+
+	if err := models.SetWorkflow(s.db, w, actorID(p), time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	return workflowToMap(w), nil
+}
+
+func (s *Schema) resolveDeleteWorkflow(p graphql.ResolveParams) (interface{}, error) {
+	typeSlug, _ := p.Args["typeSlug"].(string)
+
+	ct, err := models.GetContentTypeBySlug(s.db, typeSlug)
+	if err != nil {
+		return false, err
+	}
+	if err := s.authorize(p, models.PermManageSchema, ct); err != nil {
+		return false, err
+	}
+
+	if err := models.DeleteWorkflow(s.db, ct.ID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// entryWorkflow loads an entry with its content type and workflow, failing if
+// the content type has no workflow
+func (s *Schema) entryWorkflow(id int) (*models.ContentEntry, *models.ContentType, *models.Workflow, error) {
+	entry, err := models.GetContentEntry(s.db, id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ct, err := models.GetContentType(s.db, entry.ContentTypeID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	w, err := models.GetWorkflow(s.db, ct.ID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if w == nil {
+		return nil, nil, nil, fmt.Errorf("content type %s has no workflow", ct.Slug)
+	}
+	return entry, ct, w, nil
+}
+
+func (s *Schema) resolveTransitionContent(p graphql.ResolveParams) (interface{}, error) {
+	id, _ := p.Args["id"].(int)
+	stage, _ := p.Args["stage"].(string)
+	message, _ := p.Args["message"].(string)
+
+	if err := requireCaller(p); err != nil {
+		return nil, err
+	}
+
+	entry, ct, w, err := s.entryWorkflow(id)
+	if err != nil {
+		return nil, err
+	}
+
+	t := w.Transition(entry.Stage, stage)
+	if t == nil {
+		current := ""
+		if entry.Stage != nil {
+			current = *entry.Stage
+		}
+		return nil, &models.WorkflowError{Reason: fmt.Sprintf("no transition from stage %q to %q", current, stage)}
+	}
+	if err := s.authorizeTransition(p, ct, w, t); err != nil {
+		return nil, err
+	}
+
+	updated, err := models.UpdateContentEntry(s.db, id, models.EntryUpdate{
+		Status:          w.Stage(t.To).Status,
+		AuthorID:        actorID(p),
+		Message:         message,
+		ExpectedVersion: expectedVersion(p),
+		Transition:      t,
+	}, time.Now().UTC())
+	if err != nil {
+		return nil, s.entryConflict(err, id)
+	}
+	return entryToMap(updated), nil
+}
+
+func (s *Schema) resolveReviewContent(p graphql.ResolveParams) (interface{}, error) {
+	id, _ := p.Args["id"].(int)
+	approved, _ := p.Args["approved"].(bool)
+	comment, _ := p.Args["comment"].(string)
+
+	// Reviews are made by people, not API keys
+	session, err := requireAuth(p)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, ct, w, err := s.entryWorkflow(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(p, models.PermRead, ct); err != nil {
+		return nil, err
+	}
+
+	// Reviewers are the roles listed on a transition that needs approvals;
+	// transitions open to every role take reviews from publishers only
+	user, err := models.GetUserByID(s.db, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	publisher, err := models.HasPermission(s.db, session.UserID, models.PermPublish, &ct.ID)
+	if err != nil {
+		return nil, err
+	}
+	reviewable, allowed := false, false
+	for _, t := range w.TransitionsFrom(entry.Stage) {
+		if t.RequiredApprovals > 0 {
+			reviewable = true
+			if len(t.Roles) > 0 {
+				allowed = allowed || t.AllowsRole(user.Role)
+			} else {
+				allowed = allowed || publisher
+			}
+		}
+	}
+	if !reviewable {
+		return nil, &models.WorkflowError{Reason: "the entry's stage does not take reviews"}
+	}
+	if !allowed {
+		return nil, &ForbiddenError{Permission: "review", ContentType: ct.Slug}
+	}
+
+	review, err := models.CreateContentReview(s.db, id, *entry.Stage, &session.UserID, approved, comment, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return reviewToMap(review), nil
+}
+
+func (s *Schema) resolveContentReviews(p graphql.ResolveParams) (interface{}, error) {
+	entryID, _ := p.Args["entryId"].(int)
+
+	entry, err := models.GetContentEntry(s.db, entryID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorizeEntry(p, models.PermRead, entry); err != nil {
+		return nil, err
+	}
+
+	reviews, err := models.ListContentReviews(s.db, entryID)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []map[string]interface{}
+	for i := range reviews {
+		items = append(items, reviewToMap(&reviews[i]))
+	}
+	return items, nil
+}
+
+func (s *Schema) resolveContentStageHistory(p graphql.ResolveParams) (interface{}, error) {
+	entryID, _ := p.Args["entryId"].(int)
+
+	entry, err := models.GetContentEntry(s.db, entryID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorizeEntry(p, models.PermRead, entry); err != nil {
+		return nil, err
+	}
+
+	changes, err := models.ListStageChanges(s.db, entryID)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []map[string]interface{}
+	for i := range changes {
+		items = append(items, stageChangeToMap(&changes[i]))
+	}
+	return items, nil
+}
@@ -0,0 +1,180 @@
+// Package imaging derives image variants (resized, cropped or re-encoded
+// versions of uploaded images) in pure Go, and signs the URLs requesting them
+// so that only variants the server handed out can be generated.
+package imaging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Fit modes deciding how an image is sized into a width and height
+const (
+	// FitContain scales the image to fit within the box, keeping its aspect ratio
+	FitContain = "contain"
+	// FitCover scales the image to fill the box and crops what overflows,
+	// keeping the focal point in view
+	FitCover = "cover"
+	// FitFill stretches the image to the box
+	FitFill = "fill"
+)
+
+// Output formats
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatWebP = "webp"
+)
+
+const (
+	// MaxDimension caps the width and height of variants
+	MaxDimension = 4096
+
+	// DefaultQuality is the JPEG quality used when none is given
+	DefaultQuality = 80
+)
+
+// Options describe a variant. Zero values mean: keep the original width or
+// height, contain, the format of the original, the default quality and a
+// centered focal point.
+type Options struct {
+	Width   int
+	Height  int
+	Fit     string
+	Format  string
+	Quality int
+	// FocalX and FocalY locate the point cover crops keep in view, as
+	// fractions of the width and height from the top left corner
+	FocalX float64
+	FocalY float64
+}
+
+// ParseOptions reads options from query parameters: w, h, fit, fm, q and fp
+// (a focal point such as "0.3,0.6")
+func ParseOptions(values url.Values) (Options, error) {
+	o := Options{
+		Fit:    values.Get("fit"),
+		Format: values.Get("fm"),
+		FocalX: 0.5,
+		FocalY: 0.5,
+	}
+
+	var err error
+	if o.Width, err = intParam(values, "w"); err != nil {
+		return o, err
+	}
+	if o.Height, err = intParam(values, "h"); err != nil {
+		return o, err
+	}
+	if o.Quality, err = intParam(values, "q"); err != nil {
+		return o, err
+	}
+	if fp := values.Get("fp"); fp != "" {
+		x, y, ok := strings.Cut(fp, ",")
+		if !ok {
+			return o, fmt.Errorf("fp must be two fractions such as 0.5,0.5")
+		}
+		if o.FocalX, err = strconv.ParseFloat(x, 64); err != nil {
+			return o, fmt.Errorf("fp must be two fractions such as 0.5,0.5")
+		}
+		if o.FocalY, err = strconv.ParseFloat(y, 64); err != nil {
+			return o, fmt.Errorf("fp must be two fractions such as 0.5,0.5")
+		}
+	}
+
+	return o, o.Validate()
+}
+
+func intParam(values url.Values, name string) (int, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", name)
+	}
+	return value, nil
+}
+
+// Validate checks that the options describe a variant that can be generated
+func (o *Options) Validate() error {
+	if o.Width < 0 || o.Width > MaxDimension || o.Height < 0 || o.Height > MaxDimension {
+		return fmt.Errorf("width and height must be between 1 and %d", MaxDimension)
+	}
+	switch o.Fit {
+	case "", FitContain, FitCover, FitFill:
+	default:
+		return fmt.Errorf("fit must be one of %s, %s, %s", FitContain, FitCover, FitFill)
+	}
+	switch o.Format {
+	case "", FormatJPEG, FormatPNG, FormatGIF, FormatWebP:
+	default:
+		return fmt.Errorf("format must be one of %s, %s, %s, %s", FormatJPEG, FormatPNG, FormatGIF, FormatWebP)
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100")
+	}
+	if o.FocalX < 0 || o.FocalX > 1 || o.FocalY < 0 || o.FocalY > 1 {
+		return fmt.Errorf("the focal point must be between 0 and 1")
+	}
+	return nil
+}
+
+// Query encodes the options as query parameters, leaving out defaults, so
+// that equal options always give the same query
+func (o Options) Query() url.Values {
+	values := url.Values{}
+	if o.Width > 0 {
+		values.Set("w", strconv.Itoa(o.Width))
+	}
+	if o.Height > 0 {
+		values.Set("h", strconv.Itoa(o.Height))
+	}
+	if o.Fit != "" && o.Fit != FitContain {
+		values.Set("fit", o.Fit)
+	}
+	if o.Format != "" {
+		values.Set("fm", o.Format)
+	}
+	if o.Quality > 0 && o.Quality != DefaultQuality {
+		values.Set("q", strconv.Itoa(o.Quality))
+	}
+	if o.FocalX != 0.5 || o.FocalY != 0.5 {
+		values.Set("fp", strconv.FormatFloat(o.FocalX, 'f', -1, 64)+","+strconv.FormatFloat(o.FocalY, 'f', -1, 64))
+	}
+	return values
+}
+
+// CacheName names the variant of an original with these options; the format
+// must be resolved first
+func (o Options) CacheName() string {
+	sum := sha256.Sum256([]byte(o.Query().Encode()))
+	return hex.EncodeToString(sum[:8]) + "." + o.Format
+}
+
+// FormatForKey returns the format of an original judging by its extension,
+// and JPEG for anything that has no matching output format
+func FormatForKey(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".png":
+		return FormatPNG
+	case ".gif":
+		return FormatGIF
+	case ".webp":
+		return FormatWebP
+	default:
+		return FormatJPEG
+	}
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	return "image/" + format
+}
@@ -0,0 +1,48 @@
+package imaging
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// Signer builds and verifies signed variant URLs. Signatures cover the key of
+// the original and the canonical options, so changing any parameter
+// invalidates the URL.
+type Signer struct {
+	secret  []byte
+	baseURL string
+}
+
+// NewSigner creates a signer for URLs under baseURL, such as "/images". An
+// empty secret is replaced by a random one, in which case URLs stop working on
+// restart and are not accepted by other replicas.
+func NewSigner(secret []byte, baseURL string) (*Signer, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &Signer{secret: secret, baseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+// URL returns the signed URL of a variant of the original stored under key
+func (s *Signer) URL(key string, o Options) string {
+	values := o.Query()
+	values.Set("s", s.signature(key, o))
+	return s.baseURL + "/" + key + "?" + values.Encode()
+}
+
+// Verify checks the signature of a variant request
+func (s *Signer) Verify(key string, o Options, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(s.signature(key, o)))
+}
+
+func (s *Signer) signature(key string, o Options) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "?" + o.Query().Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,61 @@
+package imaging
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSignerRoundTrip(t *testing.T) {
+	signer, err := NewSigner([]byte("secret"), "/images/")
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+
+	options := Options{Width: 300, Height: 200, Fit: FitCover, Format: FormatWebP, Quality: 70, FocalX: 0.3, FocalY: 0.6}
+	signed := signer.URL("2024/photo.jpg", options)
+	if !strings.HasPrefix(signed, "/images/2024/photo.jpg?") {
+		t.Fatalf("URL() = %s", signed)
+	}
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("parse URL: %v", err)
+	}
+	values := u.Query()
+	parsed, err := ParseOptions(values)
+	if err != nil {
+		t.Fatalf("ParseOptions: %v", err)
+	}
+	if !signer.Verify("2024/photo.jpg", parsed, values.Get("s")) {
+		t.Fatal("Verify rejected a URL the signer issued")
+	}
+
+	tampered := parsed
+	tampered.Width = 3000
+	tests := []struct {
+		name      string
+		key       string
+		options   Options
+		signature string
+	}{
+		{"other key", "2024/other.jpg", parsed, values.Get("s")},
+		{"other options", "2024/photo.jpg", tampered, values.Get("s")},
+		{"missing signature", "2024/photo.jpg", parsed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if signer.Verify(tt.key, tt.options, tt.signature) {
+				t.Error("Verify accepted a forged request")
+			}
+		})
+	}
+
+	other, err := NewSigner([]byte("other secret"), "/images")
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	if other.Verify("2024/photo.jpg", parsed, values.Get("s")) {
+		t.Error("a signer with another secret accepted the URL")
+	}
+}
@@ -0,0 +1,114 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+)
+
+// Transform resizes and crops an image according to the options. Contain
+// never enlarges images; cover and fill produce exactly the requested size
+// when both width and height are given.
+func Transform(src image.Image, o Options) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 || (o.Width == 0 && o.Height == 0) {
+		return src
+	}
+
+	fit := o.Fit
+	if o.Width == 0 || o.Height == 0 {
+		// With one dimension there is no box to cover or fill
+		fit = FitContain
+	}
+
+	switch fit {
+	case FitCover:
+		crop := coverCrop(bounds, o.Width, o.Height, o.FocalX, o.FocalY)
+		return scale(src, crop, o.Width, o.Height)
+	case FitFill:
+		return scale(src, bounds, o.Width, o.Height)
+	default:
+		ratio := math.Inf(1)
+		if o.Width > 0 {
+			ratio = float64(o.Width) / float64(srcW)
+		}
+		if o.Height > 0 {
+			ratio = math.Min(ratio, float64(o.Height)/float64(srcH))
+		}
+		if ratio >= 1 {
+			return src
+		}
+		w := max(1, int(math.Round(float64(srcW)*ratio)))
+		h := max(1, int(math.Round(float64(srcH)*ratio)))
+		return scale(src, bounds, w, h)
+	}
+}
+
+// coverCrop returns the largest region of bounds with the aspect ratio of
+// width and height, as centered on the focal point as the bounds allow
+func coverCrop(bounds image.Rectangle, width, height int, focalX, focalY float64) image.Rectangle {
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	cropW, cropH := srcW, srcH
+	if srcW*height > srcH*width {
+		cropW = max(1, srcH*width/height)
+	} else {
+		cropH = max(1, srcW*height/width)
+	}
+
+	x := int(math.Round(focalX*float64(srcW))) - cropW/2
+	y := int(math.Round(focalY*float64(srcH))) - cropH/2
+	x = min(max(x, 0), srcW-cropW)
+	y = min(max(y, 0), srcH-cropH)
+
+	origin := bounds.Min.Add(image.Pt(x, y))
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(cropW, cropH))}
+}
+
+// scale resamples a region of src to width by height pixels
+func scale(src image.Image, region image.Rectangle, width, height int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, region, draw.Src, nil)
+	return dst
+}
+
+// Encode writes an image in a format. Quality applies to JPEG only; the
+// other formats are lossless.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	if quality == 0 {
+		quality = DefaultQuality
+	}
+
+	switch format {
+	case FormatJPEG:
+		// JPEG has no transparency, so flatten onto white instead of black
+		return jpeg.Encode(w, flatten(img), &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatGIF:
+		return gif.Encode(w, img, nil)
+	case FormatWebP:
+		return nativewebp.Encode(w, img, nil)
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// flatten draws an image over a white background
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,136 @@
+// Package jsondiff computes structural differences between JSON documents.
+//
+// Objects are compared key by key and arrays index by index, so a change deep
+// inside a document is reported at its own JSON pointer path instead of as a
+// replacement of the whole document.
+package jsondiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change operations
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// Change is a single difference. From is unset for additions and To for removals.
+type Change struct {
+	Op   string      `json:"op"`
+	Path string      `json:"path"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// Diff returns the changes that turn the document from into the document to,
+// ordered by path
+func Diff(from, to []byte) ([]Change, error) {
+	a, err := decode(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source document: %w", err)
+	}
+	b, err := decode(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target document: %w", err)
+	}
+
+	changes := []Change{}
+	diff("", a, b, &changes)
+	return changes, nil
+}
+
+func decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func diff(path string, a, b interface{}, changes *[]Change) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			diffObjects(path, av, bv, changes)
+			return
+		}
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			diffArrays(path, av, bv, changes)
+			return
+		}
+	}
+
+	if !equal(a, b) {
+		*changes = append(*changes, Change{Op: OpReplace, Path: path, From: a, To: b})
+	}
+}
+
+func diffObjects(path string, a, b map[string]interface{}, changes *[]Change) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		child := path + "/" + escape(k)
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inA:
+			*changes = append(*changes, Change{Op: OpAdd, Path: child, To: bv})
+		case !inB:
+			*changes = append(*changes, Change{Op: OpRemove, Path: child, From: av})
+		default:
+			diff(child, av, bv, changes)
+		}
+	}
+}
+
+func diffArrays(path string, a, b []interface{}, changes *[]Change) {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		child := path + "/" + strconv.Itoa(i)
+		switch {
+		case i >= len(a):
+			*changes = append(*changes, Change{Op: OpAdd, Path: child, To: b[i]})
+		case i >= len(b):
+			*changes = append(*changes, Change{Op: OpRemove, Path: child, From: a[i]})
+		default:
+			diff(child, a[i], b[i], changes)
+		}
+	}
+}
+
+func equal(a, b interface{}) bool {
+	an, aNum := a.(json.Number)
+	bn, bNum := b.(json.Number)
+	if aNum && bNum {
+		af, errA := an.Float64()
+		bf, errB := bn.Float64()
+		if errA == nil && errB == nil {
+			return af == bf
+		}
+		return an == bn
+	}
+	return a == b
+}
+
+// escape encodes a key as a JSON pointer reference token (RFC 6901)
+func escape(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,53 @@
+package jsondiff
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want []Change
+	}{
+		{"equal", `{"a": 1, "b": [1, 2]}`, `{"b": [1, 2], "a": 1}`, []Change{}},
+		{"equal numbers", `{"a": 1}`, `{"a": 1.0}`, []Change{}},
+		{"replace", `{"a": "x"}`, `{"a": "y"}`, []Change{{Op: OpReplace, Path: "/a", From: "x", To: "y"}}},
+		{"add and remove", `{"a": 1}`, `{"b": true}`, []Change{
+			{Op: OpRemove, Path: "/a", From: json.Number("1")},
+			{Op: OpAdd, Path: "/b", To: true},
+		}},
+		{"nested", `{"a": {"b": [1, 2, 3]}}`, `{"a": {"b": [1, 5]}}`, []Change{
+			{Op: OpReplace, Path: "/a/b/1", From: json.Number("2"), To: json.Number("5")},
+			{Op: OpRemove, Path: "/a/b/2", From: json.Number("3")},
+		}},
+		{"type change", `{"a": [1]}`, `{"a": {"0": 1}}`, []Change{
+			{Op: OpReplace, Path: "/a", From: []interface{}{json.Number("1")}, To: map[string]interface{}{"0": json.Number("1")}},
+		}},
+		{"escaped keys", `{}`, `{"a/b~c": null}`, []Change{{Op: OpAdd, Path: "/a~1b~0c"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Diff([]byte(tt.from), []byte(tt.to))
+			if err != nil {
+				t.Fatalf("Diff: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffInvalid(t *testing.T) {
+	if _, err := Diff([]byte(`{`), []byte(`{}`)); err == nil {
+		t.Error("Diff of an invalid source succeeded")
+	}
+	if _, err := Diff([]byte(`{}`), []byte(`nope`)); err == nil {
+		t.Error("Diff of an invalid target succeeded")
+	}
+}
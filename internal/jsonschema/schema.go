@@ -0,0 +1,218 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Schema is a compiled JSON Schema (draft 2020-12 subset).
+//
+// Supported keywords: type, required, properties, items, enum, format,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, minLength,
+// maxLength, minItems, maxItems, pattern and additionalProperties.
+// Unknown keywords are ignored, as the specification allows.
+type Schema struct {
+	Types                []string
+	Properties           map[string]*Schema
+	Required             []string
+	Items                *Schema
+	Enum                 []interface{}
+	Format               string
+	Minimum              *float64
+	Maximum              *float64
+	ExclusiveMinimum     *float64
+	ExclusiveMaximum     *float64
+	MinLength            *int
+	MaxLength            *int
+	MinItems             *int
+	MaxItems             *int
+	Pattern              *regexp.Regexp
+	AdditionalProperties *Schema
+	// NoAdditionalProperties is set when additionalProperties is false
+	NoAdditionalProperties bool
+	// MatchesNothing is set for the boolean schema false, which no value matches
+	MatchesNothing bool
+}
+
+// rawSchema mirrors the JSON representation of a schema before compilation
+type rawSchema struct {
+	Type                 json.RawMessage            `json:"type"`
+	Properties           map[string]json.RawMessage `json:"properties"`
+	Required             []string                   `json:"required"`
+	Items                json.RawMessage            `json:"items"`
+	Enum                 []json.RawMessage          `json:"enum"`
+	Format               string                     `json:"format"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	ExclusiveMinimum     *float64                   `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64                   `json:"exclusiveMaximum"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+	Pattern              *string                    `json:"pattern"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+}
+
+var validTypes = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"object":  true,
+	"array":   true,
+	"number":  true,
+	"integer": true,
+	"string":  true,
+}
+
+// Parse compiles a JSON Schema document
+func Parse(raw []byte) (*Schema, error) {
+	return parseAt(raw, "")
+}
+
+func parseAt(raw []byte, path string) (*Schema, error) {
+	raw = bytes.TrimSpace(raw)
+
+	// Boolean schemas: true accepts everything, false accepts nothing
+	if bytes.Equal(raw, []byte("true")) {
+		return &Schema{}, nil
+	}
+	if bytes.Equal(raw, []byte("false")) {
+		return &Schema{MatchesNothing: true}, nil
+	}
+
+	var r rawSchema
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("schema at %q: %w", pointerOrRoot(path), err)
+	}
+
+	s := &Schema{
+		Required:         r.Required,
+		Format:           r.Format,
+		Minimum:          r.Minimum,
+		Maximum:          r.Maximum,
+		ExclusiveMinimum: r.ExclusiveMinimum,
+		ExclusiveMaximum: r.ExclusiveMaximum,
+		MinLength:        r.MinLength,
+		MaxLength:        r.MaxLength,
+		MinItems:         r.MinItems,
+		MaxItems:         r.MaxItems,
+	}
+
+	// type may be a single name or a list of names
+	if len(r.Type) > 0 {
+		var single string
+		if err := json.Unmarshal(r.Type, &single); err == nil {
+			s.Types = []string{single}
+		} else if err := json.Unmarshal(r.Type, &s.Types); err != nil {
+			return nil, fmt.Errorf("schema at %q: type must be a string or an array of strings", pointerOrRoot(path))
+		}
+		for _, t := range s.Types {
+			if !validTypes[t] {
+				return nil, fmt.Errorf("schema at %q: unknown type %q", pointerOrRoot(path), t)
+			}
+		}
+	}
+
+	if len(r.Properties) > 0 {
+		s.Properties = make(map[string]*Schema, len(r.Properties))
+		for name, propRaw := range r.Properties {
+			prop, err := parseAt(propRaw, path+"/properties/"+escapePointer(name))
+			if err != nil {
+				return nil, err
+			}
+			s.Properties[name] = prop
+		}
+	}
+
+	if len(r.Items) > 0 {
+		items, err := parseAt(r.Items, path+"/items")
+		if err != nil {
+			return nil, err
+		}
+		s.Items = items
+	}
+
+	for _, e := range r.Enum {
+		v, err := decodeValue(e)
+		if err != nil {
+			return nil, fmt.Errorf("schema at %q: invalid enum value: %w", pointerOrRoot(path), err)
+		}
+		s.Enum = append(s.Enum, v)
+	}
+
+	if r.Pattern != nil {
+		re, err := regexp.Compile(*r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("schema at %q: invalid pattern: %w", pointerOrRoot(path), err)
+		}
+		s.Pattern = re
+	}
+
+	if len(r.AdditionalProperties) > 0 {
+		if bytes.Equal(bytes.TrimSpace(r.AdditionalProperties), []byte("false")) {
+			s.NoAdditionalProperties = true
+		} else {
+			additional, err := parseAt(r.AdditionalProperties, path+"/additionalProperties")
+			if err != nil {
+				return nil, err
+			}
+			s.AdditionalProperties = additional
+		}
+	}
+
+	return s, nil
+}
+
+// HasType reports whether the schema explicitly allows the given type
+func (s *Schema) HasType(t string) bool {
+	for _, st := range s.Types {
+		if st == t {
+			return true
+		}
+	}
+	return false
+}
+
+// PropertyNames returns the declared property names in a stable order
+func (s *Schema) PropertyNames() []string {
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsRequired reports whether the named property is listed in required
+func (s *Schema) IsRequired(name string) bool {
+	for _, r := range s.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeValue decodes a JSON value keeping numbers as json.Number
+func decodeValue(raw []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after top-level value")
+	}
+	return v, nil
+}
+
+func pointerOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,278 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Violation describes a single place where a document does not match a schema
+type Violation struct {
+	Path    string `json:"path"`    // JSON pointer to the offending value ("" is the document root)
+	Keyword string `json:"keyword"` // Schema keyword that failed
+	Message string `json:"message"`
+}
+
+// Validate checks a JSON document against the schema and returns every violation found
+func (s *Schema) Validate(data []byte) ([]Violation, error) {
+	v, err := decodeValue(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	var violations []Violation
+	s.validate(v, "", &violations)
+	return violations, nil
+}
+
+func (s *Schema) validate(v interface{}, path string, out *[]Violation) {
+	report := func(keyword, format string, args ...interface{}) {
+		*out = append(*out, Violation{
+			Path:    path,
+			Keyword: keyword,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if s.MatchesNothing {
+		report("false", "no value is allowed here")
+		return
+	}
+
+	if len(s.Types) > 0 && !s.matchesType(v) {
+		report("type", "expected %s, got %s", strings.Join(s.Types, " or "), typeOf(v))
+		// Further checks would only repeat the same problem
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if equal(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report("enum", "value must be one of %s", formatEnum(s.Enum))
+		}
+	}
+
+	switch value := v.(type) {
+	case string:
+		s.validateString(value, report)
+	case json.Number:
+		s.validateNumber(value, report)
+	case []interface{}:
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			report("minItems", "must contain at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(value) > *s.MaxItems {
+			report("maxItems", "must contain at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range value {
+				s.Items.validate(item, fmt.Sprintf("%s/%d", path, i), out)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				*out = append(*out, Violation{
+					Path:    path + "/" + escapePointer(name),
+					Keyword: "required",
+					Message: fmt.Sprintf("property %q is required", name),
+				})
+			}
+		}
+		for _, name := range sortedKeys(value) {
+			childPath := path + "/" + escapePointer(name)
+			if prop, ok := s.Properties[name]; ok {
+				prop.validate(value[name], childPath, out)
+				continue
+			}
+			if s.NoAdditionalProperties {
+				*out = append(*out, Violation{
+					Path:    childPath,
+					Keyword: "additionalProperties",
+					Message: fmt.Sprintf("property %q is not allowed", name),
+				})
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(value[name], childPath, out)
+			}
+		}
+	}
+}
+
+func (s *Schema) validateString(value string, report func(keyword, format string, args ...interface{})) {
+	length := utf8.RuneCountInString(value)
+	if s.MinLength != nil && length < *s.MinLength {
+		report("minLength", "must be at least %d characters long", *s.MinLength)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		report("maxLength", "must be at most %d characters long", *s.MaxLength)
+	}
+	if s.Pattern != nil && !s.Pattern.MatchString(value) {
+		report("pattern", "must match pattern %q", s.Pattern.String())
+	}
+	if s.Format != "" {
+		if check, ok := formatCheckers[s.Format]; ok && !check(value) {
+			report("format", "must be a valid %s", s.Format)
+		}
+	}
+}
+
+func (s *Schema) validateNumber(value json.Number, report func(keyword, format string, args ...interface{})) {
+	f, err := value.Float64()
+	if err != nil {
+		report("type", "invalid number %s", value.String())
+		return
+	}
+	if s.Minimum != nil && f < *s.Minimum {
+		report("minimum", "must be greater than or equal to %v", *s.Minimum)
+	}
+	if s.Maximum != nil && f > *s.Maximum {
+		report("maximum", "must be less than or equal to %v", *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+		report("exclusiveMinimum", "must be greater than %v", *s.ExclusiveMinimum)
+	}
+	if s.ExclusiveMaximum != nil && f >= *s.ExclusiveMaximum {
+		report("exclusiveMaximum", "must be less than %v", *s.ExclusiveMaximum)
+	}
+}
+
+func (s *Schema) matchesType(v interface{}) bool {
+	actual := typeOf(v)
+	for _, t := range s.Types {
+		if t == actual {
+			return true
+		}
+		// Every integer is also a number
+		if t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type name of a decoded value
+func typeOf(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if isInteger(value) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// isInteger reports whether a number has no fractional part (1.0 counts as an integer)
+func isInteger(n json.Number) bool {
+	if _, err := n.Int64(); err == nil {
+		return true
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return false
+	}
+	return !math.IsInf(f, 0) && f == math.Trunc(f)
+}
+
+// equal compares two decoded JSON values structurally
+func equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		return ok && canonicalNumber(string(av)) == canonicalNumber(string(bv))
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			w, ok := bv[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// canonicalNumber rewrites a JSON number as its significant digits and a
+// power of ten, such as "-1.50e2" as "-15e1", so that numbers compare exactly
+// even when they are too large or precise for a float64
+func canonicalNumber(number string) string {
+	sign := ""
+	if strings.HasPrefix(number, "-") {
+		sign = "-"
+		number = number[1:]
+	}
+
+	mantissa, exponent := number, 0
+	if i := strings.IndexAny(number, "eE"); i >= 0 {
+		e, err := strconv.Atoi(number[i+1:])
+		if err != nil {
+			// Exponents this large only equal themselves as written
+			return sign + number
+		}
+		mantissa, exponent = number[:i], e
+	}
+
+	digits := mantissa
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		digits = mantissa[:i] + mantissa[i+1:]
+		exponent -= len(mantissa) - i - 1
+	}
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" {
+		return "0"
+	}
+	significant := strings.TrimRight(digits, "0")
+	exponent += len(digits) - len(significant)
+	return sign + significant + "e" + strconv.Itoa(exponent)
+}
+
+func formatEnum(values []interface{}) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		b, _ := json.Marshal(v)
+		parts = append(parts, string(b))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// escapePointer escapes a reference token for use in a JSON pointer (RFC 6901)
+func escapePointer(token string) string {
+	token = strings.ReplaceAll(token, "~", "~0")
+	return strings.ReplaceAll(token, "/", "~1")
+}
@@ -0,0 +1,63 @@
+package jsonschema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		data   string
+		// want lists the paths and keywords of the expected violations
+		want []string
+	}{
+		{"true accepts anything", `true`, `{"a": [1, "b"]}`, nil},
+		{"false accepts nothing", `false`, `null`, []string{":false"}},
+		{"false property", `{"properties": {"secret": false}}`, `{"secret": 1}`, []string{"/secret:false"}},
+		{"false property absent", `{"properties": {"secret": false}}`, `{"title": "x"}`, nil},
+		{"false items", `{"type": "array", "items": false}`, `[]`, nil},
+		{"false items with values", `{"type": "array", "items": false}`, `[1, 2]`, []string{"/0:false", "/1:false"}},
+		{"type", `{"type": "string"}`, `1`, []string{":type"}},
+		{"integer", `{"type": "integer"}`, `1.0`, nil},
+		{"not an integer", `{"type": "integer"}`, `1.5`, []string{":type"}},
+		{"required", `{"type": "object", "required": ["title"]}`, `{}`, []string{"/title:required"}},
+		{"enum", `{"enum": ["a", "b"]}`, `"c"`, []string{":enum"}},
+		{"enum object with other key", `{"enum": [{"a": null}]}`, `{"b": null}`, []string{":enum"}},
+		{"enum object", `{"enum": [{"a": null}]}`, `{"a": null}`, nil},
+		{"enum number written differently", `{"enum": [100, 0.5]}`, `1.00e2`, nil},
+		{"enum negative zero", `{"enum": [0]}`, `-0.0`, nil},
+		{"enum large integers", `{"enum": [9007199254740993]}`, `9007199254740992`, []string{":enum"}},
+		{"enum large integer", `{"enum": [9007199254740993]}`, `9007199254740993`, nil},
+		{"nested", `{"properties": {"tags": {"items": {"type": "string", "maxLength": 3}}}}`, `{"tags": ["ok", "long"]}`, []string{"/tags/1:maxLength"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Parse([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			violations, err := schema.Validate([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			var got []string
+			for _, v := range violations {
+				got = append(got, v.Path+":"+v.Keyword)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations = %v (%+v), want %v", got, violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, raw := range []string{`1`, `"string"`, `{"type": "date"}`, `{"properties": {"a": 1}}`} {
+		if _, err := Parse([]byte(raw)); err == nil {
+			t.Errorf("Parse(%s) succeeded", raw)
+		}
+	}
+}
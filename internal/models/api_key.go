@@ -0,0 +1,174 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// API key access levels
+const (
+	APIKeyAccessRead      = "read"
+	APIKeyAccessReadWrite = "read_write"
+)
+
+// APIKey is a long-lived credential for server-to-server access. Only the
+// hash of the key is stored; Prefix is kept so keys can be told apart.
+type APIKey struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Prefix      string `json:"prefix"`
+	KeyHash     string `json:"-"`
+	Access      string `json:"access"`
+	Environment string `json:"environment"`
+	// ContentTypeIDs limits the key to these content types; empty allows every content type
+	ContentTypeIDs []int      `json:"content_type_ids"`
+	CreatedBy      *int       `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+}
+
+// AllowsContentType reports whether the key is scoped to a content type
+func (k *APIKey) AllowsContentType(contentTypeID int) bool {
+	if len(k.ContentTypeIDs) == 0 {
+		return true
+	}
+	for _, id := range k.ContentTypeIDs {
+		if id == contentTypeID {
+			return true
+		}
+	}
+	return false
+}
+
+const apiKeyColumns = `id, name, prefix, key_hash, access, environment, content_type_ids, created_by, created_at, last_used_at, expires_at, revoked_at`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var contentTypeIDs pq.Int64Array
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, &key.Access, &key.Environment, &contentTypeIDs,
+		&key.CreatedBy, &key.CreatedAt, &key.LastUsedAt, &key.ExpiresAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range contentTypeIDs {
+		key.ContentTypeIDs = append(key.ContentTypeIDs, int(id))
+	}
+	return &key, nil
+}
+
+func int64Array(ids []int) pq.Int64Array {
+	result := pq.Int64Array{}
+	for _, id := range ids {
+		result = append(result, int64(id))
+	}
+	return result
+}
+
+// CreateAPIKey stores a new key and fills in its ID and creation time
+func CreateAPIKey(db *sql.DB, key *APIKey) error {
+	err := db.QueryRow(
+		`INSERT INTO api_keys (name, prefix, key_hash, access, environment, content_type_ids, created_by, expires_at) 
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
+		 RETURNING id, created_at`,
+		key.Name, key.Prefix, key.KeyHash, key.Access, key.Environment, int64Array(key.ContentTypeIDs), key.CreatedBy, key.ExpiresAt,
+	).Scan(&key.ID, &key.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+func GetAPIKey(db *sql.DB, id int) (*APIKey, error) {
+	key, err := scanAPIKey(db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("API key not found")
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
+
+// GetAPIKeyByHash returns the unrevoked key with the given hash. Keys act on
+// behalf of the user who created them, so keys of deactivated or deleted
+// users are not returned.
+func GetAPIKeyByHash(db *sql.DB, keyHash string) (*APIKey, error) {
+	key, err := scanAPIKey(db.QueryRow(
+		`SELECT `+apiKeyColumns+` FROM api_keys 
+		 WHERE key_hash = $1 AND revoked_at IS NULL 
+		 AND EXISTS (SELECT 1 FROM users u WHERE u.id = api_keys.created_by AND u.active)`,
+		keyHash,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("API key not found")
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns every key, including revoked ones, newest first
+func ListAPIKeys(db *sql.DB) ([]APIKey, error) {
+	rows, err := db.Query(`SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RotateAPIKey replaces the secret of an unrevoked key, keeping its settings
+func RotateAPIKey(db *sql.DB, id int, prefix, keyHash string) error {
+	result, err := db.Exec(
+		`UPDATE api_keys SET prefix = $1, key_hash = $2, last_used_at = NULL 
+		 WHERE id = $3 AND revoked_at IS NULL`,
+		prefix, keyHash, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rotate API key: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("API key not found or revoked")
+	}
+	return nil
+}
+
+func RevokeAPIKey(db *sql.DB, id int, now time.Time) error {
+	result, err := db.Exec(
+		`UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`,
+		now, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("API key not found or already revoked")
+	}
+	return nil
+}
+
+// TouchAPIKey records when a key was last used
+func TouchAPIKey(db *sql.DB, id int, now time.Time) error {
+	_, err := db.Exec(`UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, now, id)
+	if err != nil {
+		return fmt.Errorf("failed to touch API key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Asset statuses
+const (
+	// AssetPending marks assets whose file is being uploaded directly to storage
+	AssetPending = "pending"
+	// AssetReady marks assets whose file is stored and verified
+	AssetReady = "ready"
+)
+
+// Asset is an uploaded file of the media library
+type Asset struct {
+	ID int `json:"id"`
+	// Status is AssetReady, or AssetPending until a direct upload completes
+	Status string `json:"status"`
+	// Key locates the file in storage
+	Key string `json:"key"`
+	// Filename is the name the file was uploaded with
+	Filename string `json:"filename"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	// Width and Height are set for images whose dimensions could be read
+	Width  *int `json:"width"`
+	Height *int `json:"height"`
+	// Checksum is the hex encoded SHA-256 of the content
+	Checksum   string    `json:"checksum"`
+	AltText    string    `json:"alt_text"`
+	UploadedBy *int      `json:"uploaded_by"`
+	Folder     string    `json:"folder"`
+	Tags       []string  `json:"tags"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// assetColumns are the columns read by scanAsset, in order
+const assetColumns = `id, status, key, filename, mime_type, size, width, height, checksum, alt_text, uploaded_by, folder, tags, created_at, updated_at`
+
+func scanAsset(row interface{ Scan(...interface{}) error }) (*Asset, error) {
+	var asset Asset
+	var tags pq.StringArray
+	err := row.Scan(&asset.ID, &asset.Status, &asset.Key, &asset.Filename, &asset.MimeType, &asset.Size, &asset.Width, &asset.Height,
+		&asset.Checksum, &asset.AltText, &asset.UploadedBy, &asset.Folder, &tags, &asset.CreatedAt, &asset.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	asset.Tags = []string(tags)
+	return &asset, nil
+}
+
+// NormalizeFolder trims surrounding slashes and spaces from a folder path
+func NormalizeFolder(folder string) string {
+	return strings.Trim(strings.TrimSpace(folder), "/")
+}
+
+// maxFilenameLength is the length of the filename columns, in characters
+const maxFilenameLength = 255
+
+// maxKeptExtension is the longest extension NormalizeFilename keeps when it
+// shortens a filename
+const maxKeptExtension = 16
+
+// NormalizeFilename shortens filenames longer than the filename columns hold,
+// keeping their extension
+func NormalizeFilename(filename string) string {
+	runes := []rune(filename)
+	if len(runes) <= maxFilenameLength {
+		return filename
+	}
+	ext := []rune(path.Ext(filename))
+	if len(ext) > maxKeptExtension {
+		ext = nil
+	}
+	base := runes[:len(runes)-len(ext)]
+	return string(base[:maxFilenameLength-len(ext)]) + string(ext)
+}
+
+// NormalizeTags trims tags and drops empty and repeated ones
+func NormalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+// CreateAsset stores a new asset and fills in its ID and timestamps. Assets
+// without a status are ready.
+func CreateAsset(db *sql.DB, asset *Asset) error {
+	if asset.Status == "" {
+		asset.Status = AssetReady
+	}
+	asset.Filename = NormalizeFilename(asset.Filename)
+	asset.Folder = NormalizeFolder(asset.Folder)
+	asset.Tags = NormalizeTags(asset.Tags)
+	err := db.QueryRow(
+		`INSERT INTO assets (status, key, filename, mime_type, size, width, height, checksum, alt_text, uploaded_by, folder, tags) 
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) 
+		 RETURNING id, created_at, updated_at`,
+		asset.Status, asset.Key, asset.Filename, asset.MimeType, asset.Size, asset.Width, asset.Height, asset.Checksum,
+		asset.AltText, asset.UploadedBy, asset.Folder, pq.StringArray(asset.Tags),
+	).Scan(&asset.ID, &asset.CreatedAt, &asset.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create asset: %w", err)
+	}
+	return nil
+}
+
+func GetAsset(db *sql.DB, id int) (*Asset, error) {
+	asset, err := scanAsset(db.QueryRow(`SELECT `+assetColumns+` FROM assets WHERE id = $1`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("asset not found")
+		}
+		return nil, fmt.Errorf("failed to get asset: %w", err)
+	}
+	return asset, nil
+}
+
+// AssetFilter selects ready assets; zero fields match every ready asset
+type AssetFilter struct {
+	// Search matches words in the filename or alt text, ignoring case
+	Search string
+	// Folder matches assets directly in a folder; "" is the root folder
+	Folder *string
+	// Tags matches assets that have every tag
+	Tags []string
+	// MimeType matches a MIME type such as "image/png", or a type prefix
+	// such as "image/"
+	MimeType string
+}
+
+// where returns the conditions of the filter and their arguments
+func (f AssetFilter) where() (string, []interface{}) {
+	conditions := []string{"status = 'ready'"}
+	var args []interface{}
+	param := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	for _, word := range strings.Fields(f.Search) {
+		pattern := param("%" + escapeLike(word) + "%")
+		conditions = append(conditions, fmt.Sprintf("(filename ILIKE %s OR alt_text ILIKE %s)", pattern, pattern))
+	}
+	if f.Folder != nil {
+		conditions = append(conditions, "folder = "+param(NormalizeFolder(*f.Folder)))
+	}
+	if tags := NormalizeTags(f.Tags); len(tags) > 0 {
+		conditions = append(conditions, "tags @> "+param(pq.StringArray(tags))+"::text[]")
+	}
+	if f.MimeType != "" {
+		if strings.HasSuffix(f.MimeType, "/") {
+			conditions = append(conditions, "mime_type LIKE "+param(escapeLike(f.MimeType)+"%"))
+		} else {
+			conditions = append(conditions, "mime_type = "+param(f.MimeType))
+		}
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// escapeLike escapes the wildcards of LIKE patterns
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ListAssets returns the assets matching filter, newest first
+func ListAssets(db *sql.DB, filter AssetFilter, limit, offset int) ([]Asset, error) {
+	where, args := filter.where()
+	rows, err := db.Query(fmt.Sprintf(
+		`SELECT %s FROM assets 
+		 WHERE %s 
+		 ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d`,
+		assetColumns, where, len(args)+1, len(args)+2,
+	), append(args, limit, offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list assets: %w", err)
+	}
+	defer rows.Close()
+
+	var assets []Asset
+	for rows.Next() {
+		asset, err := scanAsset(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan asset: %w", err)
+		}
+		assets = append(assets, *asset)
+	}
+
+	return assets, rows.Err()
+}
+
+// CountAssets returns the number of assets matching filter
+func CountAssets(db *sql.DB, filter AssetFilter) (int, error) {
+	where, args := filter.where()
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM assets WHERE `+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count assets: %w", err)
+	}
+	return count, nil
+}
+
+// AssetUpdate holds the editable metadata of an asset; nil fields are kept
+type AssetUpdate struct {
+	AltText *string
+	Folder  *string
+	Tags    []string
+}
+
+// UpdateAsset changes the metadata of an asset and returns it
+func UpdateAsset(db *sql.DB, id int, update AssetUpdate, now time.Time) (*Asset, error) {
+	var folder *string
+	if update.Folder != nil {
+		normalized := NormalizeFolder(*update.Folder)
+		folder = &normalized
+	}
+	var tags interface{}
+	if update.Tags != nil {
+		tags = pq.StringArray(NormalizeTags(update.Tags))
+	}
+
+	asset, err := scanAsset(db.QueryRow(
+		`UPDATE assets 
+		 SET alt_text = COALESCE($1, alt_text), folder = COALESCE($2, folder), tags = COALESCE($3::text[], tags), updated_at = $4 
+		 WHERE id = $5 
+		 RETURNING `+assetColumns,
+		update.AltText, folder, tags, now, id,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("asset not found")
+		}
+		return nil, fmt.Errorf("failed to update asset: %w", err)
+	}
+	return asset, nil
+}
+
+// CompleteAsset records the verified size, dimensions and checksum of a
+// pending asset's file and makes it ready
+func CompleteAsset(db *sql.DB, id int, size int64, width, height *int, checksum string, now time.Time) (*Asset, error) {
+	asset, err := scanAsset(db.QueryRow(
+		`UPDATE assets 
+		 SET status = 'ready', size = $1, width = $2, height = $3, checksum = $4, updated_at = $5 
+		 WHERE id = $6 AND status = 'pending' 
+		 RETURNING `+assetColumns,
+		size, width, height, checksum, now, id,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("pending asset not found")
+		}
+		return nil, fmt.Errorf("failed to complete asset: %w", err)
+	}
+	return asset, nil
+}
+
+func DeleteAsset(db *sql.DB, id int) error {
+	result, err := db.Exec(`DELETE FROM assets WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete asset: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("asset not found")
+	}
+	return nil
+}
+
+// ListStalePendingAssets returns up to limit assets that were created before
+// before and are still waiting for their direct upload
+func ListStalePendingAssets(db *sql.DB, before time.Time, limit int) ([]Asset, error) {
+	rows, err := db.Query(
+		`SELECT `+assetColumns+` FROM assets 
+		 WHERE status = 'pending' AND created_at < $1 
+		 ORDER BY created_at LIMIT $2`,
+		before, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending assets: %w", err)
+	}
+	defer rows.Close()
+
+	var assets []Asset
+	for rows.Next() {
+		asset, err := scanAsset(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan asset: %w", err)
+		}
+		assets = append(assets, *asset)
+	}
+
+	return assets, rows.Err()
+}
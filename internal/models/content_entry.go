@@ -1,46 +1,171 @@
 package models
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"gofrik/internal/query"
+
+	"github.com/lib/pq"
 )
 
+// Entry statuses
+const (
+	StatusDraft     = "draft"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
+)
+
+// Statuses lists every valid status
+var Statuses = []string{StatusDraft, StatusPublished, StatusArchived}
+
+// statusTransitions lists the statuses each status can move to. Publishing a
+// published entry promotes its working draft to the published snapshot.
+var statusTransitions = map[string][]string{
+	StatusDraft:     {StatusPublished, StatusArchived},
+	StatusPublished: {StatusPublished, StatusDraft, StatusArchived},
+	StatusArchived:  {StatusPublished, StatusDraft},
+}
+
+// IsValidStatus reports whether status is one of Statuses
+func IsValidStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether an entry can move from one status to another
+func CanTransition(from, to string) bool {
+	for _, allowed := range statusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionError is returned when a status change is not allowed
+type TransitionError struct {
+	From, To string
+}
+
+func (e *TransitionError) Error() string {
+	if !IsValidStatus(e.To) {
+		return fmt.Sprintf("invalid status %q", e.To)
+	}
+	return fmt.Sprintf("cannot change status from %s to %s", e.From, e.To)
+}
+
 type ContentEntry struct {
 	ID            int             `json:"id"`
 	ContentTypeID int             `json:"content_type_id"`
-	Data          json.RawMessage `json:"data"`
+	// Data is the working draft
+	Data json.RawMessage `json:"data"`
+	// PublishedData is the snapshot served publicly; it is set only while the
+	// entry is published
+	PublishedData json.RawMessage `json:"published_data"`
 	Status        string          `json:"status"`
 	CreatedBy     *int            `json:"created_by"`
 	CreatedAt     time.Time       `json:"created_at"`
 	UpdatedAt     time.Time       `json:"updated_at"`
 	PublishedAt   *time.Time      `json:"published_at"`
+	PublishAt     *time.Time      `json:"publish_at"`
+	UnpublishAt   *time.Time      `json:"unpublish_at"`
+	// Version increases on every change, for optimistic concurrency control
+	Version int `json:"version"`
+	// Stage is the workflow stage; nil unless the content type has a workflow
+	Stage *string `json:"stage"`
+}
+
+// HasUnpublishedChanges reports whether a published entry's draft differs from
+// its published snapshot. JSONB output is normalized, so equal documents read
+// from the database are byte-for-byte equal.
+func (e *ContentEntry) HasUnpublishedChanges() bool {
+	return e.Status == StatusPublished && !bytes.Equal(e.Data, e.PublishedData)
+}
+
+// Published returns a copy of the entry whose Data is the published snapshot
+func (e *ContentEntry) Published() *ContentEntry {
+	published := *e
+	published.Data = e.PublishedData
+	return &published
 }
 
-func CreateContentEntry(db *sql.DB, contentTypeID int, data json.RawMessage, status string, createdBy *int) (*ContentEntry, error) {
+// entryColumns are the columns read by scanEntry, in order
+const entryColumns = `id, content_type_id, data, published_data, status, created_by, created_at, updated_at, published_at, publish_at, unpublish_at, version, stage`
+
+// scanEntry scans a row selected with entryColumns
+func scanEntry(row interface{ Scan(...interface{}) error }) (*ContentEntry, error) {
 	var entry ContentEntry
-	err := db.QueryRow(
-		`INSERT INTO content_entries (content_type_id, data, status, created_by) 
-		 VALUES ($1, $2, $3, $4) 
-		 RETURNING id, content_type_id, data, status, created_by, created_at, updated_at, published_at`,
-		contentTypeID, data, status, createdBy,
-	).Scan(&entry.ID, &entry.ContentTypeID, &entry.Data, &entry.Status, &entry.CreatedBy, &entry.CreatedAt, &entry.UpdatedAt, &entry.PublishedAt)
+	var publishedData []byte
+	err := row.Scan(&entry.ID, &entry.ContentTypeID, &entry.Data, &publishedData, &entry.Status, &entry.CreatedBy, &entry.CreatedAt, &entry.UpdatedAt, &entry.PublishedAt, &entry.PublishAt, &entry.UnpublishAt, &entry.Version, &entry.Stage)
+	if err != nil {
+		return nil, err
+	}
+	entry.PublishedData = publishedData
+	return &entry, nil
+}
+
+// CreateContentEntry creates an entry as a draft or, stamping published_at and
+// snapshotting its data, as published, and records its first revision. Entries
+// of content types with a workflow start as drafts in the workflow's first stage.
+func CreateContentEntry(db *sql.DB, contentTypeID int, data json.RawMessage, status string, createdBy *int, message string, now time.Time) (*ContentEntry, error) {
+	if status != StatusDraft && status != StatusPublished {
+		return nil, fmt.Errorf("invalid status %q (new entries are draft or published)", status)
+	}
+
+	var publishedAt *time.Time
+	var publishedData json.RawMessage
+	if status == StatusPublished {
+		publishedAt = &now
+		publishedData = data
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	entry, err := scanEntry(tx.QueryRow(
+		`INSERT INTO content_entries (content_type_id, data, published_data, status, created_by, created_at, updated_at, published_at, stage) 
+		 VALUES ($1, $2, $3, $4, $5, $6, $6, $7, (SELECT w.stages->0->>'name' FROM workflows w WHERE w.content_type_id = $1)) 
+		 RETURNING `+entryColumns,
+		contentTypeID, data, nullableJSON(publishedData), status, createdBy, now, publishedAt,
+	))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create content entry: %w", err)
 	}
 
-	return &entry, nil
+	if entry.Stage != nil {
+		if status != StatusDraft {
+			return nil, &WorkflowError{Reason: "entries of content types with a workflow are created as drafts"}
+		}
+		if err := insertStageChange(tx, entry.ID, nil, *entry.Stage, createdBy, message, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := insertRevision(tx, entry.ID, createdBy, message, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return entry, nil
 }
 
 func GetContentEntry(db *sql.DB, id int) (*ContentEntry, error) {
-	var entry ContentEntry
-	err := db.QueryRow(
-		`SELECT id, content_type_id, data, status, created_by, created_at, updated_at, published_at 
+	entry, err := scanEntry(db.QueryRow(
+		`SELECT `+entryColumns+` 
 		 FROM content_entries WHERE id = $1`,
 		id,
-	).Scan(&entry.ID, &entry.ContentTypeID, &entry.Data, &entry.Status, &entry.CreatedBy, &entry.CreatedAt, &entry.UpdatedAt, &entry.PublishedAt)
+	))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -49,34 +174,105 @@ func GetContentEntry(db *sql.DB, id int) (*ContentEntry, error) {
 		return nil, fmt.Errorf("failed to get content entry: %w", err)
 	}
 
-	return &entry, nil
+	return entry, nil
+}
+
+// EntryFilter selects the entries returned by ListContentEntries and CountContentEntries
+type EntryFilter struct {
+	ContentTypeID int
+	// Status limits the entries to one status; empty matches every status
+	Status string
+	// Where is a validated filter on entry data. Published entries are
+	// matched on their published snapshot, every other status on the draft.
+	Where *query.Filter
 }
 
-func ListContentEntries(db *sql.DB, contentTypeID int, limit, offset int, orderBy, orderDirection string) ([]ContentEntry, error) {
-	// Validate orderBy to prevent SQL injection
-	validOrderFields := map[string]bool{
-		"id":           true,
-		"created_at":   true,
-		"updated_at":   true,
-		"published_at": true,
-		"status":       true,
+// where returns the WHERE clause of the filter and its arguments
+func (f EntryFilter) where() (string, []interface{}, error) {
+	clause := "content_type_id = $1"
+	args := []interface{}{f.ContentTypeID}
+	if f.Status != "" {
+		args = append(args, f.Status)
+		clause += fmt.Sprintf(" AND status = $%d", len(args))
 	}
-	if !validOrderFields[orderBy] {
-		orderBy = "created_at"
+	if f.Where != nil {
+		condition, whereArgs, err := f.Where.SQL(f.dataColumn(), args)
+		if err != nil {
+			return "", nil, err
+		}
+		clause += " AND " + condition
+		args = whereArgs
 	}
+	return clause, args, nil
+}
+
+// dataColumn is the JSONB column filters and sort keys read entry data from
+func (f EntryFilter) dataColumn() string {
+	if f.Status == StatusPublished {
+		return "published_data"
+	}
+	return "data"
+}
+
+// entrySortColumns are the entry columns that can be sorted by, with their SQL types
+var entrySortColumns = map[string]string{
+	"id":           "integer",
+	"created_at":   "timestamp",
+	"updated_at":   "timestamp",
+	"published_at": "timestamp",
+	"status":       "text",
+}
+
+// IsEntrySortColumn reports whether entries can be sorted by the named column
+func IsEntrySortColumn(name string) bool {
+	return entrySortColumns[name] != ""
+}
 
-	// Validate order direction
-	if orderDirection != "ASC" && orderDirection != "DESC" {
-		orderDirection = "DESC"
+// entryOrder resolves the fields of validated sort keys to entry columns and
+// completes the ordering: newest first when there are no keys, and by id among
+// entries that sort equal
+func entryOrder(order []query.Sort) ([]query.Sort, error) {
+	if len(order) == 0 {
+		order = []query.Sort{{Field: "created_at", Descending: true}}
 	}
 
+	keys := make([]query.Sort, 0, len(order)+1)
+	keys = append(keys, order...)
+	if last := keys[len(keys)-1]; last.Field != "id" {
+		keys = append(keys, query.Sort{Field: "id", Descending: last.Descending})
+	}
+	for i := range keys {
+		if err := keys[i].Resolve(entrySortColumns); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// ListContentEntries returns a page of the entries matching filter in the
+// order of the validated sort keys, newest first when there are none. Entries
+// that sort equal are ordered by id.
+func ListContentEntries(db *sql.DB, filter EntryFilter, order []query.Sort, limit, offset int) ([]ContentEntry, error) {
+	order, err := entryOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args, err := filter.where()
+	if err != nil {
+		return nil, err
+	}
+	orderBy, args, err := query.OrderBy(order, filter.dataColumn(), args)
+	if err != nil {
+		return nil, err
+	}
 	query := fmt.Sprintf(
-		`SELECT id, content_type_id, data, status, created_by, created_at, updated_at, published_at 
-		 FROM content_entries WHERE content_type_id = $1 ORDER BY %s %s LIMIT $2 OFFSET $3`,
-		orderBy, orderDirection,
+		`SELECT %s 
+		 FROM content_entries WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d`,
+		entryColumns, where, orderBy, len(args)+1, len(args)+2,
 	)
 
-	rows, err := db.Query(query, contentTypeID, limit, offset)
+	rows, err := db.Query(query, append(args, limit, offset)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list content entries: %w", err)
 	}
@@ -84,44 +280,256 @@ func ListContentEntries(db *sql.DB, contentTypeID int, limit, offset int, orderB
 
 	var entries []ContentEntry
 	for rows.Next() {
-		var entry ContentEntry
-		if err := rows.Scan(&entry.ID, &entry.ContentTypeID, &entry.Data, &entry.Status, &entry.CreatedBy, &entry.CreatedAt, &entry.UpdatedAt, &entry.PublishedAt); err != nil {
+		entry, err := scanEntry(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan content entry: %w", err)
 		}
-		entries = append(entries, entry)
+		entries = append(entries, *entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list content entries: %w", err)
 	}
 
 	return entries, nil
 }
 
-// CountContentEntries returns the total number of content entries for a given content type
-func CountContentEntries(db *sql.DB, contentTypeID int) (int, error) {
+// EntryConnection is a keyset page of content entries with a cursor for each
+type EntryConnection struct {
+	Entries  []ContentEntry
+	Cursors  []string
+	PageInfo query.PageInfo
+}
+
+// PageContentEntries returns the page of the entries matching filter that page
+// selects, in the order of the validated sort keys as in ListContentEntries
+func PageContentEntries(db *sql.DB, filter EntryFilter, order []query.Sort, page query.Page) (*EntryConnection, error) {
+	order, err := entryOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args, err := filter.where()
+	if err != nil {
+		return nil, err
+	}
+	keyset, err := page.Compile(order, filter.dataColumn(), args)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf(
+		`SELECT %s, %s 
+		 FROM content_entries WHERE %s AND %s ORDER BY %s LIMIT %d`,
+		entryColumns, keyset.Columns, where, keyset.Condition, keyset.OrderBy, keyset.Limit,
+	), keyset.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list content entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []ContentEntry
+	var cursors []string
+	for rows.Next() {
+		values := keyset.Values()
+		entry, err := scanEntry(withColumns{rows, values})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan content entry: %w", err)
+		}
+		entries = append(entries, *entry)
+		cursors = append(cursors, keyset.Cursor(values))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list content entries: %w", err)
+	}
+
+	indexes, info := keyset.Window(cursors)
+	conn := &EntryConnection{PageInfo: info}
+	for _, i := range indexes {
+		conn.Entries = append(conn.Entries, entries[i])
+		conn.Cursors = append(conn.Cursors, cursors[i])
+	}
+	return conn, nil
+}
+
+// CountContentEntries returns the total number of content entries matching filter
+func CountContentEntries(db *sql.DB, filter EntryFilter) (int, error) {
+	where, args, err := filter.where()
+	if err != nil {
+		return 0, err
+	}
 	var count int
-	err := db.QueryRow(`SELECT COUNT(*) FROM content_entries WHERE content_type_id = $1`, contentTypeID).Scan(&count)
+	err = db.QueryRow(`SELECT COUNT(*) FROM content_entries WHERE `+where, args...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count content entries: %w", err)
 	}
 	return count, nil
 }
 
-func UpdateContentEntry(db *sql.DB, id int, data json.RawMessage, status string) error {
-	_, err := db.Exec(
+// EntryUpdate describes a change to an entry. Nil Data or an empty Status keeps
+// the current value.
+type EntryUpdate struct {
+	Data     json.RawMessage
+	Status   string
+	AuthorID *int
+	// Message is stored with the revision the update creates
+	Message string
+	// ExpectedVersion, if set, must match the stored version
+	ExpectedVersion *int
+	// Transition, if set, moves the entry through its workflow instead of
+	// following the default status transitions. The entry must be in the
+	// transition's From stage and have the required approvals.
+	Transition *WorkflowTransition
+}
+
+// UpdateContentEntry applies an update to an entry, records it as a new
+// revision and returns the updated entry. Data changes only touch the working
+// draft. Status changes must follow the allowed transitions; publishing copies
+// the draft to the published snapshot and stamps published_at, and any other
+// status drops the snapshot.
+func UpdateContentEntry(db *sql.DB, id int, update EntryUpdate, now time.Time) (*ContentEntry, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	locked, err := lockEntry(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(update.ExpectedVersion, locked.Version); err != nil {
+		return nil, err
+	}
+
+	entry, err := applyUpdate(tx, id, locked, update, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return entry, nil
+}
+
+// applyUpdate applies an update to an entry locked within tx
+func applyUpdate(tx *sql.Tx, id int, locked *lockedEntry, update EntryUpdate, now time.Time) (*ContentEntry, error) {
+	current := locked.Status
+	status := update.Status
+	if status == "" {
+		status = current
+	}
+
+	var stage *string
+	if t := update.Transition; t != nil {
+		if locked.Stage == nil || *locked.Stage != t.From {
+			return nil, &WorkflowError{Reason: fmt.Sprintf("the entry is not in stage %q", t.From)}
+		}
+		if t.RequiredApprovals > 0 {
+			approvals, err := countApprovals(tx, id, t.From)
+			if err != nil {
+				return nil, err
+			}
+			if approvals < t.RequiredApprovals {
+				return nil, &WorkflowError{Reason: fmt.Sprintf("moving to %q needs %d approvals, it has %d", t.To, t.RequiredApprovals, approvals)}
+			}
+		}
+		stage = &t.To
+	} else if locked.Stage != nil && (status != current || update.Status == StatusPublished) {
+		return nil, &WorkflowError{Reason: "entries with a workflow change status through its transitions"}
+	} else if status != current && !CanTransition(current, status) {
+		return nil, &TransitionError{From: current, To: status}
+	}
+
+	publishing := update.Status == StatusPublished
+	unpublished := status != StatusPublished
+	// Archived entries are taken off any publishing schedule
+	archiving := status == StatusArchived && current != StatusArchived
+	entry, err := scanEntry(tx.QueryRow(
 		`UPDATE content_entries 
-		 SET data = $1, status = $2, updated_at = CURRENT_TIMESTAMP 
-		 WHERE id = $3`,
-		data, status, id,
-	)
+		 SET data = COALESCE($1, data), status = $2, updated_at = $3, version = version + 1, 
+		     published_data = CASE WHEN $4 THEN COALESCE($1, data) WHEN $5 THEN NULL ELSE published_data END, 
+		     published_at = CASE WHEN $4 THEN $3 ELSE published_at END, 
+		     publish_at = CASE WHEN $6 THEN NULL ELSE publish_at END, 
+		     unpublish_at = CASE WHEN $6 THEN NULL ELSE unpublish_at END, 
+		     stage = COALESCE($7, stage) 
+		 WHERE id = $8 
+		 RETURNING `+entryColumns,
+		nullableJSON(update.Data), status, now, publishing, unpublished, archiving, stage, id,
+	))
 	if err != nil {
-		return fmt.Errorf("failed to update content entry: %w", err)
+		return nil, fmt.Errorf("failed to update content entry: %w", err)
 	}
-	return nil
+
+	if err := insertRevision(tx, id, update.AuthorID, update.Message, now); err != nil {
+		return nil, err
+	}
+
+	if stage != nil {
+		if err := insertStageChange(tx, id, locked.Stage, *stage, update.AuthorID, update.Message, now); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
 }
 
-func DeleteContentEntry(db *sql.DB, id int) error {
-	_, err := db.Exec(`DELETE FROM content_entries WHERE id = $1`, id)
+// lockedEntry is the state of an entry read by lockEntry
+type lockedEntry struct {
+	Status  string
+	Version int
+	Stage   *string
+}
+
+// lockEntry locks an entry row for the rest of tx and returns its status, version and stage
+func lockEntry(tx *sql.Tx, id int) (*lockedEntry, error) {
+	var locked lockedEntry
+	err := tx.QueryRow(`SELECT status, version, stage FROM content_entries WHERE id = $1 FOR UPDATE`, id).Scan(&locked.Status, &locked.Version, &locked.Stage)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("content entry not found")
+		}
+		return nil, fmt.Errorf("failed to get content entry: %w", err)
+	}
+	return &locked, nil
+}
+
+// nullableJSON maps nil data to SQL NULL
+func nullableJSON(data json.RawMessage) interface{} {
+	if data == nil {
+		return nil
+	}
+	return []byte(data)
+}
+
+// DeleteContentEntry deletes an entry after applying the delete policies of
+// the references to it (see releaseReferences). A non-nil expectedVersion must
+// match the stored version.
+func DeleteContentEntry(db *sql.DB, id int, expectedVersion *int, check func(DeletionEffects) error, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	locked, err := lockEntry(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(expectedVersion, locked.Version); err != nil {
+		return err
+	}
+
+	ids, err := releaseReferences(tx, []int{id}, check, now)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM content_entries WHERE id = ANY($1::integer[])`, pq.Array(ids)); err != nil {
 		return fmt.Errorf("failed to delete content entry: %w", err)
 	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return nil
 }
 
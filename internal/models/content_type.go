@@ -5,6 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"gofrik/internal/query"
+	"gofrik/internal/search"
+
+	"github.com/lib/pq"
 )
 
 type ContentType struct {
@@ -15,31 +20,95 @@ type ContentType struct {
 	Schema      json.RawMessage `json:"schema"`
 	CreatedAt   time.Time       `json:"created_at"`
 	UpdatedAt   time.Time       `json:"updated_at"`
+	// Version increases on every update, for optimistic concurrency control
+	Version int `json:"version"`
 }
 
-func CreateContentType(db *sql.DB, name, slug, description string, schema json.RawMessage) (*ContentType, error) {
+// ContentTypeScope restricts content type lists to the content types a caller
+// may see
+type ContentTypeScope struct {
+	// All lists every content type; otherwise only those in IDs are listed
+	All bool
+	IDs []int
+}
+
+// Intersect returns the scope of content types in both sc and other
+func (sc ContentTypeScope) Intersect(other ContentTypeScope) ContentTypeScope {
+	switch {
+	case sc.All:
+		return other
+	case other.All:
+		return sc
+	}
+	var scope ContentTypeScope
+	for _, id := range sc.IDs {
+		for _, otherID := range other.IDs {
+			if id == otherID {
+				scope.IDs = append(scope.IDs, id)
+				break
+			}
+		}
+	}
+	return scope
+}
+
+// condition returns the SQL restricting content_types rows to the scope,
+// adding its parameter to args
+func (sc ContentTypeScope) condition(args []interface{}) (string, []interface{}) {
+	if sc.All {
+		return "TRUE", args
+	}
+	ids := make(pq.Int64Array, len(sc.IDs))
+	for i, id := range sc.IDs {
+		ids[i] = int64(id)
+	}
+	args = append(args, ids)
+	return fmt.Sprintf("id = ANY($%d::integer[])", len(args)), args
+}
+
+// contentTypeColumns are the columns read by scanContentType, in order
+const contentTypeColumns = `id, name, slug, description, schema, created_at, updated_at, version`
+
+func scanContentType(row interface{ Scan(...interface{}) error }) (*ContentType, error) {
 	var ct ContentType
-	err := db.QueryRow(
-		`INSERT INTO content_types (name, slug, description, schema) 
-		 VALUES ($1, $2, $3, $4) 
-		 RETURNING id, name, slug, description, schema, created_at, updated_at`,
-		name, slug, description, schema,
-	).Scan(&ct.ID, &ct.Name, &ct.Slug, &ct.Description, &ct.Schema, &ct.CreatedAt, &ct.UpdatedAt)
+	if err := row.Scan(&ct.ID, &ct.Name, &ct.Slug, &ct.Description, &ct.Schema, &ct.CreatedAt, &ct.UpdatedAt, &ct.Version); err != nil {
+		return nil, err
+	}
+	return &ct, nil
+}
+
+// CreateContentType creates a content type with the search settings and
+// reference properties its schema declares
+func CreateContentType(db *sql.DB, name, slug, description string, schema json.RawMessage) (*ContentType, error) {
+	config, fields, err := searchSettings(schema)
+	if err != nil {
+		return nil, err
+	}
+	references, err := referenceFields(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	ct, err := scanContentType(db.QueryRow(
+		`INSERT INTO content_types (name, slug, description, schema, search_config, search_fields, reference_fields) 
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) 
+		 RETURNING `+contentTypeColumns,
+		name, slug, description, schema, config, fields, references,
+	))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create content type: %w", err)
 	}
 
-	return &ct, nil
+	return ct, nil
 }
 
 func GetContentType(db *sql.DB, id int) (*ContentType, error) {
-	var ct ContentType
-	err := db.QueryRow(
-		`SELECT id, name, slug, description, schema, created_at, updated_at 
+	ct, err := scanContentType(db.QueryRow(
+		`SELECT `+contentTypeColumns+` 
 		 FROM content_types WHERE id = $1`,
 		id,
-	).Scan(&ct.ID, &ct.Name, &ct.Slug, &ct.Description, &ct.Schema, &ct.CreatedAt, &ct.UpdatedAt)
+	))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -48,16 +117,15 @@ func GetContentType(db *sql.DB, id int) (*ContentType, error) {
 		return nil, fmt.Errorf("failed to get content type: %w", err)
 	}
 
-	return &ct, nil
+	return ct, nil
 }
 
 func GetContentTypeBySlug(db *sql.DB, slug string) (*ContentType, error) {
-	var ct ContentType
-	err := db.QueryRow(
-		`SELECT id, name, slug, description, schema, created_at, updated_at 
+	ct, err := scanContentType(db.QueryRow(
+		`SELECT `+contentTypeColumns+` 
 		 FROM content_types WHERE slug = $1`,
 		slug,
-	).Scan(&ct.ID, &ct.Name, &ct.Slug, &ct.Description, &ct.Schema, &ct.CreatedAt, &ct.UpdatedAt)
+	))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -66,10 +134,10 @@ func GetContentTypeBySlug(db *sql.DB, slug string) (*ContentType, error) {
 		return nil, fmt.Errorf("failed to get content type: %w", err)
 	}
 
-	return &ct, nil
+	return ct, nil
 }
 
-func ListContentTypes(db *sql.DB, limit, offset int, orderBy, orderDirection string) ([]ContentType, error) {
+func ListContentTypes(db *sql.DB, scope ContentTypeScope, limit, offset int, orderBy, orderDirection string) ([]ContentType, error) {
 	// Validate orderBy to prevent SQL injection
 	validOrderFields := map[string]bool{
 		"id":         true,
@@ -87,13 +155,14 @@ func ListContentTypes(db *sql.DB, limit, offset int, orderBy, orderDirection str
 		orderDirection = "DESC"
 	}
 
+	condition, args := scope.condition([]interface{}{limit, offset})
 	query := fmt.Sprintf(
-		`SELECT id, name, slug, description, schema, created_at, updated_at 
-		 FROM content_types ORDER BY %s %s LIMIT $1 OFFSET $2`,
-		orderBy, orderDirection,
+		`SELECT %s 
+		 FROM content_types WHERE %s ORDER BY %s %s LIMIT $1 OFFSET $2`,
+		contentTypeColumns, condition, orderBy, orderDirection,
 	)
 
-	rows, err := db.Query(query, limit, offset)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list content types: %w", err)
 	}
@@ -101,44 +170,224 @@ func ListContentTypes(db *sql.DB, limit, offset int, orderBy, orderDirection str
 
 	var types []ContentType
 	for rows.Next() {
-		var ct ContentType
-		if err := rows.Scan(&ct.ID, &ct.Name, &ct.Slug, &ct.Description, &ct.Schema, &ct.CreatedAt, &ct.UpdatedAt); err != nil {
+		ct, err := scanContentType(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan content type: %w", err)
 		}
-		types = append(types, ct)
+		types = append(types, *ct)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list content types: %w", err)
 	}
 
 	return types, nil
 }
 
-// CountContentTypes returns the total number of content types
-func CountContentTypes(db *sql.DB) (int, error) {
+// contentTypeSortColumns are the content type columns that can be sorted by, with their SQL types
+var contentTypeSortColumns = map[string]string{
+	"id":         "integer",
+	"name":       "text",
+	"slug":       "text",
+	"created_at": "timestamp",
+	"updated_at": "timestamp",
+}
+
+// ContentTypeConnection is a keyset page of content types with a cursor for each
+type ContentTypeConnection struct {
+	Types    []ContentType
+	Cursors  []string
+	PageInfo query.PageInfo
+}
+
+// PageContentTypes returns the page of content types that page selects,
+// ordered like ListContentTypes and by id among content types that sort equal
+func PageContentTypes(db *sql.DB, scope ContentTypeScope, orderBy, orderDirection string, page query.Page) (*ContentTypeConnection, error) {
+	if contentTypeSortColumns[orderBy] == "" {
+		orderBy = "created_at"
+	}
+	descending := orderDirection != "ASC"
+	order := []query.Sort{{Field: orderBy, Descending: descending}}
+	if orderBy != "id" {
+		order = append(order, query.Sort{Field: "id", Descending: descending})
+	}
+	for i := range order {
+		if err := order[i].Resolve(contentTypeSortColumns); err != nil {
+			return nil, err
+		}
+	}
+
+	condition, args := scope.condition(nil)
+	keyset, err := page.Compile(order, "", args)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf(
+		`SELECT %s, %s 
+		 FROM content_types WHERE %s AND %s ORDER BY %s LIMIT %d`,
+		contentTypeColumns, keyset.Columns, condition, keyset.Condition, keyset.OrderBy, keyset.Limit,
+	), keyset.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list content types: %w", err)
+	}
+	defer rows.Close()
+
+	var types []ContentType
+	var cursors []string
+	for rows.Next() {
+		values := keyset.Values()
+		ct, err := scanContentType(withColumns{rows, values})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan content type: %w", err)
+		}
+		types = append(types, *ct)
+		cursors = append(cursors, keyset.Cursor(values))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list content types: %w", err)
+	}
+
+	indexes, info := keyset.Window(cursors)
+	conn := &ContentTypeConnection{PageInfo: info}
+	for _, i := range indexes {
+		conn.Types = append(conn.Types, types[i])
+		conn.Cursors = append(conn.Cursors, cursors[i])
+	}
+	return conn, nil
+}
+
+// ListAllContentTypes returns every content type ordered by ID
+func ListAllContentTypes(db *sql.DB) ([]ContentType, error) {
+	rows, err := db.Query(
+		`SELECT `+contentTypeColumns+` 
+		 FROM content_types ORDER BY id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list content types: %w", err)
+	}
+	defer rows.Close()
+
+	var types []ContentType
+	for rows.Next() {
+		ct, err := scanContentType(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan content type: %w", err)
+		}
+		types = append(types, *ct)
+	}
+
+	return types, rows.Err()
+}
+
+// GetSchemaVersion returns the counter bumped by every change to content types
+func GetSchemaVersion(db *sql.DB) (int64, error) {
+	var version int64
+	err := db.QueryRow(`SELECT version FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	return version, nil
+}
+
+// CountContentTypes returns the number of content types in scope
+func CountContentTypes(db *sql.DB, scope ContentTypeScope) (int, error) {
 	var count int
-	err := db.QueryRow(`SELECT COUNT(*) FROM content_types`).Scan(&count)
+	condition, args := scope.condition(nil)
+	err := db.QueryRow(`SELECT COUNT(*) FROM content_types WHERE `+condition, args...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count content types: %w", err)
 	}
 	return count, nil
 }
 
-func UpdateContentType(db *sql.DB, id int, name, description string, schema json.RawMessage) error {
-	_, err := db.Exec(
+// UpdateContentType saves a content type and bumps its version. A non-nil
+// expectedVersion must match the stored version or a VersionConflictError is returned.
+// Entries are reindexed for search when the schema's search settings change,
+// and their references are tracked anew when its reference properties change.
+func UpdateContentType(db *sql.DB, id int, name, description string, schema json.RawMessage, expectedVersion *int) error {
+	config, fields, err := searchSettings(schema)
+	if err != nil {
+		return err
+	}
+	references, err := referenceFields(schema)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = db.QueryRow(
 		`UPDATE content_types 
-		 SET name = $1, description = $2, schema = $3, updated_at = CURRENT_TIMESTAMP 
-		 WHERE id = $4`,
-		name, description, schema, id,
-	)
+		 SET name = $1, description = $2, schema = $3, search_config = $6, search_fields = $7, 
+		     reference_fields = $8, updated_at = CURRENT_TIMESTAMP, version = version + 1 
+		 WHERE id = $4 AND ($5::integer IS NULL OR version = $5) 
+		 RETURNING version`,
+		name, description, schema, id, expectedVersion, config, fields, references,
+	).Scan(&version)
+	if err == sql.ErrNoRows {
+		current, err := GetContentType(db, id)
+		if err != nil {
+			return err
+		}
+		return &VersionConflictError{Expected: *expectedVersion, Actual: current.Version}
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update content type: %w", err)
 	}
 	return nil
 }
 
-func DeleteContentType(db *sql.DB, id int) error {
-	_, err := db.Exec(`DELETE FROM content_types WHERE id = $1`, id)
+// searchSettings returns the text search configuration and the JSON encoded
+// search fields declared by a schema
+func searchSettings(schema json.RawMessage) (string, []byte, error) {
+	settings, err := search.FromSchema(schema)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid search settings: %w", err)
+	}
+	fields, err := json.Marshal(settings.Fields)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode search fields: %w", err)
+	}
+	return settings.Config, fields, nil
+}
+
+// DeleteContentType deletes a content type and its entries after applying the
+// delete policies of the references to them (see releaseReferences)
+func DeleteContentType(db *sql.DB, id int, check func(DeletionEffects) error, now time.Time) error {
+	tx, err := db.Begin()
 	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var entryIDs []int64
+	err = tx.QueryRow(
+		`SELECT COALESCE(array_agg(id ORDER BY id), '{}') FROM content_entries WHERE content_type_id = $1`,
+		id,
+	).Scan(pq.Array(&entryIDs))
+	if err != nil {
+		return fmt.Errorf("failed to get content entries: %w", err)
+	}
+	ids := make([]int, len(entryIDs))
+	for i, entryID := range entryIDs {
+		ids[i] = int(entryID)
+	}
+
+	// The entries and those deleted with them through cascading references go
+	// in one statement, so references among them do not block it
+	ids, err = releaseReferences(tx, ids, check, now)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM content_entries WHERE id = ANY($1::integer[])`, pq.Array(ids)); err != nil {
+		return fmt.Errorf("failed to delete content entries: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM content_types WHERE id = $1`, id); err != nil {
 		return fmt.Errorf("failed to delete content type: %w", err)
 	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return nil
 }
 
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Invitation is a single-use, expiring invitation to create an account
+type Invitation struct {
+	ID         int        `json:"id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	TokenHash  string     `json:"-"`
+	InvitedBy  *int       `json:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func CreateInvitation(db *sql.DB, email, role, tokenHash string, invitedBy int, expiresAt time.Time) (*Invitation, error) {
+	var inv Invitation
+	err := db.QueryRow(
+		`INSERT INTO invitations (email, role, token_hash, invited_by, expires_at) 
+		 VALUES ($1, $2, $3, $4, $5) 
+		 RETURNING id, email, role, token_hash, invited_by, expires_at, accepted_at, created_at`,
+		email, role, tokenHash, invitedBy, expiresAt,
+	).Scan(&inv.ID, &inv.Email, &inv.Role, &inv.TokenHash, &inv.InvitedBy, &inv.ExpiresAt, &inv.AcceptedAt, &inv.CreatedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	return &inv, nil
+}
+
+func GetInvitationByTokenHash(db *sql.DB, tokenHash string) (*Invitation, error) {
+	var inv Invitation
+	err := db.QueryRow(
+		`SELECT id, email, role, token_hash, invited_by, expires_at, accepted_at, created_at 
+		 FROM invitations WHERE token_hash = $1`,
+		tokenHash,
+	).Scan(&inv.ID, &inv.Email, &inv.Role, &inv.TokenHash, &inv.InvitedBy, &inv.ExpiresAt, &inv.AcceptedAt, &inv.CreatedAt)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("invitation not found")
+		}
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+
+	return &inv, nil
+}
+
+// ListPendingInvitations returns invitations that are neither accepted nor expired
+func ListPendingInvitations(db *sql.DB, now time.Time) ([]Invitation, error) {
+	rows, err := db.Query(
+		`SELECT id, email, role, token_hash, invited_by, expires_at, accepted_at, created_at 
+		 FROM invitations WHERE accepted_at IS NULL AND expires_at > $1 ORDER BY created_at DESC`,
+		now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %w", err)
+	}
+	defer rows.Close()
+
+	var invitations []Invitation
+	for rows.Next() {
+		var inv Invitation
+		if err := rows.Scan(&inv.ID, &inv.Email, &inv.Role, &inv.TokenHash, &inv.InvitedBy, &inv.ExpiresAt, &inv.AcceptedAt, &inv.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan invitation: %w", err)
+		}
+		invitations = append(invitations, inv)
+	}
+
+	return invitations, rows.Err()
+}
+
+// AcceptInvitation marks the invitation as used and creates its user in one
+// transaction, so a token can never create two accounts
+func AcceptInvitation(db *sql.DB, inv *Invitation, password string, now time.Time) (*User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE invitations SET accepted_at = $1 
+		 WHERE id = $2 AND accepted_at IS NULL AND expires_at > $1`,
+		now, inv.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept invitation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("invitation is no longer valid")
+	}
+
+	user, err := createUser(tx, inv.Email, password, inv.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return user, nil
+}
+
+func DeleteInvitation(db *sql.DB, id int) error {
+	_, err := db.Exec(`DELETE FROM invitations WHERE id = $1 AND accepted_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete invitation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package models
+
+// withColumns scans a row whose leading columns are read by a scan helper and
+// whose trailing columns go to extra, such as the cursor columns of a keyset page
+type withColumns struct {
+	row   interface{ Scan(...interface{}) error }
+	extra []interface{}
+}
+
+func (w withColumns) Scan(dest ...interface{}) error {
+	return w.row.Scan(append(dest, w.extra...)...)
+}
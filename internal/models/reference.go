@@ -0,0 +1,212 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gofrik/internal/reference"
+
+	"github.com/lib/pq"
+)
+
+// unlinkMessage is the revision message of entries whose references to deleted entries were removed
+const unlinkMessage = "Removed references to deleted entries"
+
+// ReferencedError is returned when deleting an entry that another entry
+// references through a property with the restrict policy
+type ReferencedError struct {
+	EntryID  int
+	SourceID int
+	Property string
+}
+
+func (e *ReferencedError) Error() string {
+	return fmt.Sprintf("content entry %d is referenced by entry %d through %q", e.EntryID, e.SourceID, e.Property)
+}
+
+// EntryRef identifies an entry and its content type
+type EntryRef struct {
+	ID            int
+	ContentTypeID int
+}
+
+// DeletionEffects are the other entries a deletion changes through
+// references: the entries deleted along with it and the entries whose
+// references to deleted entries are removed
+type DeletionEffects struct {
+	Deleted []EntryRef
+	Updated []EntryRef
+}
+
+// referenceFields returns the JSON encoded reference properties declared by a schema
+func referenceFields(schema json.RawMessage) ([]byte, error) {
+	fields, err := reference.FromSchema(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid references: %w", err)
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode reference fields: %w", err)
+	}
+	return encoded, nil
+}
+
+// GetReferenceTargets returns the content type slug of each existing entry
+// among ids
+func GetReferenceTargets(db *sql.DB, ids []int) (map[int]string, error) {
+	rows, err := db.Query(
+		`SELECT e.id, t.slug 
+		 FROM content_entries e JOIN content_types t ON t.id = e.content_type_id 
+		 WHERE e.id = ANY($1::integer[])`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get referenced entries: %w", err)
+	}
+	defer rows.Close()
+
+	targets := map[int]string{}
+	for rows.Next() {
+		var id int
+		var slug string
+		if err := rows.Scan(&id, &slug); err != nil {
+			return nil, fmt.Errorf("failed to scan referenced entry: %w", err)
+		}
+		targets[id] = slug
+	}
+	return targets, rows.Err()
+}
+
+// GetContentEntries returns the existing entries among ids, keyed by id
+func GetContentEntries(db *sql.DB, ids []int) (map[int]*ContentEntry, error) {
+	rows, err := db.Query(
+		`SELECT `+entryColumns+` 
+		 FROM content_entries WHERE id = ANY($1::integer[])`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get content entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := map[int]*ContentEntry{}
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan content entry: %w", err)
+		}
+		entries[entry.ID] = entry
+	}
+	return entries, rows.Err()
+}
+
+// releaseReferences applies the delete policies of the references to entries
+// about to be deleted within tx. Entries referencing them through cascading
+// properties are deleted too, restricting properties of any other entry fail
+// the deletion with a ReferencedError and nulling properties have the ids
+// removed, recorded as a new revision. check, when set, may refuse the
+// effects before anything changes. It returns every entry to delete.
+func releaseReferences(tx *sql.Tx, ids []int, check func(DeletionEffects) error, now time.Time) ([]int, error) {
+	var effects DeletionEffects
+	deleting := map[int]bool{}
+	for _, id := range ids {
+		deleting[id] = true
+	}
+
+	all := append([]int{}, ids...)
+	for next := ids; len(next) > 0; {
+		cascaded, err := queryEntryRefs(tx,
+			`SELECT DISTINCT e.id, e.content_type_id 
+			 FROM content_references r JOIN content_entries e ON e.id = r.source_id 
+			 WHERE r.target_id = ANY($1::integer[]) AND r.on_delete = 'cascade' 
+			 ORDER BY e.id`,
+			pq.Array(next),
+		)
+		if err != nil {
+			return nil, err
+		}
+		next = nil
+		for _, ref := range cascaded {
+			if deleting[ref.ID] {
+				continue
+			}
+			deleting[ref.ID] = true
+			all = append(all, ref.ID)
+			next = append(next, ref.ID)
+			effects.Deleted = append(effects.Deleted, ref)
+		}
+	}
+
+	restricted := ReferencedError{}
+	err := tx.QueryRow(
+		`SELECT target_id, source_id, property FROM content_references 
+		 WHERE target_id = ANY($1::integer[]) AND on_delete = 'restrict' AND NOT source_id = ANY($1::integer[]) 
+		 ORDER BY target_id, source_id, property LIMIT 1`,
+		pq.Array(all),
+	).Scan(&restricted.EntryID, &restricted.SourceID, &restricted.Property)
+	if err == nil {
+		return nil, &restricted
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to check references: %w", err)
+	}
+
+	effects.Updated, err = queryEntryRefs(tx,
+		`SELECT DISTINCT e.id, e.content_type_id 
+		 FROM content_references r JOIN content_entries e ON e.id = r.source_id 
+		 WHERE r.target_id = ANY($1::integer[]) AND r.on_delete = 'setNull' AND NOT r.source_id = ANY($1::integer[]) 
+		 ORDER BY e.id`,
+		pq.Array(all),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if check != nil {
+		if err := check(effects); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ref := range effects.Updated {
+		_, err := tx.Exec(
+			`UPDATE content_entries e 
+			 SET data = gofrik_unlink(data, p.properties, $2::integer[]), 
+			     published_data = gofrik_unlink(published_data, p.properties, $2::integer[]), 
+			     updated_at = $3, version = version + 1 
+			 FROM (SELECT array_agg(DISTINCT property)::text[] AS properties FROM content_references 
+			       WHERE source_id = $1 AND target_id = ANY($2::integer[]) AND on_delete = 'setNull') p 
+			 WHERE e.id = $1`,
+			ref.ID, pq.Array(all), now,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove references from content entry %d: %w", ref.ID, err)
+		}
+		if err := insertRevision(tx, ref.ID, nil, unlinkMessage, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return all, nil
+}
+
+// queryEntryRefs runs a query selecting entry ids and content type ids within tx
+func queryEntryRefs(tx *sql.Tx, query string, args ...interface{}) ([]EntryRef, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get referencing entries: %w", err)
+	}
+	defer rows.Close()
+
+	var refs []EntryRef
+	for rows.Next() {
+		var ref EntryRef
+		if err := rows.Scan(&ref.ID, &ref.ContentTypeID); err != nil {
+			return nil, fmt.Errorf("failed to scan referencing entry: %w", err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
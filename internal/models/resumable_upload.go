@@ -0,0 +1,195 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gofrik/internal/storage"
+
+	"github.com/lib/pq"
+)
+
+// ErrUploadNotFound is returned for resumable uploads that do not exist or
+// have expired
+var ErrUploadNotFound = errors.New("upload not found")
+
+// ErrUploadLocked is returned while another request writes to an upload
+var ErrUploadLocked = errors.New("upload is locked by another request")
+
+// ResumableUpload is a file being uploaded in chunks, and the asset metadata
+// to record once it is complete
+type ResumableUpload struct {
+	// ID is the random token identifying the upload in URLs
+	ID string
+	storage.Resumable
+	Filename   string
+	AltText    string
+	Folder     string
+	Tags       []string
+	UploadedBy *int
+	// LockToken identifies the request holding the lock of
+	// LockResumableUpload; it is only set on uploads returned by it
+	LockToken string
+	// ExpiresAt is when an abandoned upload is discarded; every chunk
+	// received postpones it
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// resumableUploadColumns are the columns read by scanResumableUpload, in order
+const resumableUploadColumns = `id, key, multipart_id, mime_type, size, upload_offset, parts, tail_size, hash_state, filename, alt_text, folder, tags, uploaded_by, expires_at, created_at, updated_at`
+
+func scanResumableUpload(row interface{ Scan(...interface{}) error }) (*ResumableUpload, error) {
+	var upload ResumableUpload
+	var parts []byte
+	var tags pq.StringArray
+	err := row.Scan(&upload.ID, &upload.Key, &upload.MultipartID, &upload.ContentType, &upload.Size, &upload.Offset, &parts,
+		&upload.TailSize, &upload.HashState, &upload.Filename, &upload.AltText, &upload.Folder, &tags, &upload.UploadedBy,
+		&upload.ExpiresAt, &upload.CreatedAt, &upload.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(parts, &upload.Parts); err != nil {
+		return nil, fmt.Errorf("failed to decode upload parts: %w", err)
+	}
+	upload.Tags = []string(tags)
+	return &upload, nil
+}
+
+// CreateResumableUpload stores a new upload and fills in its timestamps
+func CreateResumableUpload(db *sql.DB, upload *ResumableUpload) error {
+	upload.Filename = NormalizeFilename(upload.Filename)
+	upload.Folder = NormalizeFolder(upload.Folder)
+	upload.Tags = NormalizeTags(upload.Tags)
+	err := db.QueryRow(
+		`INSERT INTO resumable_uploads (id, key, multipart_id, mime_type, size, filename, alt_text, folder, tags, uploaded_by, expires_at) 
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) 
+		 RETURNING created_at, updated_at`,
+		upload.ID, upload.Key, upload.MultipartID, upload.ContentType, upload.Size, upload.Filename, upload.AltText,
+		upload.Folder, pq.StringArray(upload.Tags), upload.UploadedBy, upload.ExpiresAt,
+	).Scan(&upload.CreatedAt, &upload.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create upload: %w", err)
+	}
+	return nil
+}
+
+// GetResumableUpload returns an upload that has not expired
+func GetResumableUpload(db *sql.DB, id string, now time.Time) (*ResumableUpload, error) {
+	upload, err := scanResumableUpload(db.QueryRow(
+		`SELECT `+resumableUploadColumns+` FROM resumable_uploads WHERE id = $1 AND expires_at > $2`,
+		id, now,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("failed to get upload: %w", err)
+	}
+	return upload, nil
+}
+
+// LockResumableUpload reserves an upload for the request holding lockToken,
+// a random token of its own, until the lock expires or is released, so that
+// two requests never append to it at once. The reservation survives crashes
+// only until lockedUntil.
+func LockResumableUpload(db *sql.DB, id, lockToken string, now, lockedUntil time.Time) (*ResumableUpload, error) {
+	upload, err := scanResumableUpload(db.QueryRow(
+		`UPDATE resumable_uploads 
+		 SET locked_until = $1, lock_token = $2 
+		 WHERE id = $3 AND expires_at > $4 AND (locked_until IS NULL OR locked_until <= $4) 
+		 RETURNING `+resumableUploadColumns,
+		lockedUntil, lockToken, id, now,
+	))
+	if err == nil {
+		upload.LockToken = lockToken
+		return upload, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to lock upload: %w", err)
+	}
+
+	// Tell uploads that are locked from ones that do not exist
+	if _, err := GetResumableUpload(db, id, now); err != nil {
+		return nil, err
+	}
+	return nil, ErrUploadLocked
+}
+
+// UnlockResumableUpload releases the lock of LockResumableUpload, unless it
+// expired and another request took the upload over
+func UnlockResumableUpload(db *sql.DB, id, lockToken string) error {
+	_, err := db.Exec(
+		`UPDATE resumable_uploads SET locked_until = NULL, lock_token = NULL WHERE id = $1 AND lock_token = $2`,
+		id, lockToken,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to unlock upload: %w", err)
+	}
+	return nil
+}
+
+// SaveResumableProgress records the progress of an upload locked by
+// LockResumableUpload, extending its lock and expiry. It returns
+// ErrUploadLocked when the lock expired and another request took the upload
+// over, which must then stop writing.
+func SaveResumableProgress(db *sql.DB, upload *ResumableUpload, lockedUntil, expiresAt, now time.Time) error {
+	parts, err := json.Marshal(upload.Parts)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload parts: %w", err)
+	}
+
+	result, err := db.Exec(
+		`UPDATE resumable_uploads 
+		 SET upload_offset = $1, parts = $2, tail_size = $3, hash_state = $4, locked_until = $5, expires_at = $6, updated_at = $7 
+		 WHERE id = $8 AND lock_token = $9`,
+		upload.Offset, parts, upload.TailSize, upload.HashState, lockedUntil, expiresAt, now, upload.ID, upload.LockToken,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save upload progress: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUploadLocked
+	}
+	upload.ExpiresAt = expiresAt
+	upload.UpdatedAt = now
+	return nil
+}
+
+func DeleteResumableUpload(db *sql.DB, id string) error {
+	if _, err := db.Exec(`DELETE FROM resumable_uploads WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+	return nil
+}
+
+// ListExpiredResumableUploads returns up to limit uploads that expired
+// before now and are not being written to
+func ListExpiredResumableUploads(db *sql.DB, now time.Time, limit int) ([]ResumableUpload, error) {
+	rows, err := db.Query(
+		`SELECT `+resumableUploadColumns+` FROM resumable_uploads 
+		 WHERE expires_at <= $1 AND (locked_until IS NULL OR locked_until <= $1) 
+		 ORDER BY expires_at LIMIT $2`,
+		now, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var uploads []ResumableUpload
+	for rows.Next() {
+		upload, err := scanResumableUpload(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan upload: %w", err)
+		}
+		uploads = append(uploads, *upload)
+	}
+
+	return uploads, rows.Err()
+}
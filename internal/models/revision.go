@@ -0,0 +1,141 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ContentRevision is an immutable snapshot of an entry, written on every save
+type ContentRevision struct {
+	ID       int             `json:"id"`
+	EntryID  int             `json:"entry_id"`
+	Revision int             `json:"revision"`
+	Data     json.RawMessage `json:"data"`
+	Status   string          `json:"status"`
+	AuthorID *int            `json:"author_id"`
+	Message  string          `json:"message"`
+	// RestoredFrom is the revision whose data this revision restored, if any
+	RestoredFrom *int      `json:"restored_from"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+const revisionColumns = `id, entry_id, revision, data, status, author_id, message, restored_from, created_at`
+
+func scanRevision(row interface{ Scan(...interface{}) error }) (*ContentRevision, error) {
+	var rev ContentRevision
+	err := row.Scan(&rev.ID, &rev.EntryID, &rev.Revision, &rev.Data, &rev.Status, &rev.AuthorID, &rev.Message, &rev.RestoredFrom, &rev.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// nextRevisionSQL numbers revisions per entry. Writers hold the entry row lock,
+// so numbers cannot be handed out twice.
+const nextRevisionSQL = `COALESCE((SELECT MAX(r.revision) FROM content_revisions r WHERE r.entry_id = e.id), 0) + 1`
+
+// insertRevision snapshots the current state of an entry within tx
+func insertRevision(tx *sql.Tx, entryID int, authorID *int, message string, now time.Time) error {
+	return insertRevisionFrom(tx, entryID, authorID, message, nil, now)
+}
+
+func insertRevisionFrom(tx *sql.Tx, entryID int, authorID *int, message string, restoredFrom *int, now time.Time) error {
+	_, err := tx.Exec(
+		`INSERT INTO content_revisions (entry_id, revision, data, status, author_id, message, restored_from, created_at) 
+		 SELECT e.id, `+nextRevisionSQL+`, e.data, e.status, $2::integer, $3::text, $4::integer, $5::timestamp 
+		 FROM content_entries e WHERE e.id = $1`,
+		entryID, authorID, message, restoredFrom, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record revision: %w", err)
+	}
+	return nil
+}
+
+func GetContentRevision(db *sql.DB, id int) (*ContentRevision, error) {
+	rev, err := scanRevision(db.QueryRow(`SELECT `+revisionColumns+` FROM content_revisions WHERE id = $1`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("revision not found")
+		}
+		return nil, fmt.Errorf("failed to get revision: %w", err)
+	}
+	return rev, nil
+}
+
+// ListContentRevisions returns the revisions of an entry, newest first
+func ListContentRevisions(db *sql.DB, entryID, limit, offset int) ([]ContentRevision, error) {
+	rows, err := db.Query(
+		`SELECT `+revisionColumns+` FROM content_revisions 
+		 WHERE entry_id = $1 ORDER BY revision DESC LIMIT $2 OFFSET $3`,
+		entryID, limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []ContentRevision
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan revision: %w", err)
+		}
+		revisions = append(revisions, *rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+// CountContentRevisions returns the number of revisions of an entry
+func CountContentRevisions(db *sql.DB, entryID int) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM content_revisions WHERE entry_id = $1`, entryID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count revisions: %w", err)
+	}
+	return count, nil
+}
+
+// RestoreContentRevision copies the data of an earlier revision back into its
+// entry and records that as a new revision; history is never rewritten. The
+// entry's status is left unchanged.
+func RestoreContentRevision(db *sql.DB, rev *ContentRevision, authorID *int, message string, expectedVersion *int, now time.Time) (*ContentEntry, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	locked, err := lockEntry(tx, rev.EntryID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(expectedVersion, locked.Version); err != nil {
+		return nil, err
+	}
+
+	entry, err := scanEntry(tx.QueryRow(
+		`UPDATE content_entries SET data = $1, updated_at = $2, version = version + 1 
+		 WHERE id = $3 
+		 RETURNING `+entryColumns,
+		[]byte(rev.Data), now, rev.EntryID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore revision: %w", err)
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Restored revision %d", rev.Revision)
+	}
+	if err := insertRevisionFrom(tx, rev.EntryID, authorID, message, &rev.ID, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return entry, nil
+}
@@ -0,0 +1,259 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Permissions, granted to roles either globally or for a single content type
+const (
+	PermRead         = "read"
+	PermCreate       = "create"
+	PermUpdate       = "update"
+	PermPublish      = "publish"
+	PermDelete       = "delete"
+	PermManageSchema = "manage_schema"
+)
+
+// Permissions lists every valid permission
+var Permissions = []string{PermRead, PermCreate, PermUpdate, PermPublish, PermDelete, PermManageSchema}
+
+// IsValidPermission reports whether permission is one of Permissions
+func IsValidPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type Role struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Builtin     bool             `json:"builtin"`
+	Permissions []RolePermission `json:"permissions"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+// RolePermission grants a permission on one content type, or on all of them when ContentTypeID is nil
+type RolePermission struct {
+	Permission    string `json:"permission"`
+	ContentTypeID *int   `json:"content_type_id"`
+}
+
+func CreateRole(db *sql.DB, name, description string) (*Role, error) {
+	var role Role
+	err := db.QueryRow(
+		`INSERT INTO roles (name, description) 
+		 VALUES ($1, $2) 
+		 RETURNING name, description, builtin, created_at`,
+		name, description,
+	).Scan(&role.Name, &role.Description, &role.Builtin, &role.CreatedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create role: %w", err)
+	}
+
+	return &role, nil
+}
+
+func GetRole(db *sql.DB, name string) (*Role, error) {
+	var role Role
+	err := db.QueryRow(
+		`SELECT name, description, builtin, created_at 
+		 FROM roles WHERE name = $1`,
+		name,
+	).Scan(&role.Name, &role.Description, &role.Builtin, &role.CreatedAt)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("role not found")
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+
+	role.Permissions, err = listRolePermissions(db, role.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// ListRoles returns every role with its permissions
+func ListRoles(db *sql.DB) ([]Role, error) {
+	rows, err := db.Query(`SELECT name, description, builtin, created_at FROM roles ORDER BY builtin DESC, name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.Name, &role.Description, &role.Builtin, &role.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	for i := range roles {
+		roles[i].Permissions, err = listRolePermissions(db, roles[i].Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return roles, nil
+}
+
+func listRolePermissions(db *sql.DB, role string) ([]RolePermission, error) {
+	rows, err := db.Query(
+		`SELECT permission, content_type_id FROM role_permissions 
+		 WHERE role = $1 ORDER BY content_type_id NULLS FIRST, permission`,
+		role,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list role permissions: %w", err)
+	}
+	defer rows.Close()
+
+	var permissions []RolePermission
+	for rows.Next() {
+		var rp RolePermission
+		if err := rows.Scan(&rp.Permission, &rp.ContentTypeID); err != nil {
+			return nil, fmt.Errorf("failed to scan role permission: %w", err)
+		}
+		permissions = append(permissions, rp)
+	}
+
+	return permissions, rows.Err()
+}
+
+// DeleteRole deletes a custom role that no user is assigned to
+func DeleteRole(db *sql.DB, name string) error {
+	var assigned int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE role = $1`, name).Scan(&assigned); err != nil {
+		return fmt.Errorf("failed to count role users: %w", err)
+	}
+	if assigned > 0 {
+		return fmt.Errorf("role is assigned to %d user(s)", assigned)
+	}
+
+	result, err := db.Exec(`DELETE FROM roles WHERE name = $1 AND NOT builtin`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("role not found or built in")
+	}
+	return nil
+}
+
+// GrantPermission grants a permission to a role, on one content type or globally when contentTypeID is nil
+func GrantPermission(db *sql.DB, role, permission string, contentTypeID *int) error {
+	_, err := db.Exec(
+		`INSERT INTO role_permissions (role, permission, content_type_id) 
+		 VALUES ($1, $2, $3) 
+		 ON CONFLICT DO NOTHING`,
+		role, permission, contentTypeID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to grant permission: %w", err)
+	}
+	return nil
+}
+
+// RevokePermission removes a grant made by GrantPermission
+func RevokePermission(db *sql.DB, role, permission string, contentTypeID *int) error {
+	_, err := db.Exec(
+		`DELETE FROM role_permissions 
+		 WHERE role = $1 AND permission = $2 AND content_type_id IS NOT DISTINCT FROM $3`,
+		role, permission, contentTypeID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke permission: %w", err)
+	}
+	return nil
+}
+
+// HasPermission reports whether an active user holds permission on a content type
+// (or globally when contentTypeID is nil). Admins hold every permission.
+func HasPermission(db *sql.DB, userID int, permission string, contentTypeID *int) (bool, error) {
+	var allowed bool
+	err := db.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM users u 
+			LEFT JOIN role_permissions rp ON rp.role = u.role 
+			 AND rp.permission = $2 
+			 AND (rp.content_type_id IS NULL OR rp.content_type_id = $3)
+			WHERE u.id = $1 AND u.active AND (u.role = $4 OR rp.role IS NOT NULL)
+		)`,
+		userID, permission, contentTypeID, RoleAdmin,
+	).Scan(&allowed)
+	if err != nil {
+		return false, fmt.Errorf("failed to check permission: %w", err)
+	}
+	return allowed, nil
+}
+
+// PermittedContentTypes returns the content types an active user holds
+// permission on: every content type for admins and global grants, otherwise
+// those granted one by one
+func PermittedContentTypes(db *sql.DB, userID int, permission string) (ContentTypeScope, error) {
+	var scope ContentTypeScope
+	var ids pq.Int64Array
+	err := db.QueryRow(
+		`SELECT u.role = $3 OR COALESCE(bool_or(rp.role IS NOT NULL AND rp.content_type_id IS NULL), FALSE), 
+		        COALESCE(array_agg(rp.content_type_id) FILTER (WHERE rp.content_type_id IS NOT NULL), '{}') 
+		 FROM users u 
+		 LEFT JOIN role_permissions rp ON rp.role = u.role AND rp.permission = $2 
+		 WHERE u.id = $1 AND u.active 
+		 GROUP BY u.id, u.role`,
+		userID, permission, RoleAdmin,
+	).Scan(&scope.All, &ids)
+	if err == sql.ErrNoRows {
+		return scope, nil
+	}
+	if err != nil {
+		return scope, fmt.Errorf("failed to list permitted content types: %w", err)
+	}
+	for _, id := range ids {
+		scope.IDs = append(scope.IDs, int(id))
+	}
+	return scope, nil
+}
+
+// SetUserRole assigns a role to a user
+func SetUserRole(db *sql.DB, userID int, role string) error {
+	result, err := db.Exec(
+		`UPDATE users 
+		 SET role = $1, updated_at = CURRENT_TIMESTAMP 
+		 WHERE id = $2`,
+		role, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to assign role: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// CountActiveAdmins returns the number of active users with the admin role
+func CountActiveAdmins(db *sql.DB) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE role = $1 AND active`, RoleAdmin).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count admins: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ScheduleContentEntry sets or clears (nil) the times an entry is published and
+// unpublished. A non-nil expectedVersion must match the stored version.
+func ScheduleContentEntry(db *sql.DB, id int, publishAt, unpublishAt *time.Time, expectedVersion *int) (*ContentEntry, error) {
+	if publishAt != nil && unpublishAt != nil && !unpublishAt.After(*publishAt) {
+		return nil, fmt.Errorf("unpublishAt must be after publishAt")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	locked, err := lockEntry(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(expectedVersion, locked.Version); err != nil {
+		return nil, err
+	}
+
+	entry, err := scanEntry(tx.QueryRow(
+		`UPDATE content_entries 
+		 SET publish_at = $1, unpublish_at = $2, version = version + 1 
+		 WHERE id = $3 
+		 RETURNING `+entryColumns,
+		publishAt, unpublishAt, id,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to schedule content entry: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return entry, nil
+}
+
+// hasWorkflowSQL matches content_entries rows whose content type has a
+// workflow; those are handled by the Due*WorkflowEntries functions
+const hasWorkflowSQL = `EXISTS (SELECT 1 FROM workflows w WHERE w.content_type_id = content_entries.content_type_id)`
+
+// PublishDueEntries publishes up to limit entries whose publish_at has passed,
+// promoting their drafts, clears their schedule and records a revision for
+// each one that changed status. It returns how many entries it handled, which
+// is less than limit once no more are due. Entries with a workflow are left to
+// PublishDueWorkflowEntries. Rows locked by another replica are skipped, so
+// concurrent schedulers never handle the same entry twice.
+func PublishDueEntries(db *sql.DB, now time.Time, limit int) (int, error) {
+	var published int
+	err := db.QueryRow(
+		`WITH due AS (
+			SELECT id, status FROM content_entries 
+			WHERE publish_at <= $1 AND NOT `+hasWorkflowSQL+` 
+			ORDER BY publish_at 
+			LIMIT $3 
+			FOR UPDATE SKIP LOCKED
+		 ), updated AS (
+			UPDATE content_entries e 
+			SET status = $2, publish_at = NULL, updated_at = $1, version = e.version + 1, 
+			    published_data = e.data, published_at = $1 
+			FROM due WHERE e.id = due.id 
+			RETURNING e.id, e.data, e.status, due.status AS previous_status
+		 ), revisions AS (
+			INSERT INTO content_revisions (entry_id, revision, data, status, message, created_at) 
+			SELECT e.id, `+nextRevisionSQL+`, e.data, e.status, $4::text, $1::timestamp 
+			FROM updated e WHERE e.previous_status <> e.status
+		 ) 
+		 SELECT count(*) FROM updated`,
+		now, StatusPublished, limit, "Scheduled publish",
+	).Scan(&published)
+	if err != nil {
+		return 0, fmt.Errorf("failed to publish scheduled entries: %w", err)
+	}
+	return published, nil
+}
+
+// UnpublishDueEntries moves up to limit published entries whose unpublish_at
+// has passed back to draft, drops their published snapshots, clears their
+// schedule and records revisions
+func UnpublishDueEntries(db *sql.DB, now time.Time, limit int) (int, error) {
+	var unpublished int
+	err := db.QueryRow(
+		`WITH due AS (
+			SELECT id, status FROM content_entries 
+			WHERE unpublish_at <= $1 AND (publish_at IS NULL OR publish_at > $1) AND NOT `+hasWorkflowSQL+` 
+			ORDER BY unpublish_at 
+			LIMIT $4 
+			FOR UPDATE SKIP LOCKED
+		 ), updated AS (
+			UPDATE content_entries e 
+			SET status = CASE WHEN due.status = $2 THEN $3 ELSE e.status END, 
+			    published_data = NULL, unpublish_at = NULL, updated_at = $1, version = e.version + 1 
+			FROM due WHERE e.id = due.id 
+			RETURNING e.id, e.data, e.status, due.status AS previous_status
+		 ), revisions AS (
+			INSERT INTO content_revisions (entry_id, revision, data, status, message, created_at) 
+			SELECT e.id, `+nextRevisionSQL+`, e.data, e.status, $5::text, $1::timestamp 
+			FROM updated e WHERE e.previous_status <> e.status
+		 ) 
+		 SELECT count(*) FROM updated`,
+		now, StatusPublished, StatusDraft, limit, "Scheduled unpublish",
+	).Scan(&unpublished)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unpublish scheduled entries: %w", err)
+	}
+	return unpublished, nil
+}
+
+// PublishDueWorkflowEntries publishes up to limit entries of content types with
+// a workflow whose publish_at has passed. Each entry takes a transition from
+// its stage to a published stage whose approvals are met; the roles were
+// checked when the publish was scheduled. If there is none the scheduled
+// publish is cancelled and the reason recorded in the entry's stage history.
+func PublishDueWorkflowEntries(db *sql.DB, now time.Time, limit int) (int, error) {
+	return moveDueWorkflowEntries(db, now, limit,
+		`publish_at <= $1`, "publish_at", StatusPublished, "Scheduled publish")
+}
+
+// UnpublishDueWorkflowEntries is the unpublishing counterpart of
+// PublishDueWorkflowEntries; entries move to a stage with the draft status
+func UnpublishDueWorkflowEntries(db *sql.DB, now time.Time, limit int) (int, error) {
+	return moveDueWorkflowEntries(db, now, limit,
+		`unpublish_at <= $1 AND (publish_at IS NULL OR publish_at > $1)`, "unpublish_at", StatusDraft, "Scheduled unpublish")
+}
+
+// moveDueWorkflowEntries moves workflow entries matching due into a stage with
+// status and clears the schedule column. It returns how many entries it handled.
+func moveDueWorkflowEntries(db *sql.DB, now time.Time, limit int, due, column, status, message string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT id, content_type_id FROM content_entries 
+		 WHERE `+due+` AND `+hasWorkflowSQL+` 
+		 ORDER BY `+column+` 
+		 LIMIT $2 
+		 FOR UPDATE SKIP LOCKED`,
+		now, limit,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find scheduled entries: %w", err)
+	}
+	type dueEntry struct{ id, contentTypeID int }
+	var entries []dueEntry
+	for rows.Next() {
+		var e dueEntry
+		if err := rows.Scan(&e.id, &e.contentTypeID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan scheduled entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to find scheduled entries: %w", err)
+	}
+
+	workflows := map[int]*Workflow{}
+	for _, e := range entries {
+		w, ok := workflows[e.contentTypeID]
+		if !ok {
+			if w, err = getWorkflow(tx, e.contentTypeID); err != nil {
+				return 0, err
+			}
+			workflows[e.contentTypeID] = w
+		}
+
+		locked, err := lockEntry(tx, e.id)
+		if err != nil {
+			return 0, err
+		}
+
+		// Unpublishing an entry that is not published only clears its schedule
+		if status == StatusPublished || locked.Status == StatusPublished {
+			transition, err := dueTransition(tx, e.id, w, locked.Stage, status)
+			if err != nil {
+				return 0, err
+			}
+			if transition != nil {
+				update := EntryUpdate{Status: status, Message: message, Transition: transition}
+				if _, err := applyUpdate(tx, e.id, locked, update, now); err != nil {
+					return 0, err
+				}
+			} else if locked.Stage != nil {
+				reason := fmt.Sprintf("%s cancelled: no transition from %q to a %s stage is allowed", message, *locked.Stage, status)
+				if err := insertStageChange(tx, e.id, locked.Stage, *locked.Stage, nil, reason, now); err != nil {
+					return 0, err
+				}
+			}
+		}
+
+		if _, err := tx.Exec(`UPDATE content_entries SET `+column+` = NULL, version = version + 1 WHERE id = $1`, e.id); err != nil {
+			return 0, fmt.Errorf("failed to clear schedule: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(entries), nil
+}
+
+// dueTransition returns the first transition out of stage into a stage with
+// status whose approvals are met, or nil
+func dueTransition(tx *sql.Tx, entryID int, w *Workflow, stage *string, status string) (*WorkflowTransition, error) {
+	if w == nil {
+		return nil, nil
+	}
+	for _, t := range w.TransitionsFrom(stage) {
+		if target := w.Stage(t.To); target == nil || target.Status != status {
+			continue
+		}
+		if t.RequiredApprovals > 0 {
+			approvals, err := countApprovals(tx, entryID, t.From)
+			if err != nil {
+				return nil, err
+			}
+			if approvals < t.RequiredApprovals {
+				continue
+			}
+		}
+		t := t
+		return &t, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Snippets mark matches with control characters, which are removed from the
+// text beforehand, and are escaped before the markers become <mark> tags, so
+// that entry text can never inject HTML
+const (
+	snippetStart = "\x02"
+	snippetStop  = "\x03"
+)
+
+// searchHeadlineOptions shape the highlighted snippets of search results
+const searchHeadlineOptions = `StartSel="` + snippetStart + `", StopSel="` + snippetStop + `", ` +
+	`MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=" … "`
+
+// snippetMarks turns the markers of an escaped snippet into <mark> tags
+var snippetMarks = strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>")
+
+// highlightSnippet returns a ts_headline snippet as HTML
+func highlightSnippet(snippet string) string {
+	return snippetMarks.Replace(html.EscapeString(snippet))
+}
+
+// qualifiedEntryColumns are entryColumns of the content_entries table aliased as e
+var qualifiedEntryColumns = "e." + strings.ReplaceAll(entryColumns, ", ", ", e.")
+
+// SearchQuery selects the published entries a full-text search matches. Text
+// uses web search syntax: quoted phrases, OR and -excluded words.
+type SearchQuery struct {
+	Text string
+	// ContentTypeIDs limits the search to some content types; empty searches all
+	ContentTypeIDs []int
+	// Config limits the search to content types indexed with one text search
+	// configuration; empty searches all
+	Config string
+}
+
+// SearchResult is an entry matched by a search with its rank and a snippet of
+// its searchable text as HTML: the text is escaped and matches are wrapped in
+// <mark> tags
+type SearchResult struct {
+	Entry   ContentEntry
+	Rank    float64
+	Snippet string
+}
+
+// sql returns the common table expression queries, which parses the search
+// text once for each text search configuration of the searched content types,
+// and the FROM and WHERE clauses matching entries against it, with their
+// arguments. Matching each entry against a tsquery computed from its joined
+// content type would keep the planner from using the GIN index on
+// search_vector.
+func (q SearchQuery) sql() (string, string, []interface{}) {
+	types := "TRUE"
+	args := []interface{}{q.Text}
+	if len(q.ContentTypeIDs) > 0 {
+		args = append(args, pq.Array(q.ContentTypeIDs))
+		types += fmt.Sprintf(" AND t.id = ANY($%d::integer[])", len(args))
+	}
+	if q.Config != "" {
+		args = append(args, q.Config)
+		types += fmt.Sprintf(" AND t.search_config = $%d::regconfig", len(args))
+	}
+
+	queries := fmt.Sprintf(
+		`queries AS ( 
+			SELECT c.config, websearch_to_tsquery(c.config, $1) AS query 
+			FROM (SELECT DISTINCT t.search_config AS config FROM content_types t WHERE %s) c 
+		 )`,
+		types,
+	)
+	from := fmt.Sprintf(
+		`queries q 
+		 JOIN content_types t ON t.search_config = q.config 
+		 JOIN content_entries e ON e.content_type_id = t.id 
+		 WHERE %s AND e.status = 'published' AND e.search_vector @@ q.query`,
+		types,
+	)
+	return queries, from, args
+}
+
+// SearchContent returns a page of the published entries matching a search,
+// best matches first, and the total number of matches
+func SearchContent(db *sql.DB, q SearchQuery, limit, offset int) ([]SearchResult, int, error) {
+	queries, from, args := q.sql()
+
+	var total int
+	err := db.QueryRow(
+		`WITH `+queries+` 
+		 SELECT COUNT(*) FROM `+from,
+		args...,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	// Snippets are only built for the entries on the page
+	rows, err := db.Query(fmt.Sprintf(
+		`WITH %s, 
+		 matches AS ( 
+			SELECT e.id, q.query, ts_rank(e.search_vector, q.query) AS rank 
+			FROM %s 
+			ORDER BY rank DESC, e.id DESC LIMIT $%d OFFSET $%d 
+		 ) 
+		 SELECT %s, m.rank, 
+		        COALESCE(ts_headline(t.search_config, translate(gofrik_search_text(t.search_fields, e.published_data), $%d, ''), 
+		                            m.query, $%d), '') 
+		 FROM matches m 
+		 JOIN content_entries e ON e.id = m.id 
+		 JOIN content_types t ON t.id = e.content_type_id 
+		 ORDER BY m.rank DESC, e.id DESC`,
+		queries, from, len(args)+1, len(args)+2, qualifiedEntryColumns, len(args)+3, len(args)+4,
+	), append(args, limit, offset, snippetStart+snippetStop, searchHeadlineOptions)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search content: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		entry, err := scanEntry(withColumns{rows, []interface{}{&result.Rank, &result.Snippet}})
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		result.Entry = *entry
+		result.Snippet = highlightSnippet(result.Snippet)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to search content: %w", err)
+	}
+
+	return results, total, nil
+}
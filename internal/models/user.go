@@ -8,27 +8,46 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Built-in roles; custom roles can be added at runtime (see role.go)
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleAuthor = "author"
+	RoleViewer = "viewer"
+)
+
 type User struct {
 	ID           int       `json:"id"`
 	Email        string    `json:"email"`
 	PasswordHash string    `json:"-"`
+	Role         string    `json:"role"`
+	Active       bool      `json:"active"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
-func CreateUser(db *sql.DB, email, password string) (*User, error) {
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func CreateUser(db *sql.DB, email, password, role string) (*User, error) {
+	return createUser(db, email, password, role)
+}
+
+func createUser(q queryRower, email, password, role string) (*User, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, fmt.Errorf("failed to hash password: %w", err)
 	}
 
 	var user User
-	err = db.QueryRow(
-		`INSERT INTO users (email, password_hash) 
-		 VALUES ($1, $2) 
-		 RETURNING id, email, created_at, updated_at`,
-		email, string(hash),
-	).Scan(&user.ID, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+	err = q.QueryRow(
+		`INSERT INTO users (email, password_hash, role) 
+		 VALUES ($1, $2, $3) 
+		 RETURNING id, email, role, active, created_at, updated_at`,
+		email, string(hash), role,
+	).Scan(&user.ID, &user.Email, &user.Role, &user.Active, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
@@ -40,10 +59,10 @@ func CreateUser(db *sql.DB, email, password string) (*User, error) {
 func GetUserByEmail(db *sql.DB, email string) (*User, error) {
 	var user User
 	err := db.QueryRow(
-		`SELECT id, email, password_hash, created_at, updated_at 
+		`SELECT id, email, password_hash, role, active, created_at, updated_at 
 		 FROM users WHERE email = $1`,
 		email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Role, &user.Active, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -69,3 +88,60 @@ func CountUsers(db *sql.DB) (int, error) {
 	return count, nil
 }
 
+func GetUserByID(db *sql.DB, id int) (*User, error) {
+	var user User
+	err := db.QueryRow(
+		`SELECT id, email, password_hash, role, active, created_at, updated_at 
+		 FROM users WHERE id = $1`,
+		id,
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Role, &user.Active, &user.CreatedAt, &user.UpdatedAt)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// ListUsers returns every user ordered by ID
+func ListUsers(db *sql.DB) ([]User, error) {
+	rows, err := db.Query(
+		`SELECT id, email, role, active, created_at, updated_at 
+		 FROM users ORDER BY id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Email, &user.Role, &user.Active, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// SetUserActive activates or deactivates a user; deactivated users cannot log in
+func SetUserActive(db *sql.DB, id int, active bool) error {
+	result, err := db.Exec(
+		`UPDATE users 
+		 SET active = $1, updated_at = CURRENT_TIMESTAMP 
+		 WHERE id = $2`,
+		active, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
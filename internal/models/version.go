@@ -0,0 +1,22 @@
+package models
+
+import "fmt"
+
+// VersionConflictError is returned when a write expected a different version
+// than the stored one, i.e. someone else saved in the meantime
+type VersionConflictError struct {
+	Expected int
+	Actual   int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict: expected version %d, current version is %d", e.Expected, e.Actual)
+}
+
+// checkVersion returns a VersionConflictError if expected is set and differs from actual
+func checkVersion(expected *int, actual int) error {
+	if expected != nil && *expected != actual {
+		return &VersionConflictError{Expected: *expected, Actual: actual}
+	}
+	return nil
+}
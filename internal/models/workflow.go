@@ -0,0 +1,451 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// WorkflowStage is a step entries of a content type move through. Entering a
+// stage with a Status gives the entry that status: published publishes the
+// draft, draft unpublishes and archived archives. Stages without a Status
+// leave the entry's status alone, so a published entry can be reworked and
+// reviewed while its published snapshot stays live.
+type WorkflowStage struct {
+	Name   string `json:"name"`
+	Status string `json:"status,omitempty"`
+}
+
+// WorkflowTransition allows moving entries from one stage to another
+type WorkflowTransition struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Roles lists the roles that may make the transition; admins always can.
+	// An empty list allows every caller with the underlying permission.
+	Roles []string `json:"roles"`
+	// RequiredApprovals is how many reviewers must approve the entry's latest
+	// revision in the From stage first
+	RequiredApprovals int `json:"requiredApprovals"`
+}
+
+// AllowsRole reports whether users with role may make the transition
+func (t *WorkflowTransition) AllowsRole(role string) bool {
+	if role == RoleAdmin || len(t.Roles) == 0 {
+		return true
+	}
+	for _, r := range t.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Workflow is the editorial workflow of a content type. New entries start in
+// the first stage and can only move along the listed transitions.
+type Workflow struct {
+	ContentTypeID int                  `json:"content_type_id"`
+	Stages        []WorkflowStage      `json:"stages"`
+	Transitions   []WorkflowTransition `json:"transitions"`
+	UpdatedAt     time.Time            `json:"updated_at"`
+}
+
+// WorkflowError is returned when a workflow does not allow a change
+type WorkflowError struct {
+	Reason string
+}
+
+func (e *WorkflowError) Error() string {
+	return "workflow: " + e.Reason
+}
+
+// Validate checks that stages are unique and that transitions connect them
+func (w *Workflow) Validate() error {
+	if len(w.Stages) == 0 {
+		return fmt.Errorf("a workflow needs at least one stage")
+	}
+
+	seen := map[string]bool{}
+	for _, stage := range w.Stages {
+		if stage.Name == "" {
+			return fmt.Errorf("stage names cannot be empty")
+		}
+		if len(stage.Name) > 50 {
+			return fmt.Errorf("stage name %q is too long", stage.Name)
+		}
+		if seen[stage.Name] {
+			return fmt.Errorf("duplicate stage %q", stage.Name)
+		}
+		if stage.Status != "" && !IsValidStatus(stage.Status) {
+			return fmt.Errorf("invalid status %q for stage %q", stage.Status, stage.Name)
+		}
+		seen[stage.Name] = true
+	}
+	if status := w.Stages[0].Status; status != "" && status != StatusDraft {
+		return fmt.Errorf("the first stage cannot have status %q, new entries are drafts", status)
+	}
+
+	pairs := map[[2]string]bool{}
+	for _, t := range w.Transitions {
+		if !seen[t.From] {
+			return fmt.Errorf("transition from unknown stage %q", t.From)
+		}
+		if !seen[t.To] {
+			return fmt.Errorf("transition to unknown stage %q", t.To)
+		}
+		if t.From == t.To {
+			return fmt.Errorf("transition from %q to itself", t.From)
+		}
+		if t.RequiredApprovals < 0 {
+			return fmt.Errorf("requiredApprovals cannot be negative")
+		}
+		if pairs[[2]string{t.From, t.To}] {
+			return fmt.Errorf("duplicate transition from %q to %q", t.From, t.To)
+		}
+		pairs[[2]string{t.From, t.To}] = true
+	}
+	return nil
+}
+
+// Stage returns the stage with the given name, or nil
+func (w *Workflow) Stage(name string) *WorkflowStage {
+	for i := range w.Stages {
+		if w.Stages[i].Name == name {
+			return &w.Stages[i]
+		}
+	}
+	return nil
+}
+
+// TransitionsFrom returns the transitions out of a stage
+func (w *Workflow) TransitionsFrom(stage *string) []WorkflowTransition {
+	var transitions []WorkflowTransition
+	if stage == nil {
+		return transitions
+	}
+	for _, t := range w.Transitions {
+		if t.From == *stage {
+			transitions = append(transitions, t)
+		}
+	}
+	return transitions
+}
+
+// Transition returns the transition between two stages, or nil
+func (w *Workflow) Transition(from *string, to string) *WorkflowTransition {
+	for _, t := range w.TransitionsFrom(from) {
+		if t.To == to {
+			return &t
+		}
+	}
+	return nil
+}
+
+// stageFor returns the stage existing entries with a status are placed in when
+// a workflow is set: the first stage with that status, or else the first stage
+func (w *Workflow) stageFor(status string) string {
+	for _, stage := range w.Stages {
+		if stage.Status == status {
+			return stage.Name
+		}
+	}
+	return w.Stages[0].Name
+}
+
+// GetWorkflow returns the workflow of a content type, or nil if it has none
+func GetWorkflow(db *sql.DB, contentTypeID int) (*Workflow, error) {
+	return getWorkflow(db, contentTypeID)
+}
+
+func getWorkflow(q queryRower, contentTypeID int) (*Workflow, error) {
+	var stages, transitions []byte
+	w := Workflow{ContentTypeID: contentTypeID}
+	err := q.QueryRow(
+		`SELECT stages, transitions, updated_at FROM workflows WHERE content_type_id = $1`,
+		contentTypeID,
+	).Scan(&stages, &transitions, &w.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+
+	if err := json.Unmarshal(stages, &w.Stages); err != nil {
+		return nil, fmt.Errorf("invalid workflow stages: %w", err)
+	}
+	if err := json.Unmarshal(transitions, &w.Transitions); err != nil {
+		return nil, fmt.Errorf("invalid workflow transitions: %w", err)
+	}
+	return &w, nil
+}
+
+// SetWorkflow creates or replaces the workflow of a content type. Entries
+// without a stage of the new workflow are moved to the first stage matching
+// their status, and the move is recorded in their stage history.
+func SetWorkflow(db *sql.DB, w *Workflow, actorID *int, now time.Time) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	if w.Transitions == nil {
+		w.Transitions = []WorkflowTransition{}
+	}
+	stages, err := json.Marshal(w.Stages)
+	if err != nil {
+		return fmt.Errorf("failed to encode workflow stages: %w", err)
+	}
+	transitions, err := json.Marshal(w.Transitions)
+	if err != nil {
+		return fmt.Errorf("failed to encode workflow transitions: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO workflows (content_type_id, stages, transitions, updated_at) 
+		 VALUES ($1, $2, $3, $4) 
+		 ON CONFLICT (content_type_id) DO UPDATE 
+		 SET stages = EXCLUDED.stages, transitions = EXCLUDED.transitions, updated_at = EXCLUDED.updated_at`,
+		w.ContentTypeID, stages, transitions, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save workflow: %w", err)
+	}
+
+	names := make([]string, len(w.Stages))
+	for i, stage := range w.Stages {
+		names[i] = stage.Name
+	}
+	_, err = tx.Exec(
+		`WITH moved AS ( 
+			SELECT id, stage FROM content_entries 
+			WHERE content_type_id = $1 AND (stage IS NULL OR NOT (stage = ANY($2))) 
+			FOR UPDATE 
+		 ), updated AS ( 
+			UPDATE content_entries e 
+			SET stage = CASE WHEN e.status = $3 THEN $4 WHEN e.status = $5 THEN $6 ELSE $7 END, 
+			    version = e.version + 1 
+			FROM moved WHERE e.id = moved.id 
+			RETURNING e.id, moved.stage AS from_stage, e.stage AS to_stage 
+		 ) 
+		 INSERT INTO content_stage_history (entry_id, from_stage, to_stage, actor_id, comment, created_at) 
+		 SELECT id, from_stage, to_stage, $8::integer, $9::text, $10::timestamp FROM updated`,
+		w.ContentTypeID, pq.Array(names),
+		StatusPublished, w.stageFor(StatusPublished),
+		StatusArchived, w.stageFor(StatusArchived),
+		w.stageFor(StatusDraft),
+		actorID, "Workflow changed", now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to move entries into the workflow: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	w.UpdatedAt = now
+	return nil
+}
+
+// DeleteWorkflow removes the workflow of a content type; its entries go back
+// to the default status transitions
+func DeleteWorkflow(db *sql.DB, contentTypeID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM workflows WHERE content_type_id = $1`, contentTypeID)
+	if err != nil {
+		return fmt.Errorf("failed to delete workflow: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("workflow not found")
+	}
+
+	_, err = tx.Exec(
+		`UPDATE content_entries SET stage = NULL, version = version + 1 
+		 WHERE content_type_id = $1 AND stage IS NOT NULL`,
+		contentTypeID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to clear entry stages: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// StageChange records an entry moving between workflow stages
+type StageChange struct {
+	ID        int       `json:"id"`
+	EntryID   int       `json:"entry_id"`
+	FromStage *string   `json:"from_stage"`
+	ToStage   string    `json:"to_stage"`
+	ActorID   *int      `json:"actor_id"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func insertStageChange(tx *sql.Tx, entryID int, from *string, to string, actorID *int, comment string, now time.Time) error {
+	_, err := tx.Exec(
+		`INSERT INTO content_stage_history (entry_id, from_stage, to_stage, actor_id, comment, created_at) 
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		entryID, from, to, actorID, comment, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record stage change: %w", err)
+	}
+	return nil
+}
+
+// ListStageChanges returns the stage history of an entry, newest first
+func ListStageChanges(db *sql.DB, entryID int) ([]StageChange, error) {
+	rows, err := db.Query(
+		`SELECT id, entry_id, from_stage, to_stage, actor_id, comment, created_at 
+		 FROM content_stage_history WHERE entry_id = $1 ORDER BY created_at DESC, id DESC`,
+		entryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stage history: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []StageChange
+	for rows.Next() {
+		var c StageChange
+		if err := rows.Scan(&c.ID, &c.EntryID, &c.FromStage, &c.ToStage, &c.ActorID, &c.Comment, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan stage change: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// ContentReview is a reviewer's verdict on an entry's revision in a stage
+type ContentReview struct {
+	ID         int       `json:"id"`
+	EntryID    int       `json:"entry_id"`
+	Revision   int       `json:"revision"`
+	Stage      string    `json:"stage"`
+	ReviewerID *int      `json:"reviewer_id"`
+	Approved   bool      `json:"approved"`
+	Comment    string    `json:"comment"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const reviewColumns = `id, entry_id, revision, stage, reviewer_id, approved, comment, created_at`
+
+func scanReview(row interface{ Scan(...interface{}) error }) (*ContentReview, error) {
+	var r ContentReview
+	err := row.Scan(&r.ID, &r.EntryID, &r.Revision, &r.Stage, &r.ReviewerID, &r.Approved, &r.Comment, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// latestRevisionSQL selects the newest revision number of entry $1
+const latestRevisionSQL = `(SELECT COALESCE(MAX(revision), 0) FROM content_revisions WHERE entry_id = $1)`
+
+// latestAuthorSQL selects the author of the newest revision of entry $1
+const latestAuthorSQL = `(SELECT author_id FROM content_revisions WHERE entry_id = $1 ORDER BY revision DESC LIMIT 1)`
+
+// CreateContentReview records a review of an entry's latest revision. The
+// entry must still be in stage, so a review cannot land on a stage the
+// reviewer did not see, and the reviewer cannot be the revision's author.
+func CreateContentReview(db *sql.DB, entryID int, stage string, reviewerID *int, approved bool, comment string, now time.Time) (*ContentReview, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	locked, err := lockEntry(tx, entryID)
+	if err != nil {
+		return nil, err
+	}
+	if locked.Stage == nil || *locked.Stage != stage {
+		return nil, &WorkflowError{Reason: fmt.Sprintf("the entry is not in stage %q", stage)}
+	}
+
+	if reviewerID == nil {
+		return nil, fmt.Errorf("reviews need a reviewer")
+	}
+	var author *int
+	if err := tx.QueryRow(`SELECT `+latestAuthorSQL, entryID).Scan(&author); err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get revision author: %w", err)
+	}
+	if author != nil && *author == *reviewerID {
+		return nil, &WorkflowError{Reason: "the author of a revision cannot review it"}
+	}
+
+	review, err := scanReview(tx.QueryRow(
+		`INSERT INTO content_reviews (entry_id, revision, stage, reviewer_id, approved, comment, created_at) 
+		 VALUES ($1, `+latestRevisionSQL+`, $2, $3, $4, $5, $6) 
+		 RETURNING `+reviewColumns,
+		entryID, stage, reviewerID, approved, comment, now,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create review: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return review, nil
+}
+
+// ListContentReviews returns the reviews of an entry, newest first
+func ListContentReviews(db *sql.DB, entryID int) ([]ContentReview, error) {
+	rows, err := db.Query(
+		`SELECT `+reviewColumns+` FROM content_reviews 
+		 WHERE entry_id = $1 ORDER BY created_at DESC, id DESC`,
+		entryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reviews: %w", err)
+	}
+	defer rows.Close()
+
+	var reviews []ContentReview
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan review: %w", err)
+		}
+		reviews = append(reviews, *review)
+	}
+	return reviews, rows.Err()
+}
+
+// countApprovals counts the distinct reviewers whose latest review of the
+// entry's latest revision in stage approves it. Saving the entry creates a new
+// revision, so changes made after an approval need approving again. Reviews
+// by the revision's author and by deleted users do not count.
+func countApprovals(tx *sql.Tx, entryID int, stage string) (int, error) {
+	var count int
+	err := tx.QueryRow(
+		`SELECT COUNT(*) FROM ( 
+			SELECT DISTINCT ON (reviewer_id) approved FROM content_reviews 
+			WHERE entry_id = $1 AND stage = $2 AND revision = `+latestRevisionSQL+` 
+			AND reviewer_id IS NOT NULL AND reviewer_id IS DISTINCT FROM `+latestAuthorSQL+` 
+			ORDER BY reviewer_id, created_at DESC, id DESC 
+		 ) latest WHERE approved`,
+		entryID, stage,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count approvals: %w", err)
+	}
+	return count, nil
+}
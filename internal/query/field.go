@@ -0,0 +1,117 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"gofrik/internal/jsonschema"
+)
+
+// Kind is how a schema property is compared in SQL
+type Kind string
+
+const (
+	KindText     Kind = "text"
+	KindNumber   Kind = "number"
+	KindBoolean  Kind = "boolean"
+	KindDateTime Kind = "date-time"
+	KindArray    Kind = "array"
+	KindObject   Kind = "object"
+	KindAny      Kind = "untyped"
+)
+
+// maxPathSegments bounds how deep a property path may reach into a document
+const maxPathSegments = 8
+
+// Field is a schema property addressed by a dot-separated path
+type Field struct {
+	Path   []string
+	Schema *jsonschema.Schema
+	Kind   Kind
+}
+
+// ResolveField looks up a dot-separated property path in a content type
+// schema. Every segment must be a declared property.
+func ResolveField(schema *jsonschema.Schema, path string) (*Field, error) {
+	segments := strings.Split(path, ".")
+	if len(segments) > maxPathSegments {
+		return nil, fmt.Errorf("field %q is nested more than %d levels deep", path, maxPathSegments)
+	}
+
+	current := schema
+	for i, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid field path %q", path)
+		}
+		prop, ok := current.Properties[segment]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", strings.Join(segments[:i+1], "."))
+		}
+		current = prop
+	}
+
+	return &Field{Path: segments, Schema: current, Kind: kindOf(current)}, nil
+}
+
+// kindOf picks the comparison kind from a property's declared type
+func kindOf(schema *jsonschema.Schema) Kind {
+	for _, t := range schema.Types {
+		switch t {
+		case "string":
+			if schema.Format == "date-time" || schema.Format == "date" {
+				return KindDateTime
+			}
+			return KindText
+		case "number", "integer":
+			return KindNumber
+		case "boolean":
+			return KindBoolean
+		case "array":
+			return KindArray
+		case "object":
+			return KindObject
+		}
+	}
+	return KindAny
+}
+
+// Comparable reports whether the field's values have an order
+func (field *Field) Comparable() bool {
+	switch field.Kind {
+	case KindText, KindNumber, KindDateTime:
+		return true
+	}
+	return false
+}
+
+// Sortable reports whether the field can be sorted by
+func (field *Field) Sortable() bool {
+	return field.Comparable() || field.Kind == KindBoolean
+}
+
+// Expression returns an SQL expression for the field's value in the JSONB
+// column, converted for comparison and ordering, along with its SQL type.
+// Values that cannot be converted become NULL rather than failing the query.
+func (field *Field) Expression(column string, param func(interface{}) string) (string, string) {
+	path := field.pathSQL(param)
+	switch field.Kind {
+	case KindNumber:
+		return fmt.Sprintf("(CASE WHEN jsonb_typeof(%s #> %s) = 'number' THEN (%s #>> %s)::numeric END)", column, path, column, path), "numeric"
+	case KindDateTime:
+		return fmt.Sprintf("gofrik_try_timestamptz(%s #>> %s)", column, path), "timestamptz"
+	case KindBoolean:
+		return fmt.Sprintf("(CASE WHEN jsonb_typeof(%s #> %s) = 'boolean' THEN (%s #>> %s)::boolean END)", column, path, column, path), "boolean"
+	}
+	return fmt.Sprintf("(%s #>> %s)", column, path), "text"
+}
+
+// pathSQL adds the field's path as a text[] parameter
+func (field *Field) pathSQL(param func(interface{}) string) string {
+	return param(textArray(field.Path)) + "::text[]"
+}
+
+func textArray(segments []string) interface{} {
+	return pq.Array(segments)
+}
@@ -0,0 +1,372 @@
+// Package query compiles content filters into parameterized SQL over the JSONB
+// data of content entries.
+//
+// A Filter is a tree: leaves apply operators to one property path, inner nodes
+// combine children with AND, OR and NOT. Filters are validated against the
+// content type's JSON Schema before they are compiled, so only declared
+// properties can be queried and values must have the property's type. Paths
+// and values are always passed as parameters, never spliced into the SQL.
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gofrik/internal/jsonschema"
+)
+
+// Operators
+const (
+	OpEq         = "eq"
+	OpNe         = "ne"
+	OpIn         = "in"
+	OpNin        = "nin"
+	OpLt         = "lt"
+	OpLte        = "lte"
+	OpGt         = "gt"
+	OpGte        = "gte"
+	OpContains   = "contains"
+	OpStartsWith = "startsWith"
+	OpExists     = "exists"
+	OpIsNull     = "isNull"
+)
+
+// Operators lists every operator in a stable order
+var Operators = []string{OpEq, OpNe, OpIn, OpNin, OpLt, OpLte, OpGt, OpGte, OpContains, OpStartsWith, OpExists, OpIsNull}
+
+// Limits that keep filters cheap to validate and run
+const (
+	maxDepth    = 8
+	maxNodes    = 64
+	maxInValues = 100
+)
+
+// Filter is a node of a filter tree. All of its parts must match: the
+// conditions on Path, every filter in And, at least one filter in Or and
+// not Not.
+type Filter struct {
+	And []*Filter
+	Or  []*Filter
+	Not *Filter
+
+	// Path is a dot-separated property path such as "author.name"
+	Path       string
+	Conditions []Condition
+
+	// Set by Validate
+	field *Field
+}
+
+// Condition applies one operator to the value at a filter's path
+type Condition struct {
+	Op    string
+	Value interface{}
+}
+
+// Validate checks the filter against a content type schema. It must be called
+// before SQL.
+func (f *Filter) Validate(schema *jsonschema.Schema) error {
+	nodes := 0
+	return f.validate(schema, 0, &nodes)
+}
+
+func (f *Filter) validate(schema *jsonschema.Schema, depth int, nodes *int) error {
+	*nodes++
+	if depth > maxDepth {
+		return fmt.Errorf("filter is nested more than %d levels deep", maxDepth)
+	}
+	if *nodes > maxNodes {
+		return fmt.Errorf("filter has more than %d parts", maxNodes)
+	}
+
+	for _, child := range f.And {
+		if err := child.validate(schema, depth+1, nodes); err != nil {
+			return err
+		}
+	}
+	for _, child := range f.Or {
+		if err := child.validate(schema, depth+1, nodes); err != nil {
+			return err
+		}
+	}
+	if f.Not != nil {
+		if err := f.Not.validate(schema, depth+1, nodes); err != nil {
+			return err
+		}
+	}
+
+	if f.Path == "" {
+		if len(f.Conditions) > 0 {
+			return fmt.Errorf("filter conditions need a path")
+		}
+		return nil
+	}
+	if len(f.Conditions) == 0 {
+		return fmt.Errorf("filter on %q has no conditions", f.Path)
+	}
+
+	field, err := ResolveField(schema, f.Path)
+	if err != nil {
+		return err
+	}
+	for _, c := range f.Conditions {
+		if err := field.checkCondition(c); err != nil {
+			return fmt.Errorf("%s on %q: %w", c.Op, f.Path, err)
+		}
+	}
+	f.field = field
+	return nil
+}
+
+// SQL compiles a validated filter into a condition on the JSONB column.
+// Parameters are numbered after args, which is returned with the filter's
+// values appended.
+func (f *Filter) SQL(column string, args []interface{}) (string, []interface{}, error) {
+	var parts []string
+
+	if f.Path != "" {
+		if f.field == nil {
+			return "", nil, fmt.Errorf("filter on %q was not validated", f.Path)
+		}
+		for _, c := range f.Conditions {
+			part, newArgs := f.field.conditionSQL(column, c, args)
+			args = newArgs
+			parts = append(parts, part)
+		}
+	}
+
+	for _, child := range f.And {
+		part, newArgs, err := child.SQL(column, args)
+		if err != nil {
+			return "", nil, err
+		}
+		args = newArgs
+		parts = append(parts, part)
+	}
+
+	if len(f.Or) > 0 {
+		var alternatives []string
+		for _, child := range f.Or {
+			part, newArgs, err := child.SQL(column, args)
+			if err != nil {
+				return "", nil, err
+			}
+			args = newArgs
+			alternatives = append(alternatives, part)
+		}
+		parts = append(parts, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	if f.Not != nil {
+		part, newArgs, err := f.Not.SQL(column, args)
+		if err != nil {
+			return "", nil, err
+		}
+		args = newArgs
+		parts = append(parts, "NOT "+part)
+	}
+
+	if len(parts) == 0 {
+		return "TRUE", args, nil
+	}
+	return "(" + strings.Join(parts, " AND ") + ")", args, nil
+}
+
+// checkCondition checks that an operator applies to the field and that its
+// value has the right type
+func (field *Field) checkCondition(c Condition) error {
+	switch c.Op {
+	case OpEq, OpNe:
+		return field.checkValue(c.Value)
+
+	case OpIn, OpNin:
+		values, ok := c.Value.([]interface{})
+		if !ok {
+			return fmt.Errorf("value must be a list")
+		}
+		if len(values) > maxInValues {
+			return fmt.Errorf("at most %d values are allowed", maxInValues)
+		}
+		for _, v := range values {
+			if err := field.checkValue(v); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case OpLt, OpLte, OpGt, OpGte:
+		if !field.Comparable() {
+			return fmt.Errorf("not supported for %s values", field.Kind)
+		}
+		// Bounds only need the right type, not every constraint of the field
+		if c.Value == nil {
+			return fmt.Errorf("value cannot be null")
+		}
+		return checkAgainst(&jsonschema.Schema{Types: field.Schema.Types, Format: field.Schema.Format}, c.Value)
+
+	case OpContains:
+		switch field.Kind {
+		case KindText, KindDateTime:
+			if _, ok := c.Value.(string); !ok {
+				return fmt.Errorf("value must be a string")
+			}
+			return nil
+		case KindArray:
+			if field.Schema.Items == nil {
+				return nil
+			}
+			if values, ok := c.Value.([]interface{}); ok {
+				for _, v := range values {
+					if err := checkAgainst(field.Schema.Items, v); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			return checkAgainst(field.Schema.Items, c.Value)
+		case KindObject:
+			if _, ok := c.Value.(map[string]interface{}); !ok {
+				return fmt.Errorf("value must be an object")
+			}
+			return nil
+		}
+		return fmt.Errorf("not supported for %s values", field.Kind)
+
+	case OpStartsWith:
+		if field.Kind != KindText && field.Kind != KindDateTime {
+			return fmt.Errorf("not supported for %s values", field.Kind)
+		}
+		if _, ok := c.Value.(string); !ok {
+			return fmt.Errorf("value must be a string")
+		}
+		return nil
+
+	case OpExists, OpIsNull:
+		if _, ok := c.Value.(bool); !ok {
+			return fmt.Errorf("value must be a boolean")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operator")
+}
+
+// checkValue validates a comparison value against the field's schema
+func (field *Field) checkValue(value interface{}) error {
+	if value == nil {
+		return fmt.Errorf("value cannot be null, use isNull")
+	}
+	return checkAgainst(field.Schema, value)
+}
+
+func checkAgainst(schema *jsonschema.Schema, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	violations, err := schema.Validate(raw)
+	if err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("invalid value: %s", violations[0].Message)
+	}
+	return nil
+}
+
+// conditionSQL compiles one validated condition
+func (field *Field) conditionSQL(column string, c Condition, args []interface{}) (string, []interface{}) {
+	param := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	switch c.Op {
+	case OpEq, OpNe:
+		sql := field.equalSQL(column, c.Value, param)
+		if c.Op == OpNe {
+			sql = "NOT " + sql
+		}
+		return sql, args
+
+	case OpIn, OpNin:
+		values, _ := c.Value.([]interface{})
+		alternatives := make([]string, 0, len(values))
+		for _, v := range values {
+			alternatives = append(alternatives, field.equalSQL(column, v, param))
+		}
+		sql := "FALSE"
+		if len(alternatives) > 0 {
+			sql = "(" + strings.Join(alternatives, " OR ") + ")"
+		}
+		if c.Op == OpNin {
+			sql = "NOT " + sql
+		}
+		return sql, args
+
+	case OpLt, OpLte, OpGt, OpGte:
+		operators := map[string]string{OpLt: "<", OpLte: "<=", OpGt: ">", OpGte: ">="}
+		value, cast := field.Expression(column, param)
+		return fmt.Sprintf("%s %s %s::%s", value, operators[c.Op], param(scalarParam(c.Value)), cast), args
+
+	case OpContains:
+		if field.Kind == KindArray {
+			value := c.Value
+			if _, ok := value.([]interface{}); !ok {
+				value = []interface{}{value}
+			}
+			return fmt.Sprintf("COALESCE(%s #> %s @> %s::jsonb, FALSE)", column, field.pathSQL(param), param(jsonParam(value))), args
+		}
+		if field.Kind == KindObject {
+			return fmt.Sprintf("COALESCE(%s #> %s @> %s::jsonb, FALSE)", column, field.pathSQL(param), param(jsonParam(c.Value))), args
+		}
+		return fmt.Sprintf("COALESCE(strpos(%s #>> %s, %s::text) > 0, FALSE)", column, field.pathSQL(param), param(c.Value)), args
+
+	case OpStartsWith:
+		return fmt.Sprintf("COALESCE(starts_with(%s #>> %s, %s::text), FALSE)", column, field.pathSQL(param), param(c.Value)), args
+
+	case OpExists:
+		parent, key := field.Path[:len(field.Path)-1], field.Path[len(field.Path)-1]
+		sql := fmt.Sprintf("COALESCE(%s #> %s ? %s::text, FALSE)", column, param(textArray(parent))+"::text[]", param(key))
+		if exists, _ := c.Value.(bool); !exists {
+			sql = "NOT " + sql
+		}
+		return sql, args
+
+	case OpIsNull:
+		sql := fmt.Sprintf("(COALESCE(jsonb_typeof(%s #> %s), 'null') = 'null')", column, field.pathSQL(param))
+		if isNull, _ := c.Value.(bool); !isNull {
+			sql = "NOT " + sql
+		}
+		return sql, args
+	}
+	return "FALSE", args
+}
+
+// equalSQL matches the value at the field's path. Scalars use JSONB
+// containment, which the GIN index on the column can answer.
+func (field *Field) equalSQL(column string, value interface{}, param func(interface{}) string) string {
+	if field.Kind == KindArray || field.Kind == KindObject {
+		return fmt.Sprintf("COALESCE(%s #> %s = %s::jsonb, FALSE)", column, field.pathSQL(param), param(jsonParam(value)))
+	}
+
+	var doc interface{} = value
+	for i := len(field.Path) - 1; i >= 0; i-- {
+		doc = map[string]interface{}{field.Path[i]: doc}
+	}
+	return fmt.Sprintf("%s @> %s::jsonb", column, param(jsonParam(doc)))
+}
+
+// jsonParam encodes a value as a JSONB parameter
+func jsonParam(value interface{}) string {
+	raw, _ := json.Marshal(value)
+	return string(raw)
+}
+
+// scalarParam passes strings as they are and encodes other values as JSON text
+func scalarParam(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return jsonParam(value)
+}
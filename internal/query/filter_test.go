@@ -0,0 +1,185 @@
+package query
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gofrik/internal/jsonschema"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"title": {"type": "string"},
+		"views": {"type": "integer"},
+		"featured": {"type": "boolean"},
+		"tags": {"type": "array", "items": {"type": "string"}},
+		"author": {"type": "object", "properties": {"name": {"type": "string"}}}
+	}
+}`
+
+func parseTestSchema(t *testing.T) *jsonschema.Schema {
+	t.Helper()
+	schema, err := jsonschema.Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatalf("parse schema: %v", err)
+	}
+	return schema
+}
+
+// sqlArgs converts driver values, such as path arrays, to what PostgreSQL receives
+func sqlArgs(t *testing.T, args []interface{}) []interface{} {
+	t.Helper()
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if valuer, ok := arg.(driver.Valuer); ok {
+			value, err := valuer.Value()
+			if err != nil {
+				t.Fatalf("arg %d: %v", i, err)
+			}
+			arg = value
+		}
+		converted[i] = arg
+	}
+	return converted
+}
+
+const viewsExpr = "(CASE WHEN jsonb_typeof(data #> $%d::text[]) = 'number' THEN (data #>> $%d::text[])::numeric END)"
+
+func TestFilterSQL(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   *Filter
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "empty filter matches everything",
+			filter:   &Filter{},
+			wantSQL:  "TRUE",
+			wantArgs: []interface{}{"existing"},
+		},
+		{
+			name:     "eq uses containment",
+			filter:   &Filter{Path: "title", Conditions: []Condition{{Op: OpEq, Value: "Hello"}}},
+			wantSQL:  "(data @> $2::jsonb)",
+			wantArgs: []interface{}{"existing", `{"title":"Hello"}`},
+		},
+		{
+			name:     "ne negates containment",
+			filter:   &Filter{Path: "featured", Conditions: []Condition{{Op: OpNe, Value: true}}},
+			wantSQL:  "(NOT data @> $2::jsonb)",
+			wantArgs: []interface{}{"existing", `{"featured":true}`},
+		},
+		{
+			name:     "nested eq",
+			filter:   &Filter{Path: "author.name", Conditions: []Condition{{Op: OpIn, Value: []interface{}{"Ann", "Bob"}}}},
+			wantSQL:  "((data @> $2::jsonb OR data @> $3::jsonb))",
+			wantArgs: []interface{}{"existing", `{"author":{"name":"Ann"}}`, `{"author":{"name":"Bob"}}`},
+		},
+		{
+			name:     "empty in matches nothing",
+			filter:   &Filter{Path: "title", Conditions: []Condition{{Op: OpIn, Value: []interface{}{}}}},
+			wantSQL:  "(FALSE)",
+			wantArgs: []interface{}{"existing"},
+		},
+		{
+			name:   "range on a number",
+			filter: &Filter{Path: "views", Conditions: []Condition{{Op: OpGte, Value: 10.0}, {Op: OpLt, Value: 100.0}}},
+			wantSQL: "(" + fmt.Sprintf(viewsExpr, 2, 2) + " >= $3::numeric AND " +
+				fmt.Sprintf(viewsExpr, 4, 4) + " < $5::numeric)",
+			wantArgs: []interface{}{"existing", `{"views"}`, "10", `{"views"}`, "100"},
+		},
+		{
+			name:     "contains on an array",
+			filter:   &Filter{Path: "tags", Conditions: []Condition{{Op: OpContains, Value: "go"}}},
+			wantSQL:  "(COALESCE(data #> $2::text[] @> $3::jsonb, FALSE))",
+			wantArgs: []interface{}{"existing", `{"tags"}`, `["go"]`},
+		},
+		{
+			name:     "contains on text",
+			filter:   &Filter{Path: "title", Conditions: []Condition{{Op: OpContains, Value: "ell"}}},
+			wantSQL:  "(COALESCE(strpos(data #>> $2::text[], $3::text) > 0, FALSE))",
+			wantArgs: []interface{}{"existing", `{"title"}`, "ell"},
+		},
+		{
+			name:     "exists false",
+			filter:   &Filter{Path: "author.name", Conditions: []Condition{{Op: OpExists, Value: false}}},
+			wantSQL:  "(NOT COALESCE(data #> $2::text[] ? $3::text, FALSE))",
+			wantArgs: []interface{}{"existing", `{"author"}`, "name"},
+		},
+		{
+			name: "or and not",
+			filter: &Filter{Or: []*Filter{
+				{Path: "title", Conditions: []Condition{{Op: OpStartsWith, Value: "A"}}},
+				{Not: &Filter{Path: "views", Conditions: []Condition{{Op: OpIsNull, Value: true}}}},
+			}},
+			wantSQL: "(((COALESCE(starts_with(data #>> $2::text[], $3::text), FALSE)) OR " +
+				"(NOT ((COALESCE(jsonb_typeof(data #> $4::text[]), 'null') = 'null')))))",
+			wantArgs: []interface{}{"existing", `{"title"}`, "A", `{"views"}`},
+		},
+	}
+
+	schema := parseTestSchema(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(schema); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			sql, args, err := tt.filter.SQL("data", []interface{}{"existing"})
+			if err != nil {
+				t.Fatalf("SQL: %v", err)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("SQL:\n got %s\nwant %s", sql, tt.wantSQL)
+			}
+			if got := sqlArgs(t, args); !reflect.DeepEqual(got, tt.wantArgs) {
+				t.Errorf("args:\n got %#v\nwant %#v", got, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	deep := &Filter{Path: "title", Conditions: []Condition{{Op: OpEq, Value: "x"}}}
+	for i := 0; i <= maxDepth; i++ {
+		deep = &Filter{Not: deep}
+	}
+
+	tests := []struct {
+		name    string
+		filter  *Filter
+		wantErr string
+	}{
+		{"unknown field", &Filter{Path: "missing", Conditions: []Condition{{Op: OpEq, Value: "x"}}}, `unknown field "missing"`},
+		{"unknown nested field", &Filter{Path: "author.email", Conditions: []Condition{{Op: OpEq, Value: "x"}}}, `unknown field "author.email"`},
+		{"wrong value type", &Filter{Path: "views", Conditions: []Condition{{Op: OpEq, Value: "many"}}}, "invalid value"},
+		{"null comparison", &Filter{Path: "title", Conditions: []Condition{{Op: OpEq, Value: nil}}}, "use isNull"},
+		{"range on a boolean", &Filter{Path: "featured", Conditions: []Condition{{Op: OpGt, Value: true}}}, "not supported for boolean values"},
+		{"in without a list", &Filter{Path: "title", Conditions: []Condition{{Op: OpIn, Value: "x"}}}, "value must be a list"},
+		{"unknown operator", &Filter{Path: "title", Conditions: []Condition{{Op: "like", Value: "x"}}}, "unknown operator"},
+		{"path without conditions", &Filter{Path: "title"}, "has no conditions"},
+		{"conditions without path", &Filter{Conditions: []Condition{{Op: OpEq, Value: "x"}}}, "need a path"},
+		{"too deep", deep, "nested more than"},
+	}
+
+	schema := parseTestSchema(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filter.Validate(schema)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterSQLRequiresValidate(t *testing.T) {
+	filter := &Filter{Path: "title", Conditions: []Condition{{Op: OpEq, Value: "x"}}}
+	if _, _, err := filter.SQL("data", nil); err == nil {
+		t.Fatal("SQL of an unvalidated filter succeeded")
+	}
+}
@@ -0,0 +1,289 @@
+package query
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Page sizes
+const (
+	DefaultPageSize = 10
+	MaxPageSize     = 100
+)
+
+// ErrInvalidCursor is returned for cursors that were not issued for the
+// current sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page selects a window of a sorted list the Relay way: the First rows after
+// the After cursor, or the Last rows before the Before cursor. Cursors hold
+// the sort key values of a row, so pages stay put when rows are added or
+// removed elsewhere in the list.
+type Page struct {
+	First  int
+	After  string
+	Last   int
+	Before string
+}
+
+// PageInfo describes where a page sits in its list
+type PageInfo struct {
+	HasNextPage     bool
+	HasPreviousPage bool
+	StartCursor     string
+	EndCursor       string
+}
+
+// Keyset is a page compiled into SQL over a list's sort keys. Select Columns
+// after the row's own columns, restrict rows with Condition, order them by
+// OrderBy and fetch Limit rows.
+type Keyset struct {
+	Columns   string
+	Condition string
+	OrderBy   string
+	Limit     int
+	Args      []interface{}
+
+	keys     []Sort
+	size     int
+	backward bool
+	after    bool
+	before   bool
+}
+
+// Compile turns the page into SQL over validated sort keys, whose last key
+// must be unique so every row has its own cursor. Parameters are numbered
+// after args.
+func (p Page) Compile(keys []Sort, dataColumn string, args []interface{}) (*Keyset, error) {
+	if p.First < 0 || p.Last < 0 {
+		return nil, fmt.Errorf("first and last cannot be negative")
+	}
+	if p.First > 0 && p.Last > 0 {
+		return nil, fmt.Errorf("first and last cannot be combined")
+	}
+	if p.First > MaxPageSize || p.Last > MaxPageSize {
+		return nil, fmt.Errorf("pages hold at most %d items", MaxPageSize)
+	}
+
+	k := &Keyset{keys: keys, size: p.First, after: p.After != "", before: p.Before != ""}
+	if p.Last > 0 {
+		k.size = p.Last
+		k.backward = true
+	}
+	if k.size == 0 {
+		k.size = DefaultPageSize
+	}
+
+	param := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	reversed := make([]Sort, len(keys))
+	for i := range keys {
+		reversed[i] = keys[i].reversed()
+	}
+
+	conditions := []string{}
+	if p.After != "" {
+		values, err := decodeCursor(keys, p.After)
+		if err != nil {
+			return nil, err
+		}
+		condition, err := afterCondition(keys, values, dataColumn, param)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if p.Before != "" {
+		values, err := decodeCursor(keys, p.Before)
+		if err != nil {
+			return nil, err
+		}
+		condition, err := afterCondition(reversed, values, dataColumn, param)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	k.Condition = "TRUE"
+	if len(conditions) > 0 {
+		k.Condition = strings.Join(conditions, " AND ")
+	}
+
+	// Backward pages are read from the end of the list and flipped afterwards
+	order := keys
+	if k.backward {
+		order = reversed
+	}
+	orderBy, args, err := OrderBy(order, dataColumn, args)
+	if err != nil {
+		return nil, err
+	}
+	k.OrderBy = orderBy
+
+	columns := make([]string, 0, len(keys))
+	for i := range keys {
+		expr, _, err := keys[i].Expression(dataColumn, param)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, "("+expr+")::text")
+	}
+	k.Columns = strings.Join(columns, ", ")
+
+	// One row more than the page tells whether there is another page
+	k.Limit = k.size + 1
+	k.Args = args
+	return k, nil
+}
+
+// afterCondition matches the rows that come after the cursor values in the
+// order of keys: rows equal on the first keys and after the cursor on the
+// next one. Every parameter it adds is used.
+func afterCondition(keys []Sort, values []*string, dataColumn string, param func(interface{}) string) (string, error) {
+	var alternatives []string
+	var equal []string
+	for i := range keys {
+		last := i == len(keys)-1
+		// Nothing sorts after a null placed last, except on later keys
+		nullLast := values[i] == nil && !keys[i].nullsFirst()
+		if nullLast && last {
+			break
+		}
+
+		expr, sqlType, err := keys[i].Expression(dataColumn, param)
+		if err != nil {
+			return "", err
+		}
+
+		if !nullLast {
+			var after string
+			if values[i] == nil {
+				after = expr + " IS NOT NULL"
+			} else {
+				op := ">"
+				if keys[i].Descending {
+					op = "<"
+				}
+				after = fmt.Sprintf("%s %s %s::%s", expr, op, param(*values[i]), sqlType)
+				if !keys[i].nullsFirst() {
+					after = "(" + after + " OR " + expr + " IS NULL)"
+				}
+			}
+			parts := append(append([]string{}, equal...), after)
+			alternatives = append(alternatives, "("+strings.Join(parts, " AND ")+")")
+		}
+
+		if !last {
+			if values[i] == nil {
+				equal = append(equal, expr+" IS NULL")
+			} else {
+				equal = append(equal, fmt.Sprintf("%s = %s::%s", expr, param(*values[i]), sqlType))
+			}
+		}
+	}
+	if len(alternatives) == 0 {
+		return "FALSE", nil
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", nil
+}
+
+// Values returns scan destinations for the cursor columns of one row
+func (k *Keyset) Values() []interface{} {
+	values := make([]interface{}, len(k.keys))
+	for i := range values {
+		values[i] = new(sql.NullString)
+	}
+	return values
+}
+
+// Cursor encodes the scanned cursor columns of a row
+func (k *Keyset) Cursor(values []interface{}) string {
+	cursor := cursorData{Order: signature(k.keys), Values: make([]*string, len(values))}
+	for i, v := range values {
+		if s, ok := v.(*sql.NullString); ok && s.Valid {
+			value := s.String
+			cursor.Values[i] = &value
+		}
+	}
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// Window returns the indexes of the fetched rows that make up the page, in
+// list order, and the page's position given the cursors of every fetched row
+func (k *Keyset) Window(cursors []string) ([]int, PageInfo) {
+	count := len(cursors)
+	more := count > k.size
+	if more {
+		count = k.size
+	}
+
+	indexes := make([]int, count)
+	for i := range indexes {
+		indexes[i] = i
+		if k.backward {
+			indexes[i] = count - 1 - i
+		}
+	}
+
+	// Rows exist on the far side of a cursor the page was taken from
+	info := PageInfo{HasNextPage: more || k.before, HasPreviousPage: k.after}
+	if k.backward {
+		info = PageInfo{HasNextPage: k.before, HasPreviousPage: more || k.after}
+	}
+	if count > 0 {
+		info.StartCursor = cursors[indexes[0]]
+		info.EndCursor = cursors[indexes[count-1]]
+	}
+	return indexes, info
+}
+
+// cursorData is the content of a cursor. Order ties it to the sort order it
+// was issued for.
+type cursorData struct {
+	Order  string    `json:"o"`
+	Values []*string `json:"v"`
+}
+
+func decodeCursor(keys []Sort, cursor string) ([]*string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var data cursorData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if data.Order != signature(keys) || len(data.Values) != len(keys) {
+		return nil, fmt.Errorf("%w: it was issued for a different sort order", ErrInvalidCursor)
+	}
+	return data.Values, nil
+}
+
+// signature identifies a sort order
+func signature(keys []Sort) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		name := key.Field
+		if name == "" {
+			name = "data." + key.Path
+		}
+		direction := "asc"
+		if key.Descending {
+			direction = "desc"
+		}
+		nulls := "last"
+		if key.nullsFirst() {
+			nulls = "first"
+		}
+		parts[i] = strings.Join([]string{name, direction, nulls, key.Collation}, ":")
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,215 @@
+package query
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// testKeys sorts by views, most viewed first, then by id
+func testKeys(t *testing.T) []Sort {
+	t.Helper()
+	keys := []Sort{{Path: "views", Descending: true}, {Field: "id"}}
+	schema := parseTestSchema(t)
+	for i := range keys {
+		if err := keys[i].Validate(schema); err != nil {
+			t.Fatalf("Validate: %v", err)
+		}
+		if err := keys[i].Resolve(map[string]string{"id": "integer"}); err != nil {
+			t.Fatalf("Resolve: %v", err)
+		}
+	}
+	return keys
+}
+
+// cursorFor returns the cursor of a row with the given sort key values
+func cursorFor(t *testing.T, keys []Sort, values ...*string) string {
+	t.Helper()
+	keyset, err := Page{}.Compile(keys, "data", nil)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	scanned := make([]interface{}, len(values))
+	for i, value := range values {
+		scanned[i] = &sql.NullString{}
+		if value != nil {
+			scanned[i] = &sql.NullString{String: *value, Valid: true}
+		}
+	}
+	return keyset.Cursor(scanned)
+}
+
+func str(s string) *string {
+	return &s
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	keys := testKeys(t)
+	tests := []struct {
+		name   string
+		values []*string
+	}{
+		{"values", []*string{str("42"), str("7")}},
+		{"null value", []*string{nil, str("7")}},
+		{"empty string", []*string{str(""), str("7")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(keys, cursorFor(t, keys, tt.values...))
+			if err != nil {
+				t.Fatalf("decodeCursor: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.values) {
+				t.Errorf("decodeCursor() = %v, want %v", got, tt.values)
+			}
+		})
+	}
+}
+
+func TestCursorRejected(t *testing.T) {
+	keys := testKeys(t)
+	cursor := cursorFor(t, keys, str("42"), str("7"))
+
+	ascending := testKeys(t)
+	ascending[0].Descending = false
+	idOnly := []Sort{keys[1]}
+
+	tests := []struct {
+		name   string
+		keys   []Sort
+		cursor string
+	}{
+		{"not base64", keys, "not a cursor!"},
+		{"not json", keys, "bm90IGpzb24"},
+		{"other direction", ascending, cursor},
+		{"other keys", idOnly, cursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Page{First: 10, After: tt.cursor}.Compile(tt.keys, "data", nil)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("Compile() error = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestPageCondition(t *testing.T) {
+	keys := testKeys(t)
+	views := func(n int) string { return fmt.Sprintf(viewsExpr, n, n) }
+
+	tests := []struct {
+		name     string
+		page     Page
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "first page",
+			page:     Page{First: 5},
+			wantSQL:  "TRUE",
+			wantArgs: []interface{}{`{"views"}`, `{"views"}`},
+		},
+		{
+			name: "after a row",
+			page: Page{First: 5, After: cursorFor(t, keys, str("42"), str("7"))},
+			wantSQL: "((" + views(1) + " < $2::numeric) OR (" +
+				views(1) + " = $3::numeric AND (id > $4::integer OR id IS NULL)))",
+			wantArgs: []interface{}{`{"views"}`, "42", "42", "7", `{"views"}`, `{"views"}`},
+		},
+		{
+			name: "after a row without the value",
+			page: Page{First: 5, After: cursorFor(t, keys, nil, str("7"))},
+			wantSQL: "((" + views(1) + " IS NOT NULL) OR (" +
+				views(1) + " IS NULL AND (id > $2::integer OR id IS NULL)))",
+			wantArgs: []interface{}{`{"views"}`, "7", `{"views"}`, `{"views"}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyset, err := tt.page.Compile(keys, "data", nil)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if keyset.Condition != tt.wantSQL {
+				t.Errorf("Condition:\n got %s\nwant %s", keyset.Condition, tt.wantSQL)
+			}
+			if got := sqlArgs(t, keyset.Args); !reflect.DeepEqual(got, tt.wantArgs) {
+				t.Errorf("args:\n got %#v\nwant %#v", got, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestPageWindow(t *testing.T) {
+	keys := testKeys(t)
+	cursor := cursorFor(t, keys, str("42"), str("7"))
+	fetched := []string{"a", "b", "c"}
+
+	tests := []struct {
+		name        string
+		page        Page
+		fetched     []string
+		wantIndexes []int
+		wantInfo    PageInfo
+	}{
+		{
+			name:        "forward with more rows",
+			page:        Page{First: 2},
+			fetched:     fetched,
+			wantIndexes: []int{0, 1},
+			wantInfo:    PageInfo{HasNextPage: true, StartCursor: "a", EndCursor: "b"},
+		},
+		{
+			name:        "forward last page after a cursor",
+			page:        Page{First: 5, After: cursor},
+			fetched:     fetched,
+			wantIndexes: []int{0, 1, 2},
+			wantInfo:    PageInfo{HasPreviousPage: true, StartCursor: "a", EndCursor: "c"},
+		},
+		{
+			name:        "backward is flipped",
+			page:        Page{Last: 2, Before: cursor},
+			fetched:     fetched,
+			wantIndexes: []int{1, 0},
+			wantInfo:    PageInfo{HasNextPage: true, HasPreviousPage: true, StartCursor: "b", EndCursor: "a"},
+		},
+		{
+			name:        "empty",
+			page:        Page{First: 2},
+			fetched:     nil,
+			wantIndexes: []int{},
+			wantInfo:    PageInfo{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyset, err := tt.page.Compile(keys, "data", nil)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			indexes, info := keyset.Window(tt.fetched)
+			if !reflect.DeepEqual(indexes, tt.wantIndexes) {
+				t.Errorf("indexes = %v, want %v", indexes, tt.wantIndexes)
+			}
+			if info != tt.wantInfo {
+				t.Errorf("info = %+v, want %+v", info, tt.wantInfo)
+			}
+		})
+	}
+}
+
+func TestPageLimits(t *testing.T) {
+	keys := testKeys(t)
+	for _, page := range []Page{{First: -1}, {First: 1, Last: 1}, {First: MaxPageSize + 1}} {
+		if _, err := page.Compile(keys, "data", nil); err == nil {
+			t.Errorf("Compile(%+v) succeeded", page)
+		}
+	}
+}
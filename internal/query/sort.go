@@ -0,0 +1,162 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"gofrik/internal/jsonschema"
+)
+
+// Null placements
+const (
+	NullsFirst = "FIRST"
+	NullsLast  = "LAST"
+)
+
+// MaxSortKeys bounds how many keys an ordering may have
+const MaxSortKeys = 5
+
+// collationPattern matches the collation names PostgreSQL ships, such as
+// "C", "en_US.utf8" and "de-DE-x-icu"
+var collationPattern = regexp.MustCompile(`^[A-Za-z0-9_.@-]{1,63}$`)
+
+// Sort is one key of an ordering: either a field stored in a table column or a
+// schema property path. Both may come from user input; fields only reach SQL
+// once Resolve has found them among the columns the caller allows.
+type Sort struct {
+	Field string
+	Path  string
+
+	Descending bool
+	// Nulls is NullsFirst, NullsLast or empty for PostgreSQL's default: last
+	// in ascending and first in descending order
+	Nulls string
+	// Collation orders text properties by the named collation instead of the
+	// database default
+	Collation string
+
+	// Set by Validate
+	field *Field
+	// Set by Resolve: the column of Field and its SQL type, which cursors are
+	// read as
+	column     string
+	columnType string
+}
+
+// Validate checks the sort key against a content type schema. It must be
+// called before SQL.
+func (s *Sort) Validate(schema *jsonschema.Schema) error {
+	if s.Nulls != "" && s.Nulls != NullsFirst && s.Nulls != NullsLast {
+		return fmt.Errorf("nulls must be %s or %s", NullsFirst, NullsLast)
+	}
+	if (s.Field == "") == (s.Path == "") {
+		return fmt.Errorf("sort key needs either a field or a path")
+	}
+	if s.Field != "" {
+		if s.Collation != "" {
+			return fmt.Errorf("collation only applies to text properties")
+		}
+		return nil
+	}
+
+	field, err := ResolveField(schema, s.Path)
+	if err != nil {
+		return err
+	}
+	if !field.Sortable() {
+		return fmt.Errorf("cannot sort by %s property %q", field.Kind, s.Path)
+	}
+	if s.Collation != "" {
+		if field.Kind != KindText {
+			return fmt.Errorf("collation only applies to text properties, %q is %s", s.Path, field.Kind)
+		}
+		if !collationPattern.MatchString(s.Collation) {
+			return fmt.Errorf("invalid collation %q", s.Collation)
+		}
+	}
+	s.field = field
+	return nil
+}
+
+// Resolve looks the key's field up in columns, which maps the fields that may
+// be sorted by to the SQL types of the columns of the same name. It must be
+// called before SQL for keys with a field and leaves path keys alone.
+func (s *Sort) Resolve(columns map[string]string) error {
+	if s.Field == "" {
+		return nil
+	}
+	sqlType, ok := columns[s.Field]
+	if !ok || sqlType == "" {
+		return fmt.Errorf("cannot sort by field %q", s.Field)
+	}
+	s.column, s.columnType = s.Field, sqlType
+	return nil
+}
+
+// Expression returns the value the key sorts by and its SQL type. Properties
+// are read from the JSONB column dataColumn.
+func (s *Sort) Expression(dataColumn string, param func(interface{}) string) (string, string, error) {
+	if s.Field != "" {
+		if s.column == "" {
+			return "", "", fmt.Errorf("sort by field %q was not resolved", s.Field)
+		}
+		return s.column, s.columnType, nil
+	}
+	if s.field == nil {
+		return "", "", fmt.Errorf("sort by %q was not validated", s.Path)
+	}
+	expr, sqlType := s.field.Expression(dataColumn, param)
+	if s.Collation != "" {
+		expr += " COLLATE " + pq.QuoteIdentifier(s.Collation)
+	}
+	return expr, sqlType, nil
+}
+
+// nullsFirst reports where the key places nulls
+func (s *Sort) nullsFirst() bool {
+	if s.Nulls == "" {
+		return s.Descending
+	}
+	return s.Nulls == NullsFirst
+}
+
+// reversed returns the key sorting the other way round, nulls included
+func (s Sort) reversed() Sort {
+	if s.nullsFirst() {
+		s.Nulls = NullsLast
+	} else {
+		s.Nulls = NullsFirst
+	}
+	s.Descending = !s.Descending
+	return s
+}
+
+// OrderBy compiles validated sort keys into the items of an ORDER BY clause.
+// Parameters are numbered after args, which is returned with the keys'
+// parameters appended.
+func OrderBy(keys []Sort, dataColumn string, args []interface{}) (string, []interface{}, error) {
+	param := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	items := make([]string, 0, len(keys))
+	for i := range keys {
+		expr, _, err := keys[i].Expression(dataColumn, param)
+		if err != nil {
+			return "", nil, err
+		}
+		item := expr + " ASC"
+		if keys[i].Descending {
+			item = expr + " DESC"
+		}
+		if keys[i].Nulls != "" {
+			item += " NULLS " + keys[i].Nulls
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, ", "), args, nil
+}
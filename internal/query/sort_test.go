@@ -0,0 +1,52 @@
+package query
+
+import "testing"
+
+func TestSortResolve(t *testing.T) {
+	columns := map[string]string{"id": "integer", "created_at": "timestamp"}
+	tests := []struct {
+		name     string
+		key      Sort
+		wantExpr string
+		wantType string
+		wantErr  bool
+	}{
+		{name: "known field", key: Sort{Field: "created_at"}, wantExpr: "created_at", wantType: "timestamp"},
+		{name: "unknown field", key: Sort{Field: "password_hash"}, wantErr: true},
+		{name: "injected field", key: Sort{Field: "id; DROP TABLE users"}, wantErr: true},
+		{name: "path keys are left alone", key: Sort{Path: "views"}, wantExpr: "(CASE WHEN jsonb_typeof(data #> $1::text[]) = 'number' THEN (data #>> $1::text[])::numeric END)", wantType: "numeric"},
+	}
+	schema := parseTestSchema(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.key
+			if err := key.Validate(schema); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			err := key.Resolve(columns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			expr, sqlType, err := key.Expression("data", func(interface{}) string { return "$1" })
+			if err != nil {
+				t.Fatalf("Expression: %v", err)
+			}
+			if expr != tt.wantExpr || sqlType != tt.wantType {
+				t.Errorf("Expression = %q, %q, want %q, %q", expr, sqlType, tt.wantExpr, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestSortExpressionRequiresResolve(t *testing.T) {
+	key := Sort{Field: "id"}
+	if err := key.Validate(parseTestSchema(t)); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if _, _, err := key.Expression("data", nil); err == nil {
+		t.Fatal("Expression of an unresolved field succeeded")
+	}
+}
@@ -0,0 +1,225 @@
+// Package reference reads the references between entries declared in content
+// type schemas.
+//
+// A top-level property is a reference when it has an "x-reference" keyword
+// naming the content types whose entries it may point to:
+//
+//	"author":     {"type": "integer", "x-reference": {"to": ["author"]}}
+//	"categories": {"type": "array", "items": {"type": "integer"},
+//	               "x-reference": {"to": ["category"], "onDelete": "setNull"}}
+//
+// Integer properties hold the id of one entry, arrays of integers the ids of
+// several. "onDelete" decides what deleting a referenced entry does:
+// "restrict" (the default) refuses, "cascade" deletes the referencing entry as
+// well and "setNull" removes the id, leaving null in single references.
+package reference
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gofrik/internal/jsonschema"
+)
+
+// Delete policies
+const (
+	Restrict = "restrict"
+	Cascade  = "cascade"
+	SetNull  = "setNull"
+)
+
+// Policies lists every delete policy
+var Policies = []string{Restrict, Cascade, SetNull}
+
+// Field is a reference property
+type Field struct {
+	Property string `json:"property"`
+	// To lists the slugs of the content types the property may point to
+	To []string `json:"to"`
+	// Multiple is set for arrays of references
+	Multiple bool   `json:"multiple"`
+	OnDelete string `json:"onDelete"`
+}
+
+// Allows reports whether the field may point to entries of a content type
+func (f Field) Allows(slug string) bool {
+	for _, to := range f.To {
+		if to == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// Link is an entry id held by a reference property
+type Link struct {
+	// Pointer is the JSON pointer to the id in the entry data
+	Pointer string
+	ID      int
+}
+
+// Links returns the entry ids the field holds in decoded entry data. Values
+// that are not integers are skipped; schema validation reports them.
+func (f Field) Links(data map[string]interface{}) []Link {
+	pointer := "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(f.Property)
+
+	var links []Link
+	switch value := data[f.Property].(type) {
+	case []interface{}:
+		for i, item := range value {
+			if id, ok := entryID(item); ok {
+				links = append(links, Link{Pointer: pointer + "/" + strconv.Itoa(i), ID: id})
+			}
+		}
+	default:
+		if id, ok := entryID(value); ok {
+			links = append(links, Link{Pointer: pointer, ID: id})
+		}
+	}
+	return links
+}
+
+// IDs returns the entry ids the field holds in decoded entry data, in order
+func (f Field) IDs(data map[string]interface{}) []int {
+	links := f.Links(data)
+	ids := make([]int, len(links))
+	for i, link := range links {
+		ids[i] = link.ID
+	}
+	return ids
+}
+
+func entryID(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), true
+		}
+	case json.Number:
+		if id, err := strconv.Atoi(v.String()); err == nil {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// rawSchema holds the parts of a schema document references are read from
+type rawSchema struct {
+	Properties map[string]json.RawMessage `json:"properties"`
+	Reference  json.RawMessage            `json:"x-reference"`
+}
+
+// rawReference is the value of an x-reference keyword
+type rawReference struct {
+	To       []string `json:"to"`
+	OnDelete string   `json:"onDelete"`
+}
+
+// FromSchema reads the reference properties of a content type schema, ordered
+// by property name
+func FromSchema(schema []byte) ([]Field, error) {
+	var root rawSchema
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return nil, fmt.Errorf("invalid schema JSON: %w", err)
+	}
+
+	fields := []Field{}
+	for name, raw := range root.Properties {
+		var prop rawSchema
+		if err := json.Unmarshal(raw, &prop); err != nil {
+			// Property schemas that are not objects are reported by schema validation
+			continue
+		}
+		if err := nestedReference(prop.Properties, name); err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(prop.Reference)) == 0 {
+			continue
+		}
+
+		field, err := parseField(name, raw, prop.Reference)
+		if err != nil {
+			return nil, fmt.Errorf("x-reference of %q: %w", name, err)
+		}
+		fields = append(fields, *field)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Property < fields[j].Property })
+	return fields, nil
+}
+
+// nestedReference rejects x-reference keywords below the top level
+func nestedReference(properties map[string]json.RawMessage, parent string) error {
+	for name, raw := range properties {
+		var prop rawSchema
+		if err := json.Unmarshal(raw, &prop); err != nil {
+			continue
+		}
+		path := parent + "." + name
+		if len(bytes.TrimSpace(prop.Reference)) > 0 {
+			return fmt.Errorf("x-reference of %q: only top-level properties can be references", path)
+		}
+		if err := nestedReference(prop.Properties, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseField(name string, schema, raw json.RawMessage) (*Field, error) {
+	var ref rawReference
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&ref); err != nil {
+		return nil, fmt.Errorf(`must be an object with "to" and an optional "onDelete"`)
+	}
+	if len(ref.To) == 0 {
+		return nil, fmt.Errorf(`"to" must name at least one content type`)
+	}
+	for _, slug := range ref.To {
+		if slug == "" {
+			return nil, fmt.Errorf(`"to" cannot contain empty slugs`)
+		}
+	}
+
+	field := &Field{Property: name, To: ref.To, OnDelete: ref.OnDelete}
+	if field.OnDelete == "" {
+		field.OnDelete = Restrict
+	}
+	if field.OnDelete != Restrict && field.OnDelete != Cascade && field.OnDelete != SetNull {
+		return nil, fmt.Errorf(`"onDelete" must be one of %s`, strings.Join(Policies, ", "))
+	}
+
+	prop, err := jsonschema.Parse(schema)
+	if err != nil {
+		// Invalid property schemas are reported by schema validation
+		return field, nil
+	}
+	switch {
+	case only(prop, "integer"):
+		if field.OnDelete == SetNull && !prop.HasType("null") {
+			return nil, fmt.Errorf(`"setNull" needs the property to allow null`)
+		}
+	case only(prop, "array") && prop.Items != nil && only(prop.Items, "integer"):
+		field.Multiple = true
+	default:
+		return nil, fmt.Errorf("the property must be an integer or an array of integers")
+	}
+	return field, nil
+}
+
+// only reports whether a schema allows one type, and maybe null
+func only(schema *jsonschema.Schema, typ string) bool {
+	if !schema.HasType(typ) {
+		return false
+	}
+	for _, t := range schema.Types {
+		if t != typ && t != "null" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package reference
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFromSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		want    []Field
+		wantErr string
+	}{
+		{
+			name:   "no references",
+			schema: `{"type": "object", "properties": {"title": {"type": "string"}}}`,
+			want:   []Field{},
+		},
+		{
+			name:   "single reference restricts by default",
+			schema: `{"properties": {"author": {"type": "integer", "x-reference": {"to": ["author"]}}}}`,
+			want:   []Field{{Property: "author", To: []string{"author"}, OnDelete: Restrict}},
+		},
+		{
+			name: "multiple reference",
+			schema: `{"properties": {"categories": {"type": "array", "items": {"type": "integer"},
+				"x-reference": {"to": ["category", "tag"], "onDelete": "cascade"}}}}`,
+			want: []Field{{Property: "categories", To: []string{"category", "tag"}, Multiple: true, OnDelete: Cascade}},
+		},
+		{
+			name: "fields are ordered by property name",
+			schema: `{"properties": {
+				"b": {"type": "integer", "x-reference": {"to": ["x"]}},
+				"a": {"type": "integer", "x-reference": {"to": ["y"]}}}}`,
+			want: []Field{
+				{Property: "a", To: []string{"y"}, OnDelete: Restrict},
+				{Property: "b", To: []string{"x"}, OnDelete: Restrict},
+			},
+		},
+		{
+			name:   "setNull on a nullable integer",
+			schema: `{"properties": {"author": {"type": ["integer", "null"], "x-reference": {"to": ["author"], "onDelete": "setNull"}}}}`,
+			want:   []Field{{Property: "author", To: []string{"author"}, OnDelete: SetNull}},
+		},
+		{
+			name: "setNull on an array",
+			schema: `{"properties": {"tags": {"type": "array", "items": {"type": "integer"},
+				"x-reference": {"to": ["tag"], "onDelete": "setNull"}}}}`,
+			want: []Field{{Property: "tags", To: []string{"tag"}, Multiple: true, OnDelete: SetNull}},
+		},
+		{
+			name:    "setNull needs null",
+			schema:  `{"properties": {"author": {"type": "integer", "x-reference": {"to": ["author"], "onDelete": "setNull"}}}}`,
+			wantErr: `"setNull" needs the property to allow null`,
+		},
+		{
+			name:    "string property",
+			schema:  `{"properties": {"author": {"type": "string", "x-reference": {"to": ["author"]}}}}`,
+			wantErr: "must be an integer or an array of integers",
+		},
+		{
+			name:    "integer or string",
+			schema:  `{"properties": {"author": {"type": ["integer", "string"], "x-reference": {"to": ["author"]}}}}`,
+			wantErr: "must be an integer or an array of integers",
+		},
+		{
+			name:    "untyped property",
+			schema:  `{"properties": {"author": {"x-reference": {"to": ["author"]}}}}`,
+			wantErr: "must be an integer or an array of integers",
+		},
+		{
+			name:    "array of strings",
+			schema:  `{"properties": {"tags": {"type": "array", "items": {"type": "string"}, "x-reference": {"to": ["tag"]}}}}`,
+			wantErr: "must be an integer or an array of integers",
+		},
+		{
+			name:    "array without items",
+			schema:  `{"properties": {"tags": {"type": "array", "x-reference": {"to": ["tag"]}}}}`,
+			wantErr: "must be an integer or an array of integers",
+		},
+		{
+			name:    "missing to",
+			schema:  `{"properties": {"author": {"type": "integer", "x-reference": {}}}}`,
+			wantErr: `"to" must name at least one content type`,
+		},
+		{
+			name:    "empty slug",
+			schema:  `{"properties": {"author": {"type": "integer", "x-reference": {"to": [""]}}}}`,
+			wantErr: `"to" cannot contain empty slugs`,
+		},
+		{
+			name:    "unknown policy",
+			schema:  `{"properties": {"author": {"type": "integer", "x-reference": {"to": ["author"], "onDelete": "ignore"}}}}`,
+			wantErr: `"onDelete" must be one of restrict, cascade, setNull`,
+		},
+		{
+			name:    "unknown keyword",
+			schema:  `{"properties": {"author": {"type": "integer", "x-reference": {"to": ["author"], "on": "x"}}}}`,
+			wantErr: `must be an object with "to"`,
+		},
+		{
+			name:    "not an object",
+			schema:  `{"properties": {"author": {"type": "integer", "x-reference": ["author"]}}}`,
+			wantErr: `must be an object with "to"`,
+		},
+		{
+			name: "nested reference",
+			schema: `{"properties": {"meta": {"type": "object", "properties": {
+				"author": {"type": "integer", "x-reference": {"to": ["author"]}}}}}}`,
+			wantErr: `x-reference of "meta.author": only top-level properties can be references`,
+		},
+		{
+			name: "deeply nested reference",
+			schema: `{"properties": {"a": {"properties": {"b": {"properties": {
+				"c": {"type": "integer", "x-reference": {"to": ["author"]}}}}}}}}`,
+			wantErr: `x-reference of "a.b.c"`,
+		},
+		{
+			name:   "property schemas that are not objects are skipped",
+			schema: `{"properties": {"anything": true, "nothing": false}}`,
+			want:   []Field{},
+		},
+		{
+			name:    "invalid JSON",
+			schema:  `{"properties": `,
+			wantErr: "invalid schema JSON",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromSchema([]byte(tt.schema))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("FromSchema error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromSchema: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromSchema = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldLinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		field Field
+		data  string
+		want  []Link
+	}{
+		{
+			name:  "single",
+			field: Field{Property: "author"},
+			data:  `{"author": 7}`,
+			want:  []Link{{Pointer: "/author", ID: 7}},
+		},
+		{
+			name:  "null",
+			field: Field{Property: "author"},
+			data:  `{"author": null}`,
+		},
+		{
+			name:  "missing",
+			field: Field{Property: "author"},
+			data:  `{}`,
+		},
+		{
+			name:  "multiple skips values that are not integers",
+			field: Field{Property: "tags", Multiple: true},
+			data:  `{"tags": [1, "2", 3.5, 4]}`,
+			want:  []Link{{Pointer: "/tags/0", ID: 1}, {Pointer: "/tags/3", ID: 4}},
+		},
+		{
+			name:  "pointer escapes",
+			field: Field{Property: "a/b~c"},
+			data:  `{"a/b~c": 3}`,
+			want:  []Link{{Pointer: "/a~1b~0c", ID: 3}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.data), &data); err != nil {
+				t.Fatalf("decode data: %v", err)
+			}
+			if got := tt.field.Links(data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Links = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Package scheduler publishes and unpublishes content entries at their scheduled times.
+//
+// The schedule lives in the content_entries table, so nothing is lost on restart:
+// entries that fell due while no scheduler was running are handled on the next
+// pass. Due rows are claimed with SELECT ... FOR UPDATE SKIP LOCKED, so any number
+// of replicas can run a scheduler at the same time.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"gofrik/internal/models"
+)
+
+// batchSize limits how many entries a single statement transitions
+const batchSize = 100
+
+type Scheduler struct {
+	db       *sql.DB
+	interval time.Duration
+	logger   *log.Logger
+}
+
+func New(db *sql.DB, interval time.Duration, logger *log.Logger) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// Run makes due transitions right away and then every interval until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce publishes and then unpublishes every entry that is due. Entries of
+// content types with a workflow must take one of its transitions.
+func (s *Scheduler) RunOnce(ctx context.Context) {
+	s.drain(ctx, "published", models.PublishDueEntries)
+	s.drain(ctx, "published", models.PublishDueWorkflowEntries)
+	s.drain(ctx, "unpublished", models.UnpublishDueEntries)
+	s.drain(ctx, "unpublished", models.UnpublishDueWorkflowEntries)
+}
+
+// drain repeats a transition in batches until no due entries are left
+func (s *Scheduler) drain(ctx context.Context, action string, transition func(*sql.DB, time.Time, int) (int, error)) {
+	total := 0
+	for ctx.Err() == nil {
+		n, err := transition(s.db, time.Now().UTC(), batchSize)
+		if err != nil {
+			s.logger.Printf("Scheduler: %v", err)
+			return
+		}
+		total += n
+		if n < batchSize {
+			break
+		}
+	}
+	if total > 0 {
+		s.logger.Printf("Scheduler: %s %d scheduled entries", action, total)
+	}
+}
@@ -0,0 +1,166 @@
+// Package search reads the full-text search settings declared in content type
+// schemas.
+//
+// A property is searchable when it has an "x-search" keyword: a weight from
+// "A" (most important) to "D", or true for "D". Strings anywhere below a
+// searchable object or array are indexed with its weight. A top-level
+// "x-search-language" locale such as "en" or "de-AT" picks the PostgreSQL text
+// search configuration that stems words; without one, words are indexed as
+// they are written.
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultConfig indexes words without language-specific stemming or stop words
+const DefaultConfig = "simple"
+
+// configs maps language subtags to the text search configurations PostgreSQL ships
+var configs = map[string]string{
+	"ar": "arabic",
+	"ca": "catalan",
+	"da": "danish",
+	"de": "german",
+	"el": "greek",
+	"en": "english",
+	"es": "spanish",
+	"eu": "basque",
+	"fi": "finnish",
+	"fr": "french",
+	"ga": "irish",
+	"hi": "hindi",
+	"hu": "hungarian",
+	"hy": "armenian",
+	"id": "indonesian",
+	"it": "italian",
+	"lt": "lithuanian",
+	"nb": "norwegian",
+	"ne": "nepali",
+	"nl": "dutch",
+	"nn": "norwegian",
+	"no": "norwegian",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sr": "serbian",
+	"sv": "swedish",
+	"ta": "tamil",
+	"tr": "turkish",
+	"yi": "yiddish",
+}
+
+// ConfigForLocale returns the text search configuration of a locale such as
+// "en" or "pt-BR"
+func ConfigForLocale(locale string) (string, error) {
+	language := strings.ToLower(strings.SplitN(strings.ReplaceAll(locale, "_", "-"), "-", 2)[0])
+	config, ok := configs[language]
+	if !ok {
+		return "", fmt.Errorf("unsupported search locale %q", locale)
+	}
+	return config, nil
+}
+
+// Field is a searchable property and its weight
+type Field struct {
+	Path   []string `json:"path"`
+	Weight string   `json:"weight"`
+}
+
+// Settings are the search settings of a content type
+type Settings struct {
+	Config string
+	Fields []Field
+}
+
+// rawSchema holds the parts of a schema document search settings are read from
+type rawSchema struct {
+	Properties map[string]json.RawMessage `json:"properties"`
+	Search     json.RawMessage            `json:"x-search"`
+	Language   string                     `json:"x-search-language"`
+}
+
+// FromSchema reads the search settings of a content type schema. Fields are
+// ordered by weight, most important first, then by path.
+func FromSchema(schema []byte) (*Settings, error) {
+	var root rawSchema
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return nil, fmt.Errorf("invalid schema JSON: %w", err)
+	}
+
+	settings := &Settings{Config: DefaultConfig, Fields: []Field{}}
+	if root.Language != "" {
+		config, err := ConfigForLocale(root.Language)
+		if err != nil {
+			return nil, fmt.Errorf("x-search-language: %w", err)
+		}
+		settings.Config = config
+	}
+
+	if err := collectFields(root.Properties, nil, &settings.Fields); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(settings.Fields, func(i, j int) bool {
+		if settings.Fields[i].Weight != settings.Fields[j].Weight {
+			return settings.Fields[i].Weight < settings.Fields[j].Weight
+		}
+		return strings.Join(settings.Fields[i].Path, ".") < strings.Join(settings.Fields[j].Path, ".")
+	})
+	return settings, nil
+}
+
+// collectFields adds the searchable properties below parent. Properties inside
+// a searchable property are covered by it.
+func collectFields(properties map[string]json.RawMessage, parent []string, fields *[]Field) error {
+	for name, raw := range properties {
+		var prop rawSchema
+		if err := json.Unmarshal(raw, &prop); err != nil {
+			// Property schemas that are not objects are reported by schema validation
+			continue
+		}
+		path := append(append([]string{}, parent...), name)
+
+		weight, err := parseWeight(prop.Search)
+		if err != nil {
+			return fmt.Errorf("x-search of %q: %w", strings.Join(path, "."), err)
+		}
+		if weight != "" {
+			*fields = append(*fields, Field{Path: path, Weight: weight})
+			continue
+		}
+		if err := collectFields(prop.Properties, path, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseWeight reads an x-search value; it returns "" when the property is not
+// searchable
+func parseWeight(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return "", nil
+	}
+
+	var enabled bool
+	if err := json.Unmarshal(raw, &enabled); err == nil {
+		if enabled {
+			return "D", nil
+		}
+		return "", nil
+	}
+
+	var weight string
+	if err := json.Unmarshal(raw, &weight); err == nil {
+		switch strings.ToUpper(weight) {
+		case "A", "B", "C", "D":
+			return strings.ToUpper(weight), nil
+		}
+	}
+	return "", fmt.Errorf("must be true, false or a weight from A to D")
+}
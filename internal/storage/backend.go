@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrNotFound is returned by backends when no file has a key
+var ErrNotFound = errors.New("file not found")
+
+// Object describes a stored file
+type Object struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	// ETag identifies the content, where the backend provides one
+	ETag string
+}
+
+// Backend stores files under keys. Keys are slash separated paths without a
+// leading slash, such as "1700000000-ab12cd34-photo.jpg".
+type Backend interface {
+	// Put stores a file, replacing any file with the same key
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get opens a file; callers must close it
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (*Object, error)
+	// List returns the files whose keys start with prefix, ordered by key
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// URL returns the public URL of a key
+	URL(key string) string
+}
+
+// PrivatePutter is implemented by backends whose files are publicly readable,
+// to store files that only the server reads
+type PrivatePutter interface {
+	// PutPrivate stores a file like Put, without making it public
+	PutPrivate(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+}
+
+// PutPrivate stores a file that is only read through the server, such as an
+// upload tail or a cached image variant
+func PutPrivate(ctx context.Context, backend Backend, key string, body io.Reader, size int64, contentType string) error {
+	if private, ok := backend.(PrivatePutter); ok {
+		return private.PutPrivate(ctx, key, body, size, contentType)
+	}
+	return backend.Put(ctx, key, body, size, contentType)
+}
+
+// ErrChanged is returned by Presigner.Publish when the file no longer has
+// the expected ETag
+var ErrChanged = errors.New("file changed")
+
+// Presigner is implemented by backends that clients can upload files to
+// directly, without streaming them through the server
+type Presigner interface {
+	// PresignPut returns a URL, and the headers to send with it, for a PUT
+	// request storing exactly size bytes of contentType under key until ttl
+	// has passed. The stored file is private.
+	PresignPut(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (string, http.Header, error)
+	// Publish copies the file at src to dst, publicly readable, and deletes
+	// src. It fails with ErrChanged unless src still has the ETag etag.
+	Publish(ctx context.Context, src, dst, etag string) error
+}
+
+// Part is a stored part of a multipart upload
+type Part struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// Multipart is implemented by backends that assemble a file from parts
+// uploaded one at a time. Every part but the last must be at least 5MB.
+type Multipart interface {
+	// CreateMultipart starts a multipart upload of key and returns its id
+	CreateMultipart(ctx context.Context, key, contentType string) (string, error)
+	// UploadPart stores part number (from 1) and returns its ETag
+	UploadPart(ctx context.Context, key, uploadID string, number int, body io.Reader, size int64) (string, error)
+	// CompleteMultipart assembles the parts, in order, into the file
+	CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error
+	// AbortMultipart discards the parts
+	AbortMultipart(ctx context.Context, key, uploadID string) error
+}
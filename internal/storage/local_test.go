@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestBackend(t *testing.T) *LocalBackend {
+	t.Helper()
+	backend, err := NewLocalBackend(t.TempDir(), "http://localhost:8080/files/")
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	return backend
+}
+
+func putString(t *testing.T, backend *LocalBackend, key, body string) {
+	t.Helper()
+	if err := backend.Put(context.Background(), key, strings.NewReader(body), int64(len(body)), "text/plain"); err != nil {
+		t.Fatalf("Put(%q): %v", key, err)
+	}
+}
+
+func TestLocalBackendKeys(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{key: "a.txt", valid: true},
+		{key: "2024/01/a.txt", valid: true},
+		{key: "_staging/a.txt", valid: true},
+		{key: ""},
+		{key: "/etc/passwd"},
+		{key: "../a.txt"},
+		{key: "a/../../a.txt"},
+		{key: "a/../b.txt"},
+		{key: "./a.txt"},
+		{key: "a/./b.txt"},
+		{key: "a//b.txt"},
+		{key: "a/"},
+		{key: ".."},
+		{key: ".hidden"},
+		{key: "a/.tmp-123"},
+		{key: ".multipart/x/1"},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			backend := newTestBackend(t)
+
+			err := backend.Put(ctx, tt.key, strings.NewReader("x"), 1, "text/plain")
+			if (err == nil) != tt.valid {
+				t.Fatalf("Put error = %v, want valid %v", err, tt.valid)
+			}
+			if tt.valid {
+				return
+			}
+			if _, _, err := backend.Get(ctx, tt.key); err == nil || errors.Is(err, ErrNotFound) {
+				t.Errorf("Get error = %v, want an invalid key error", err)
+			}
+			if _, err := backend.Stat(ctx, tt.key); err == nil || errors.Is(err, ErrNotFound) {
+				t.Errorf("Stat error = %v, want an invalid key error", err)
+			}
+			if err := backend.Delete(ctx, tt.key); err == nil {
+				t.Error("Delete succeeded")
+			}
+			if _, err := backend.CreateMultipart(ctx, tt.key, "text/plain"); err == nil {
+				t.Error("CreateMultipart succeeded")
+			}
+		})
+	}
+}
+
+func TestLocalBackendStaysInDirectory(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "files")
+	backend, err := NewLocalBackend(dir, "")
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := backend.Get(context.Background(), "../secret"); err == nil {
+		t.Fatal("Get read a file outside the storage directory")
+	}
+	if err := backend.Delete(context.Background(), "../secret"); err == nil {
+		t.Fatal("Delete accepted a key outside the storage directory")
+	}
+	if _, err := os.Stat(filepath.Join(parent, "secret")); err != nil {
+		t.Fatalf("file outside the storage directory: %v", err)
+	}
+}
+
+func TestLocalBackendRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestBackend(t)
+	putString(t, backend, "docs/a.txt", "hello")
+	putString(t, backend, "docs/b.txt", "world!")
+
+	body, object, err := backend.Get(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != "hello" || object.Size != 5 || !strings.HasPrefix(object.ContentType, "text/plain") {
+		t.Errorf("Get = %q, %+v", data, object)
+	}
+	if got, want := backend.URL("docs/a.txt"), "http://localhost:8080/files/docs/a.txt"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+
+	// Directories and the backend's own files are not objects
+	if _, err := backend.Stat(ctx, "docs"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat of a directory error = %v, want ErrNotFound", err)
+	}
+	if _, err := backend.CreateMultipart(ctx, "docs/c.txt", "text/plain"); err != nil {
+		t.Fatalf("CreateMultipart: %v", err)
+	}
+	objects, err := backend.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	if got := strings.Join(keys, ","); got != "docs/a.txt,docs/b.txt" {
+		t.Errorf("List = %s, want docs/a.txt,docs/b.txt", got)
+	}
+
+	if err := backend.Delete(ctx, "docs/a.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := backend.Delete(ctx, "docs/a.txt"); err != nil {
+		t.Errorf("Delete of a missing file: %v", err)
+	}
+	if _, _, err := backend.Get(ctx, "docs/a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete error = %v, want ErrNotFound", err)
+	}
+}
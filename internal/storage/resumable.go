@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"image"
+	"io"
+	"strings"
+)
+
+// MaxResumableSize is the largest file that can be uploaded in chunks (2GB)
+const MaxResumableSize = 2 << 30
+
+// resumablePartSize is the size of the parts resumable uploads are stored
+// in; S3 needs every part but the last to be at least 5MB
+const resumablePartSize = 8 << 20
+
+// resumableTailPrefix is the key prefix of the bytes received for resumable
+// uploads that do not fill a part yet
+const resumableTailPrefix = "_uploads/"
+
+// ErrResumableUnsupported is returned when the backend cannot store files in parts
+var ErrResumableUnsupported = errors.New("the storage provider does not support resumable uploads")
+
+// resumableTypes are the content types accepted by resumable uploads, in
+// addition to images
+var resumableTypes = []string{
+	"application/pdf",
+	"video/mp4",
+	"video/quicktime",
+	"video/webm",
+}
+
+// Resumable is the progress of a file uploaded in chunks. Callers persist it
+// between chunks, so that an upload can continue on any server after a
+// disconnect.
+type Resumable struct {
+	Key         string
+	MultipartID string
+	ContentType string
+	Size        int64
+	// Offset is the number of bytes received so far
+	Offset int64
+	// Parts are the stored parts; TailSize bytes received after them are
+	// kept aside until they fill a part or end the file
+	Parts    []Part
+	TailSize int64
+	// HashState is the marshalled SHA-256 of the bytes in Parts
+	HashState []byte
+}
+
+// Complete reports whether every byte of the file has been received
+func (u *Resumable) Complete() bool {
+	return u.Offset == u.Size
+}
+
+// checkResumable enforces the policy of resumable uploads: images, videos and
+// PDFs of at most 2GB
+func checkResumable(contentType string, size int64) error {
+	if size <= 0 {
+		return fmt.Errorf("size must be positive")
+	}
+	if size > MaxResumableSize {
+		return fmt.Errorf("file too large: maximum size is 2GB")
+	}
+
+	if isValidImageType(contentType) {
+		return nil
+	}
+	for _, validType := range resumableTypes {
+		if contentType == validType {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid file type: only images, videos (mp4, webm, mov) and PDFs are allowed")
+}
+
+// StartResumable checks a file about to be uploaded in chunks against the
+// upload policy and starts a multipart upload under a new key
+func (s *Storage) StartResumable(ctx context.Context, filename, contentType string, size int64) (*Resumable, error) {
+	multipart, ok := s.backend.(Multipart)
+	if !ok {
+		return nil, ErrResumableUnsupported
+	}
+	if err := checkResumable(contentType, size); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUploadRejected, err)
+	}
+
+	key := generateUniqueFilename(filename)
+	multipartID, err := multipart.CreateMultipart(ctx, key, contentType)
+	if err != nil {
+		return nil, err
+	}
+	return &Resumable{
+		Key:         key,
+		MultipartID: multipartID,
+		ContentType: contentType,
+		Size:        size,
+	}, nil
+}
+
+// WriteResumable appends the chunk in body to an upload. Full parts are
+// stored as soon as they are received and the rest is kept as the tail, so
+// that a chunk of any size, or one cut short by a disconnect, loses nothing.
+// save is called with the new progress after each write to the backend; the
+// upload must not be written to concurrently. Bytes beyond the declared
+// size are not read.
+func (s *Storage) WriteResumable(ctx context.Context, upload *Resumable, body io.Reader, save func(*Resumable) error) error {
+	multipart, ok := s.backend.(Multipart)
+	if !ok {
+		return ErrResumableUnsupported
+	}
+
+	digest, err := restoreHash(upload.HashState)
+	if err != nil {
+		return err
+	}
+
+	stored := upload.Offset - upload.TailSize
+	reader := body
+	if upload.TailSize > 0 {
+		tail, object, err := s.backend.Get(ctx, tailKey(upload.Key))
+		if err != nil {
+			return fmt.Errorf("failed to read upload tail: %w", err)
+		}
+		defer tail.Close()
+		if object.Size != upload.TailSize {
+			return fmt.Errorf("failed to read upload tail: expected %d bytes, found %d", upload.TailSize, object.Size)
+		}
+		reader = io.MultiReader(io.LimitReader(tail, upload.TailSize), body)
+	}
+	reader = io.LimitReader(reader, upload.Size-stored)
+
+	buf := make([]byte, resumablePartSize)
+	for stored < upload.Size {
+		n, readErr := io.ReadFull(reader, buf)
+		chunk := buf[:n]
+
+		switch {
+		case n == len(buf) || (n > 0 && stored+int64(n) == upload.Size):
+			number := len(upload.Parts) + 1
+			etag, err := multipart.UploadPart(ctx, upload.Key, upload.MultipartID, number, bytes.NewReader(chunk), int64(n))
+			if err != nil {
+				return err
+			}
+			digest.Write(chunk)
+			state, err := digest.(encoding.BinaryMarshaler).MarshalBinary()
+			if err != nil {
+				return fmt.Errorf("failed to save checksum: %w", err)
+			}
+
+			stored += int64(n)
+			upload.Parts = append(upload.Parts, Part{Number: number, ETag: etag, Size: int64(n)})
+			upload.Offset = stored
+			upload.TailSize = 0
+			upload.HashState = state
+			if err := save(upload); err != nil {
+				return err
+			}
+		case int64(n) > upload.TailSize:
+			// The chunk ended, or the connection dropped, before a part filled
+			if err := PutPrivate(ctx, s.backend, tailKey(upload.Key), bytes.NewReader(chunk), int64(n), "application/octet-stream"); err != nil {
+				return fmt.Errorf("failed to store upload tail: %w", err)
+			}
+			upload.Offset = stored + int64(n)
+			upload.TailSize = int64(n)
+			if err := save(upload); err != nil {
+				return err
+			}
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	return nil
+}
+
+// FinishResumable assembles a completely received upload into the file and
+// describes it like UploadFile does
+func (s *Storage) FinishResumable(ctx context.Context, upload *Resumable) (*UploadedFile, error) {
+	multipart, ok := s.backend.(Multipart)
+	if !ok {
+		return nil, ErrResumableUnsupported
+	}
+	if !upload.Complete() || upload.TailSize > 0 {
+		return nil, fmt.Errorf("upload is incomplete: received %d of %d bytes", upload.Offset, upload.Size)
+	}
+
+	digest, err := restoreHash(upload.HashState)
+	if err != nil {
+		return nil, err
+	}
+	if err := multipart.CompleteMultipart(ctx, upload.Key, upload.MultipartID, upload.Parts); err != nil {
+		return nil, err
+	}
+	s.backend.Delete(ctx, tailKey(upload.Key))
+
+	uploaded := &UploadedFile{
+		Key:         upload.Key,
+		URL:         s.backend.URL(upload.Key),
+		ContentType: upload.ContentType,
+		Size:        upload.Size,
+		Checksum:    hex.EncodeToString(digest.Sum(nil)),
+	}
+
+	// Image dimensions are in the header, so only the start is read
+	if strings.HasPrefix(upload.ContentType, "image/") {
+		body, _, err := s.backend.Get(ctx, upload.Key)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		if config, _, err := image.DecodeConfig(body); err == nil {
+			uploaded.Width = &config.Width
+			uploaded.Height = &config.Height
+		}
+	}
+	return uploaded, nil
+}
+
+// AbortResumable discards everything received for an upload
+func (s *Storage) AbortResumable(ctx context.Context, upload *Resumable) error {
+	multipart, ok := s.backend.(Multipart)
+	if !ok {
+		return ErrResumableUnsupported
+	}
+	if err := multipart.AbortMultipart(ctx, upload.Key, upload.MultipartID); err != nil {
+		return err
+	}
+	return s.backend.Delete(ctx, tailKey(upload.Key))
+}
+
+// tailKey returns the key the tail of the upload of key is kept under
+func tailKey(key string) string {
+	return resumableTailPrefix + key
+}
+
+// restoreHash returns a SHA-256 resumed from a marshalled state, or a new
+// one when the state is empty
+func restoreHash(state []byte) (hash.Hash, error) {
+	digest := sha256.New()
+	if len(state) == 0 {
+		return digest, nil
+	}
+	if err := digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, fmt.Errorf("failed to restore checksum: %w", err)
+	}
+	return digest, nil
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testData returns n bytes that differ from part to part
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+// startTestUpload starts a resumable upload of size bytes on a local backend
+func startTestUpload(t *testing.T, size int64) (*Storage, *Resumable) {
+	t.Helper()
+	s := &Storage{backend: newTestBackend(t)}
+	upload, err := s.StartResumable(context.Background(), "video.mp4", "video/mp4", size)
+	if err != nil {
+		t.Fatalf("StartResumable: %v", err)
+	}
+	return s, upload
+}
+
+// writeChunk appends chunk to the upload, returning the progress saved after each write
+func writeChunk(t *testing.T, s *Storage, upload *Resumable, chunk io.Reader) ([]Resumable, error) {
+	t.Helper()
+	var saved []Resumable
+	err := s.WriteResumable(context.Background(), upload, chunk, func(progress *Resumable) error {
+		saved = append(saved, *progress)
+		return nil
+	})
+	return saved, err
+}
+
+func TestWriteResumable(t *testing.T) {
+	size := 2*resumablePartSize + 100
+	data := testData(size)
+
+	tests := []struct {
+		name   string
+		chunks []int
+		// wantParts are the part counts after each chunk
+		wantParts []int
+	}{
+		{name: "one chunk", chunks: []int{size}, wantParts: []int{3}},
+		{name: "chunks smaller than a part", chunks: []int{100, resumablePartSize - 100, resumablePartSize / 2, resumablePartSize / 2, 100}, wantParts: []int{0, 1, 1, 2, 3}},
+		{name: "empty chunk", chunks: []int{100, 0, size - 100}, wantParts: []int{0, 0, 3}},
+		{name: "chunks larger than a part", chunks: []int{resumablePartSize + 10, resumablePartSize + 90}, wantParts: []int{1, 3}},
+		{name: "part boundaries", chunks: []int{resumablePartSize, resumablePartSize, 100}, wantParts: []int{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, upload := startTestUpload(t, int64(size))
+
+			offset := 0
+			for i, n := range tt.chunks {
+				if _, err := writeChunk(t, s, upload, bytes.NewReader(data[offset:offset+n])); err != nil {
+					t.Fatalf("chunk %d: WriteResumable: %v", i, err)
+				}
+				offset += n
+
+				if upload.Offset != int64(offset) {
+					t.Errorf("chunk %d: Offset = %d, want %d", i, upload.Offset, offset)
+				}
+				if len(upload.Parts) != tt.wantParts[i] {
+					t.Errorf("chunk %d: %d parts, want %d", i, len(upload.Parts), tt.wantParts[i])
+				}
+				if stored := int64(len(upload.Parts)) * resumablePartSize; upload.Offset < int64(size) && upload.TailSize != upload.Offset-stored {
+					t.Errorf("chunk %d: TailSize = %d, want %d", i, upload.TailSize, upload.Offset-stored)
+				}
+			}
+
+			uploaded, err := s.FinishResumable(context.Background(), upload)
+			if err != nil {
+				t.Fatalf("FinishResumable: %v", err)
+			}
+			sum := sha256.Sum256(data)
+			if uploaded.Checksum != hex.EncodeToString(sum[:]) || uploaded.Size != int64(size) {
+				t.Errorf("FinishResumable = %+v, want the checksum and size of the data", uploaded)
+			}
+
+			body, _, err := s.backend.Get(context.Background(), upload.Key)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			got, err := io.ReadAll(body)
+			body.Close()
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Error("assembled file differs from the uploaded data")
+			}
+			if _, err := s.backend.Stat(context.Background(), tailKey(upload.Key)); !errors.Is(err, ErrNotFound) {
+				t.Errorf("tail after FinishResumable: %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestWriteResumableSavesProgress(t *testing.T) {
+	s, upload := startTestUpload(t, 2*resumablePartSize)
+	data := testData(2 * resumablePartSize)
+
+	// A part and a tail are each saved once they are stored
+	saved, err := writeChunk(t, s, upload, bytes.NewReader(data[:resumablePartSize+5]))
+	if err != nil {
+		t.Fatalf("WriteResumable: %v", err)
+	}
+	if len(saved) != 2 || saved[0].Offset != resumablePartSize || saved[0].TailSize != 0 ||
+		saved[1].Offset != resumablePartSize+5 || saved[1].TailSize != 5 {
+		t.Errorf("saved progress = %+v", saved)
+	}
+
+	// Bytes beyond the declared size are not read
+	rest := bytes.NewReader(append(data[resumablePartSize+5:], "extra"...))
+	if _, err := writeChunk(t, s, upload, rest); err != nil {
+		t.Fatalf("WriteResumable: %v", err)
+	}
+	if !upload.Complete() || upload.TailSize != 0 || rest.Len() != len("extra") {
+		t.Errorf("after the last chunk: Offset %d, TailSize %d, %d bytes unread", upload.Offset, upload.TailSize, rest.Len())
+	}
+}
+
+func TestWriteResumableInterrupted(t *testing.T) {
+	s, upload := startTestUpload(t, resumablePartSize)
+	data := testData(resumablePartSize)
+
+	// What arrived before the connection dropped is kept as the tail
+	cut := io.MultiReader(bytes.NewReader(data[:10]), &failingReader{err: io.ErrUnexpectedEOF})
+	if _, err := writeChunk(t, s, upload, cut); err != nil {
+		t.Fatalf("WriteResumable: %v", err)
+	}
+	if upload.Offset != 10 || upload.TailSize != 10 {
+		t.Errorf("Offset %d, TailSize %d, want 10 and 10", upload.Offset, upload.TailSize)
+	}
+
+	// Other read errors are returned after saving what was received
+	broken := errors.New("broken")
+	cut = io.MultiReader(bytes.NewReader(data[10:20]), &failingReader{err: broken})
+	if _, err := writeChunk(t, s, upload, cut); !errors.Is(err, broken) {
+		t.Fatalf("WriteResumable error = %v, want %v", err, broken)
+	}
+	if upload.Offset != 20 || upload.TailSize != 20 {
+		t.Errorf("Offset %d, TailSize %d, want 20 and 20", upload.Offset, upload.TailSize)
+	}
+
+	if _, err := s.FinishResumable(context.Background(), upload); err == nil {
+		t.Error("FinishResumable of an incomplete upload succeeded")
+	}
+}
+
+func TestWriteResumableSaveError(t *testing.T) {
+	s, upload := startTestUpload(t, 2*resumablePartSize)
+	data := testData(2 * resumablePartSize)
+
+	// A failed save stops the chunk before anything else is written
+	locked := errors.New("locked")
+	calls := 0
+	err := s.WriteResumable(context.Background(), upload, bytes.NewReader(data), func(*Resumable) error {
+		calls++
+		return locked
+	})
+	if !errors.Is(err, locked) || calls != 1 {
+		t.Fatalf("WriteResumable error = %v after %d saves, want %v after 1", err, calls, locked)
+	}
+	if len(upload.Parts) != 1 {
+		t.Errorf("%d parts stored, want 1", len(upload.Parts))
+	}
+}
+
+func TestWriteResumableMissingTail(t *testing.T) {
+	s, upload := startTestUpload(t, resumablePartSize)
+	if _, err := writeChunk(t, s, upload, bytes.NewReader(testData(10))); err != nil {
+		t.Fatalf("WriteResumable: %v", err)
+	}
+	if err := s.backend.Delete(context.Background(), tailKey(upload.Key)); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := writeChunk(t, s, upload, bytes.NewReader(testData(10))); err == nil {
+		t.Fatal("WriteResumable succeeded without the stored tail")
+	}
+}
+
+// failingReader fails every read with err
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
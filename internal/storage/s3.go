@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3Backend stores files in a bucket of S3 or an S3-compatible service
+type S3Backend struct {
+	config *Config
+	client *s3.Client
+}
+
+// NewS3Backend creates a backend for the bucket of cfg
+func NewS3Backend(cfg *Config) (*S3Backend, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("storage bucket is required")
+	}
+
+	// Create AWS config
+	var awsCfg aws.Config
+	var err error
+
+	if cfg.AccessKeyID != "" && cfg.SecretAccessKey != "" {
+		// Use static credentials
+		awsCfg, err = config.LoadDefaultConfig(context.TODO(),
+			config.WithRegion(cfg.Region),
+			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+				cfg.AccessKeyID,
+				cfg.SecretAccessKey,
+				"",
+			)),
+		)
+	} else {
+		// Use default credential chain (IAM roles, env vars, etc.)
+		awsCfg, err = config.LoadDefaultConfig(context.TODO(),
+			config.WithRegion(cfg.Region),
+		)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	// Create S3 client with optional custom endpoint
+	var s3Client *s3.Client
+	if cfg.Endpoint != "" {
+		// Custom endpoint for S3-compatible services
+		s3Client = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true // Required for MinIO and some other S3-compatible services
+		})
+	} else {
+		s3Client = s3.NewFromConfig(awsCfg)
+	}
+
+	return &S3Backend{
+		config: cfg,
+		client: s3Client,
+	}, nil
+}
+
+func (b *S3Backend) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	return b.put(ctx, key, body, size, contentType, types.ObjectCannedACLPublicRead)
+}
+
+// PutPrivate stores a file that is only readable with the bucket's credentials
+func (b *S3Backend) PutPrivate(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	return b.put(ctx, key, body, size, contentType, types.ObjectCannedACLPrivate)
+}
+
+func (b *S3Backend) put(ctx context.Context, key string, body io.Reader, size int64, contentType string, acl types.ObjectCannedACL) error {
+	_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(b.config.Bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String(contentType),
+		ACL:           acl,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
+// PresignPut signs the content type, length and private ACL of the upload,
+// so the bucket rejects requests that differ
+func (b *S3Backend) PresignPut(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (string, http.Header, error) {
+	request, err := s3.NewPresignClient(b.client).PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(b.config.Bucket),
+		Key:           aws.String(key),
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String(contentType),
+		ACL:           "private",
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to presign upload: %w", err)
+	}
+
+	// Clients set Host and Content-Length themselves
+	headers := request.SignedHeader.Clone()
+	headers.Del("Host")
+	headers.Del("Content-Length")
+	return request.URL, headers, nil
+}
+
+func (b *S3Backend) Publish(ctx context.Context, src, dst, etag string) error {
+	_, err := b.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(b.config.Bucket),
+		Key:               aws.String(dst),
+		CopySource:        aws.String(b.config.Bucket + "/" + url.PathEscape(src)),
+		CopySourceIfMatch: aws.String(etag),
+		ACL:               "public-read",
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed" {
+			return ErrChanged
+		}
+		if isS3NotFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to publish file: %w", err)
+	}
+	return b.Delete(ctx, src)
+}
+
+func (b *S3Backend) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	output, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	return output.Body, &Object{
+		Key:         key,
+		Size:        aws.ToInt64(output.ContentLength),
+		ContentType: aws.ToString(output.ContentType),
+		ModTime:     aws.ToTime(output.LastModified),
+		ETag:        aws.ToString(output.ETag),
+	}, nil
+}
+
+func (b *S3Backend) Delete(ctx context.Context, key string) error {
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (b *S3Backend) Stat(ctx context.Context, key string) (*Object, error) {
+	output, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	return &Object{
+		Key:         key,
+		Size:        aws.ToInt64(output.ContentLength),
+		ContentType: aws.ToString(output.ContentType),
+		ModTime:     aws.ToTime(output.LastModified),
+		ETag:        aws.ToString(output.ETag),
+	}, nil
+}
+
+func (b *S3Backend) List(ctx context.Context, prefix string) ([]Object, error) {
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.config.Bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	// S3 lists keys in ascending order
+	var objects []Object
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
+		for _, item := range page.Contents {
+			objects = append(objects, Object{
+				Key:     aws.ToString(item.Key),
+				Size:    aws.ToInt64(item.Size),
+				ModTime: aws.ToTime(item.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (b *S3Backend) CreateMultipart(ctx context.Context, key, contentType string) (string, error) {
+	output, err := b.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(b.config.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		ACL:         "public-read",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	return aws.ToString(output.UploadId), nil
+}
+
+func (b *S3Backend) UploadPart(ctx context.Context, key, uploadID string, number int, body io.Reader, size int64) (string, error) {
+	output, err := b.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(b.config.Bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(int32(number)),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", number, err)
+	}
+	return aws.ToString(output.ETag), nil
+}
+
+func (b *S3Backend) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	completed := make([]types.CompletedPart, len(parts))
+	for i, part := range parts {
+		completed[i] = types.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int32(int32(part.Number)),
+		}
+	}
+
+	_, err := b.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.config.Bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+func (b *S3Backend) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	_, err := b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(b.config.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		var noSuchUpload *types.NoSuchUpload
+		if errors.As(err, &noSuchUpload) {
+			return nil
+		}
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
+
+// URL returns the public URL of a key
+func (b *S3Backend) URL(key string) string {
+	// If custom public URL is provided, use it
+	if b.config.PublicURL != "" {
+		return fmt.Sprintf("%s/%s", strings.TrimRight(b.config.PublicURL, "/"), key)
+	}
+
+	// If custom endpoint is provided (MinIO, DigitalOcean, etc.)
+	if b.config.Endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", strings.TrimRight(b.config.Endpoint, "/"), b.config.Bucket, key)
+	}
+
+	// Default S3 URL format
+	if b.config.Region == "us-east-1" {
+		return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", b.config.Bucket, key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", b.config.Bucket, b.config.Region, key)
+}
+
+// isS3NotFound reports whether an S3 error means the key does not exist
+func isS3NotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound)
+}
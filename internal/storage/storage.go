@@ -2,168 +2,365 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
 	"mime/multipart"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
+	_ "golang.org/x/image/webp"
 )
 
 // Config holds the storage configuration
 type Config struct {
-	Provider        string // "s3", "gcs", "minio", etc. (all use S3 API)
+	Provider        string // "local", or "s3", "gcs", "minio", etc. (all use S3 API)
 	Bucket          string
 	Region          string
 	AccessKeyID     string
 	SecretAccessKey string
 	Endpoint        string // Custom endpoint for S3-compatible services (MinIO, DigitalOcean, etc.)
 	PublicURL       string // Public URL base for accessing files (e.g., CDN URL)
+	LocalDir        string // Directory the local provider stores files in
 }
 
-// Storage handles file uploads to S3-compatible storage
+// Configured reports whether enough settings are present to enable storage
+func (c *Config) Configured() bool {
+	return c.Provider == "local" || c.Bucket != ""
+}
+
+// Storage handles file uploads to a storage backend
 type Storage struct {
-	config *Config
-	client *s3.Client
+	config  *Config
+	backend Backend
 }
 
-// NewStorage creates a new storage instance
+// NewStorage creates a new storage instance with the backend of cfg.Provider
 func NewStorage(cfg *Config) (*Storage, error) {
-	if cfg.Bucket == "" {
-		return nil, fmt.Errorf("storage bucket is required")
-	}
-
-	// Create AWS config
-	var awsCfg aws.Config
+	var backend Backend
 	var err error
-
-	if cfg.AccessKeyID != "" && cfg.SecretAccessKey != "" {
-		// Use static credentials
-		awsCfg, err = config.LoadDefaultConfig(context.TODO(),
-			config.WithRegion(cfg.Region),
-			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-				cfg.AccessKeyID,
-				cfg.SecretAccessKey,
-				"",
-			)),
-		)
+	if cfg.Provider == "local" {
+		// Files are served by the /files/ handler unless a CDN is in front
+		publicURL := cfg.PublicURL
+		if publicURL == "" {
+			publicURL = "/files"
+		}
+		backend, err = NewLocalBackend(cfg.LocalDir, publicURL)
 	} else {
-		// Use default credential chain (IAM roles, env vars, etc.)
-		awsCfg, err = config.LoadDefaultConfig(context.TODO(),
-			config.WithRegion(cfg.Region),
-		)
+		backend, err = NewS3Backend(cfg)
 	}
-
 	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS config: %w", err)
-	}
-
-	// Create S3 client with optional custom endpoint
-	var s3Client *s3.Client
-	if cfg.Endpoint != "" {
-		// Custom endpoint for S3-compatible services
-		s3Client = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
-			o.BaseEndpoint = aws.String(cfg.Endpoint)
-			o.UsePathStyle = true // Required for MinIO and some other S3-compatible services
-		})
-	} else {
-		s3Client = s3.NewFromConfig(awsCfg)
+		return nil, err
 	}
 
 	return &Storage{
-		config: cfg,
-		client: s3Client,
+		config:  cfg,
+		backend: backend,
 	}, nil
 }
 
-// UploadFile uploads a file to S3-compatible storage and returns the public URL
-func (s *Storage) UploadFile(ctx context.Context, file multipart.File, header *multipart.FileHeader) (string, error) {
+// Backend returns the backend files are stored in
+func (s *Storage) Backend() Backend {
+	return s.backend
+}
+
+// UploadedFile describes a file stored by UploadFile
+type UploadedFile struct {
+	Key         string
+	URL         string
+	ContentType string
+	Size        int64
+	// Checksum is the hex encoded SHA-256 of the content
+	Checksum string
+	// Width and Height are set for raster images (GIF, JPEG, PNG and WebP)
+	Width  *int
+	Height *int
+}
+
+// maxFileSize is the largest file that can be uploaded (10MB)
+const maxFileSize = 10 * 1024 * 1024
+
+// directUploadTTL is how long presigned upload URLs stay valid
+const directUploadTTL = 15 * time.Minute
+
+// ErrDirectUploadsUnsupported is returned when the backend cannot presign uploads
+var ErrDirectUploadsUnsupported = errors.New("direct uploads need an S3-compatible storage provider")
+
+// ErrUploadRejected is returned when a directly uploaded file breaks the
+// upload policy or differs from what was declared, and when a file about to
+// be uploaded in chunks breaks the policy
+var ErrUploadRejected = errors.New("upload rejected")
+
+// checkUpload enforces the upload policy: images of at most 10MB
+func checkUpload(contentType string, size int64) error {
 	// Validate file size (max 10MB)
-	const maxFileSize = 10 * 1024 * 1024
-	if header.Size > maxFileSize {
-		return "", fmt.Errorf("file too large: maximum size is 10MB")
+	if size > maxFileSize {
+		return fmt.Errorf("file too large: maximum size is 10MB")
 	}
 
 	// Validate file type
-	contentType := header.Header.Get("Content-Type")
 	if !isValidImageType(contentType) {
-		return "", fmt.Errorf("invalid file type: only images are allowed (jpg, jpeg, png, gif, webp, svg)")
+		return fmt.Errorf("invalid file type: only images are allowed (jpg, jpeg, png, gif, webp, svg)")
+	}
+	return nil
+}
+
+// UploadFile uploads a file to the storage backend and returns where it is
+// stored and what it contains
+func (s *Storage) UploadFile(ctx context.Context, file multipart.File, header *multipart.FileHeader) (*UploadedFile, error) {
+	contentType := header.Header.Get("Content-Type")
+	if err := checkUpload(contentType, header.Size); err != nil {
+		return nil, err
 	}
 
+	uploaded, err := inspectFile(file)
+	if err != nil {
+		return nil, err
+	}
+	uploaded.ContentType = contentType
+
 	// Generate unique filename
 	filename := generateUniqueFilename(header.Filename)
 
-	// Create the upload input
-	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(s.config.Bucket),
-		Key:         aws.String(filename),
-		Body:        file,
-		ContentType: aws.String(contentType),
-		ACL:         "public-read", // Make file publicly accessible
-	})
+	if err := s.backend.Put(ctx, filename, file, uploaded.Size, contentType); err != nil {
+		return nil, err
+	}
+
+	uploaded.Key = filename
+	uploaded.URL = s.backend.URL(filename)
+	return uploaded, nil
+}
+
+// DirectUpload is a presigned request uploading one file straight to the backend
+type DirectUpload struct {
+	Key string
+	URL string
+	// Headers must be sent with the PUT request
+	Headers   http.Header
+	ExpiresAt time.Time
+}
+
+// stagingPrefix is the key prefix direct uploads are stored under, privately,
+// until they are verified
+const stagingPrefix = "_staging/"
+
+// stagingKey returns the key the direct upload of key is stored under until
+// it is verified
+func stagingKey(key string) string {
+	return stagingPrefix + key
+}
+
+// CreateUploadURL checks a file about to be uploaded against the upload
+// policy and presigns its upload to a private staging key. The file is
+// published under the returned key once VerifyUpload has checked it.
+func (s *Storage) CreateUploadURL(ctx context.Context, filename, contentType string, size int64, now time.Time) (*DirectUpload, error) {
+	presigner, ok := s.backend.(Presigner)
+	if !ok {
+		return nil, ErrDirectUploadsUnsupported
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("size must be positive")
+	}
+	if err := checkUpload(contentType, size); err != nil {
+		return nil, err
+	}
 
+	key := generateUniqueFilename(filename)
+	url, headers, err := presigner.PresignPut(ctx, stagingKey(key), contentType, size, directUploadTTL)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload file: %w", err)
+		return nil, err
+	}
+	return &DirectUpload{
+		Key:       key,
+		URL:       url,
+		Headers:   headers,
+		ExpiresAt: now.Add(directUploadTTL),
+	}, nil
+}
+
+// VerifyUpload checks a file uploaded through CreateUploadURL against the
+// upload policy and the declared content type and size, inspects it and
+// publishes exactly the inspected content under key. It returns ErrNotFound
+// while the file has not been uploaded and wraps ErrUploadRejected when the
+// file must be discarded.
+func (s *Storage) VerifyUpload(ctx context.Context, key, contentType string, size int64) (*UploadedFile, error) {
+	presigner, ok := s.backend.(Presigner)
+	if !ok {
+		return nil, ErrDirectUploadsUnsupported
 	}
 
-	// Generate public URL
-	url := s.getPublicURL(filename)
+	uploaded, etag, err := s.verify(ctx, stagingKey(key), contentType, size)
+	if errors.Is(err, ErrNotFound) {
+		// An earlier call may have published the file without recording it
+		uploaded, _, err = s.verify(ctx, key, contentType, size)
+	} else if err == nil {
+		// The presigned URL stays valid, so only publish what was inspected
+		err = presigner.Publish(ctx, stagingKey(key), key, etag)
+		if errors.Is(err, ErrChanged) {
+			err = fmt.Errorf("%w: the file changed while it was verified", ErrUploadRejected)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	return url, nil
+	uploaded.Key = key
+	uploaded.URL = s.backend.URL(key)
+	return uploaded, nil
 }
 
-// DeleteFile deletes a file from storage
-func (s *Storage) DeleteFile(ctx context.Context, url string) error {
-	// Extract filename from URL
-	filename := s.extractFilenameFromURL(url)
-	if filename == "" {
-		return fmt.Errorf("invalid URL")
+// verify checks the file stored under key against the upload policy and
+// the declared content type and size, and inspects it. It also returns the
+// ETag of the inspected content. The metadata is checked with Stat first so
+// that rejected files are never downloaded; the body of accepted files is
+// read, as assets record their checksum and image dimensions.
+func (s *Storage) verify(ctx context.Context, key, contentType string, size int64) (*UploadedFile, string, error) {
+	object, err := s.backend.Stat(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkUpload(object.ContentType, object.Size); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUploadRejected, err)
+	}
+	if object.ContentType != contentType || object.Size != size {
+		return nil, "", fmt.Errorf("%w: the file is not the declared %s of %d bytes", ErrUploadRejected, contentType, size)
 	}
 
-	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
-		Bucket: aws.String(s.config.Bucket),
-		Key:    aws.String(filename),
-	})
+	body, object, err := s.backend.Get(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	defer body.Close()
+	if object.ContentType != contentType || object.Size != size {
+		return nil, "", fmt.Errorf("%w: the file changed while it was verified", ErrUploadRejected)
+	}
 
+	uploaded, err := inspect(io.LimitReader(body, size+1))
 	if err != nil {
-		return fmt.Errorf("failed to delete file: %w", err)
+		return nil, "", err
+	}
+	if uploaded.Size != size {
+		return nil, "", fmt.Errorf("%w: the file changed while it was verified", ErrUploadRejected)
 	}
+	uploaded.ContentType = contentType
+	return uploaded, object.ETag, nil
+}
 
-	return nil
+// DiscardUpload deletes a direct upload, whether or not it was published
+func (s *Storage) DiscardUpload(ctx context.Context, key string) error {
+	if err := s.backend.Delete(ctx, stagingKey(key)); err != nil {
+		return err
+	}
+	return s.backend.Delete(ctx, key)
 }
 
-// getPublicURL generates the public URL for a file
-func (s *Storage) getPublicURL(filename string) string {
-	// If custom public URL is provided, use it
-	if s.config.PublicURL != "" {
-		return fmt.Sprintf("%s/%s", strings.TrimRight(s.config.PublicURL, "/"), filename)
+// inspectFile reads a file's size, checksum and image dimensions and rewinds it
+func inspectFile(file io.ReadSeeker) (*UploadedFile, error) {
+	uploaded, err := inspect(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	return uploaded, nil
+}
 
-	// If custom endpoint is provided (MinIO, DigitalOcean, etc.)
-	if s.config.Endpoint != "" {
-		return fmt.Sprintf("%s/%s/%s", strings.TrimRight(s.config.Endpoint, "/"), s.config.Bucket, filename)
+// inspect reads a file's size, checksum and image dimensions in one pass
+func inspect(r io.Reader) (*UploadedFile, error) {
+	hash := sha256.New()
+	var size byteCounter
+	tee := io.TeeReader(r, io.MultiWriter(hash, &size))
+
+	config, _, decodeErr := image.DecodeConfig(tee)
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	// Default S3 URL format
-	if s.config.Region == "us-east-1" {
-		return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", s.config.Bucket, filename)
+	uploaded := &UploadedFile{Size: int64(size), Checksum: hex.EncodeToString(hash.Sum(nil))}
+	if decodeErr == nil {
+		uploaded.Width = &config.Width
+		uploaded.Height = &config.Height
 	}
-	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.config.Bucket, s.config.Region, filename)
+	return uploaded, nil
 }
 
-// extractFilenameFromURL extracts the filename from a public URL
-func (s *Storage) extractFilenameFromURL(url string) string {
-	parts := strings.Split(url, "/")
-	if len(parts) > 0 {
-		return parts[len(parts)-1]
+// byteCounter counts the bytes written to it
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// URL returns the public URL of a stored file
+func (s *Storage) URL(key string) string {
+	return s.backend.URL(key)
+}
+
+// variantsPrefix is the key prefix of derived versions of stored files, such
+// as resized images
+const variantsPrefix = "_variants/"
+
+// VariantKey returns the key of a derived version of the file stored under key
+func VariantKey(key, name string) string {
+	return variantsPrefix + key + "/" + name
+}
+
+// IsInternalKey reports whether key holds a file the server keeps for itself:
+// a direct upload awaiting verification, part of a resumable upload or a
+// cached variant. Such files are not served as they are.
+func IsInternalKey(key string) bool {
+	key = strings.TrimLeft(key, "/")
+	for _, prefix := range []string{stagingPrefix, resumableTailPrefix, variantsPrefix} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteVariants deletes every derived version of the file stored under key
+func (s *Storage) DeleteVariants(ctx context.Context, key string) error {
+	variants, err := s.backend.List(ctx, variantsPrefix+key+"/")
+	if err != nil {
+		return err
+	}
+	for _, variant := range variants {
+		if err := s.backend.Delete(ctx, variant.Key); err != nil {
+			return err
+		}
 	}
-	return ""
+	return nil
+}
+
+// DeleteFile deletes a file from storage
+func (s *Storage) DeleteFile(ctx context.Context, url string) error {
+	// Extract the key from the URL
+	key := s.keyFromURL(url)
+	if key == "" {
+		return fmt.Errorf("invalid URL")
+	}
+	return s.backend.Delete(ctx, key)
+}
+
+// keyFromURL extracts the key from a public URL
+func (s *Storage) keyFromURL(url string) string {
+	if base := s.backend.URL(""); strings.HasPrefix(url, base) {
+		return strings.TrimPrefix(url, base)
+	}
+	parts := strings.Split(url, "/")
+	return parts[len(parts)-1]
 }
 
 // isValidImageType checks if the content type is a valid image type
@@ -204,6 +401,11 @@ func generateUniqueFilename(originalFilename string) string {
 	if len(baseName) > 50 {
 		baseName = baseName[:50]
 	}
+
+	// Drop extensions too long to be real, which would make the key too long
+	if len(ext) > 16 {
+		ext = ""
+	}
 	
 	return fmt.Sprintf("%d-%s-%s%s", timestamp, uniqueID, baseName, ext)
 }
@@ -223,6 +425,7 @@ func LoadConfigFromEnv() *Config {
 		SecretAccessKey: os.Getenv("STORAGE_SECRET_ACCESS_KEY"),
 		Endpoint:        os.Getenv("STORAGE_ENDPOINT"),
 		PublicURL:       os.Getenv("STORAGE_PUBLIC_URL"),
+		LocalDir:        getEnvOrDefault("STORAGE_LOCAL_DIR", "./uploads"),
 	}
 }
 
@@ -14,7 +14,9 @@ import (
 	"time"
 
 	"gofrik/internal/api"
+	"gofrik/internal/auth"
 	"gofrik/internal/database"
+	"gofrik/internal/scheduler"
 	"gofrik/internal/storage"
 )
 
@@ -58,23 +60,51 @@ func run(
 	// Initialize storage (if configured)
 	var storageClient *storage.Storage
 	storageConfig := storage.LoadConfigFromEnv()
-	if storageConfig.Bucket != "" {
+	if storageConfig.Configured() {
 		storageClient, err = storage.NewStorage(storageConfig)
 		if err != nil {
 			logger.Printf("Warning: Failed to initialize storage: %v", err)
 			logger.Printf("File upload will not be available")
+		} else if storageConfig.Provider == "local" {
+			logger.Printf("Storage initialized: local (directory: %s)", storageConfig.LocalDir)
 		} else {
 			logger.Printf("Storage initialized: %s (bucket: %s)", storageConfig.Provider, storageConfig.Bucket)
 		}
 	} else {
-		logger.Printf("Storage not configured. Set STORAGE_BUCKET to enable file uploads")
+		logger.Printf("Storage not configured. Set STORAGE_BUCKET or STORAGE_PROVIDER=local to enable file uploads")
 	}
 
+	// Initialize session store
+	var sessionStore auth.Store
+	switch config.SessionStore {
+	case "postgres":
+		sessionStore = auth.NewPostgresStore(db)
+	case "memory":
+		sessionStore = auth.NewMemoryStore()
+		logger.Printf("Warning: in-memory sessions are lost on restart and not shared between replicas")
+	default:
+		return fmt.Errorf("unknown session store %q (use postgres or memory)", config.SessionStore)
+	}
+	authMW := auth.NewMiddleware(sessionStore)
+
+	// Periodically delete expired sessions
+	go authMW.RunCleanup(ctx, time.Hour, logger)
+
+	// Periodically discard abandoned uploads
+	if storageClient != nil {
+		go api.RunUploadCleanup(ctx, db, storageClient, time.Hour, logger)
+	}
+
+	// Publish and unpublish scheduled entries
+	go scheduler.New(db, 15*time.Second, logger).Run(ctx)
+
 	// Create server with all dependencies
 	srv, err := api.NewServer(
+		ctx,
 		config,
 		db,
 		storageClient,
+		authMW,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create server: %w", err)
@@ -94,6 +124,7 @@ func run(
 		logger.Printf("Health check: http://localhost:%s/health", config.Port)
 		if storageClient != nil {
 			logger.Printf("Upload endpoint: http://localhost:%s/upload", config.Port)
+			logger.Printf("Resumable upload endpoint: http://localhost:%s/uploads/", config.Port)
 		}
 
 		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {